@@ -8,6 +8,7 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"math/big"
 	"strings"
 
 	"github.com/emb/play/monkey/token"
@@ -55,29 +56,110 @@ func (p *Program) String() string {
 	return buf.String()
 }
 
-// LetStmt describes a Let statement.
+// LetStmt describes a let or a const statement. Either Name is bound
+// to the value or the value is destructured by Pattern.
 type LetStmt struct {
-	Token token.Token
-	Name  *Identifier
-	Value Expression
+	Token   token.Token
+	Name    *Identifier
+	Pattern Pattern
+	Value   Expression
+	// Comments preceding the statement, only kept by parsers
+	// reading from lexer.NewWithComments.
+	Comments []token.Token
 }
 
 // TokenLiteral returns the token literal underlying let statement.
 func (l *LetStmt) TokenLiteral() string { return l.Token.Literal }
 
+// Const reports whether the statement is a const declaration, whose
+// bindings can not be rebound.
+func (l *LetStmt) Const() bool { return l.Token.Type == token.CONST }
+
 // String reconstructs let statement into valid code.
 func (l *LetStmt) String() string {
 	if l == nil {
 		return ""
 	}
+	if l.Pattern != nil {
+		return fmt.Sprintf("%s %s = %s;", l.TokenLiteral(), l.Pattern, l.Value)
+	}
 	return fmt.Sprintf("%s %s = %s;", l.TokenLiteral(), l.Name, l.Value)
 }
 
+// Pattern describes the left hand side of a destructuring let.
+type Pattern interface {
+	Node
+	// Names returns the identifiers bound by the pattern.
+	Names() []*Identifier
+}
+
+// ArrayPattern destructures an array such as let [a, b, ...rest] = x;
+type ArrayPattern struct {
+	// Token holds the `[`
+	Token    token.Token
+	Elements []*Identifier
+	Rest     *Identifier
+}
+
+// TokenLiteral returns the `[` literal
+func (a *ArrayPattern) TokenLiteral() string { return a.Token.Literal }
+
+// Names returns the identifiers bound by the pattern.
+func (a *ArrayPattern) Names() []*Identifier {
+	if a.Rest != nil {
+		return append(a.Elements[:len(a.Elements):len(a.Elements)], a.Rest)
+	}
+	return a.Elements
+}
+
+// String returns a string representation of the pattern
+func (a *ArrayPattern) String() string {
+	es := make([]string, len(a.Elements))
+	for i, e := range a.Elements {
+		es[i] = e.String()
+	}
+	if a.Rest != nil {
+		es = append(es, "..."+a.Rest.String())
+	}
+	return "[" + strings.Join(es, ", ") + "]"
+}
+
+// HashPattern destructures a hash by string keys such as let {x, y} = h;
+type HashPattern struct {
+	// Token holds the `{`
+	Token token.Token
+	Keys  []*Identifier
+}
+
+// TokenLiteral returns the `{` literal
+func (h *HashPattern) TokenLiteral() string { return h.Token.Literal }
+
+// Names returns the identifiers bound by the pattern.
+func (h *HashPattern) Names() []*Identifier { return h.Keys }
+
+// String returns a string representation of the pattern
+func (h *HashPattern) String() string {
+	ks := make([]string, len(h.Keys))
+	for i, k := range h.Keys {
+		ks[i] = k.String()
+	}
+	return "{" + strings.Join(ks, ", ") + "}"
+}
+
 // Identifier describes user defined names used during variable
 // bindings in the language.
 type Identifier struct {
 	Token token.Token
 	Value string
+
+	// Local is set when the identifier is resolved to a parameter
+	// or let binding of an enclosing function or block. Depth is
+	// the number of environments to walk out and Slot the index
+	// of the binding within that environment. Other identifiers
+	// are globals or builtins looked up by name.
+	Local bool `json:"-"`
+	Depth int  `json:"-"`
+	Slot  int  `json:"-"`
 }
 
 // TokenLiteral returns the literal value of an identifier token.
@@ -93,8 +175,9 @@ func (i *Identifier) String() string {
 
 // ReturnStmt describes a Return statement.
 type ReturnStmt struct {
-	Token token.Token
-	Value Expression
+	Token    token.Token
+	Value    Expression
+	Comments []token.Token
 }
 
 // TokenLiteral returns the token literal underlying return statement.
@@ -111,12 +194,14 @@ func (r *ReturnStmt) String() string {
 // ExpressionStmt describes an Expression statement. Unlike the main two
 // statements of the language this is a wrapper. Since the following
 // code is valid Monkey code.
-// 	let x = 4
+//
+//	let x = 4
 //	x + 3
 type ExpressionStmt struct {
 	// Token stores the first token of an expression.
 	Token      token.Token
 	Expression Expression
+	Comments   []token.Token
 }
 
 // TokenLiteral returns the literal value of the first token in an
@@ -131,10 +216,13 @@ func (e *ExpressionStmt) String() string {
 	return e.Expression.String()
 }
 
-// IntegerLiteral describes an integer in the Monkey language
+// IntegerLiteral describes an integer in the Monkey language. Big
+// holds the value of a literal that does not fit in Value, it is nil
+// otherwise.
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int
 }
 
 // TokenLiteral returns the literal value of IntegerLiteral
@@ -155,6 +243,33 @@ func (s *StringLiteral) TokenLiteral() string { return s.Token.Literal }
 // String returns a string representation of StringLiteral
 func (s *StringLiteral) String() string { return s.Token.Literal }
 
+// TemplateLiteral describes an interpolated string such as
+// "hello ${name}". Parts are string literals for the text and the
+// interpolated expressions in order.
+type TemplateLiteral struct {
+	Token token.Token
+	Parts []Expression
+}
+
+// TokenLiteral returns the raw template string
+func (t *TemplateLiteral) TokenLiteral() string { return t.Token.Literal }
+
+// String returns a string representation of TemplateLiteral
+func (t *TemplateLiteral) String() string {
+	if t == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	for _, p := range t.Parts {
+		if s, ok := p.(*StringLiteral); ok {
+			buf.WriteString(s.Value)
+			continue
+		}
+		fmt.Fprintf(&buf, "${%s}", p)
+	}
+	return buf.String()
+}
+
 // ArrayLiteral describes an array within the language
 type ArrayLiteral struct {
 	// Token stores `[` token
@@ -181,11 +296,18 @@ func (a *ArrayLiteral) String() string {
 	return buf.String()
 }
 
+// HashPair describes a key value pair within a hash literal.
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
 // HashLiteral describes hashes in monkey
 type HashLiteral struct {
 	// Token stores the `{`
 	Token token.Token
-	Pairs map[Expression]Expression
+	// Pairs are kept in source order.
+	Pairs []HashPair
 }
 
 // TokenLiteral returns the `{` literal
@@ -197,9 +319,9 @@ func (h *HashLiteral) String() string {
 		return ""
 	}
 	var buf bytes.Buffer
-	pairs := []string{}
-	for k, v := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s:%s", k, v))
+	pairs := make([]string, len(h.Pairs))
+	for i, p := range h.Pairs {
+		pairs[i] = fmt.Sprintf("%s:%s", p.Key, p.Value)
 	}
 	buf.WriteByte('{')
 	buf.WriteString(strings.Join(pairs, ", "))
@@ -223,6 +345,48 @@ func (i *IndexExpr) String() string {
 	return fmt.Sprintf("(%s[%s])", i.Left, i.Index)
 }
 
+// SliceExpr describes an expression of the form myarray[1:3], either
+// bound may be left out.
+type SliceExpr struct {
+	// Token is the `[`
+	Token token.Token
+	Left  Expression
+	Start Expression
+	End   Expression
+}
+
+// TokenLiteral return the literal token `[`
+func (s *SliceExpr) TokenLiteral() string { return s.Token.Literal }
+
+// String returns a string representation of a slice expression
+func (s *SliceExpr) String() string {
+	var start, end string
+	if s.Start != nil {
+		start = s.Start.String()
+	}
+	if s.End != nil {
+		end = s.End.String()
+	}
+	return fmt.Sprintf("(%s[%s:%s])", s.Left, start, end)
+}
+
+// MemberExpr describes accessing a member of a module such as
+// os.getenv, or the function of a method call such as a.push(1)
+type MemberExpr struct {
+	// Token is the `.`
+	Token  token.Token
+	Left   Expression
+	Member *Identifier
+}
+
+// TokenLiteral return the literal token `.`
+func (m *MemberExpr) TokenLiteral() string { return m.Token.Literal }
+
+// String returns a string representation of a member expression
+func (m *MemberExpr) String() string {
+	return fmt.Sprintf("%s.%s", m.Left, m.Member)
+}
+
 // PrefixExpr describes a prefix expressions of form -5.
 type PrefixExpr struct {
 	// Token describes the prefix token; ! or -
@@ -309,7 +473,18 @@ type FunctionLiteral struct {
 	// Token holds the `fn` string
 	Token      token.Token
 	Parameters []*Identifier
-	Body       *BlockStmt
+	// Defaults holds the default value of each parameter, nil for
+	// required parameters.
+	Defaults []Expression
+	// Rest collects extra arguments of a variadic function.
+	Rest *Identifier
+	Body *BlockStmt
+	// Locals is the number of parameters and let bindings in the
+	// function, resolved by the parser.
+	Locals int `json:"-"`
+	// Generator is set by the parser for functions containing a
+	// yield, their last slot holds the state of the generator.
+	Generator bool `json:"-"`
 }
 
 // TokenLiteral returns a string representing the fn token.
@@ -321,10 +496,7 @@ func (f *FunctionLiteral) String() string {
 		return ""
 	}
 	var buf bytes.Buffer
-	params := make([]string, len(f.Parameters))
-	for i, p := range f.Parameters {
-		params[i] = p.String()
-	}
+	params := ParamStrings(f.Parameters, f.Defaults, f.Rest)
 	buf.WriteString(f.TokenLiteral())
 	buf.WriteByte('(')
 	buf.WriteString(strings.Join(params, ", "))
@@ -333,12 +505,65 @@ func (f *FunctionLiteral) String() string {
 	return buf.String()
 }
 
+// ParamStrings returns a string representation of each parameter of
+// a function.
+func ParamStrings(params []*Identifier, defaults []Expression, rest *Identifier) []string {
+	ps := make([]string, len(params))
+	for i, p := range params {
+		ps[i] = p.String()
+		if i < len(defaults) && defaults[i] != nil {
+			ps[i] += " = " + defaults[i].String()
+		}
+	}
+	if rest != nil {
+		ps = append(ps, "..."+rest.String())
+	}
+	return ps
+}
+
+// SpreadExpr describes expanding an array into the arguments of a call
+// or the elements of an array literal, such as f(...args).
+type SpreadExpr struct {
+	// Token holds the `...`
+	Token token.Token
+	Value Expression
+}
+
+// TokenLiteral returns the `...` literal
+func (s *SpreadExpr) TokenLiteral() string { return s.Token.Literal }
+
+// String returns a string representation of the spread
+func (s *SpreadExpr) String() string { return "..." + s.Value.String() }
+
+// YieldExpr describes producing a value from a generator, a function
+// containing a yield returns an iterator over the values it yields
+// when called. A spread value, such as yield ...xs, yields each value
+// of an iterable.
+type YieldExpr struct {
+	// Token holds the `yield`
+	Token token.Token
+	Value Expression
+	// Slot is where the generator state is kept in the environment
+	// of the function, resolved by the parser.
+	Slot int `json:"-"`
+}
+
+// TokenLiteral returns the `yield` literal
+func (y *YieldExpr) TokenLiteral() string { return y.Token.Literal }
+
+// String returns a string representation of the yield
+func (y *YieldExpr) String() string { return "yield " + y.Value.String() }
+
 // BlockStmt describes a list of statements that belongs to IfExpr and
 // FnExpr.
 type BlockStmt struct {
 	// Token describes the opening brace `{` of the block statement.
 	Token      token.Token
 	Statements []Statement
+	// Locals is the number of slots needed by an environment of
+	// a block outside of functions, resolved by the parser. Blocks
+	// within functions use the slots of the function.
+	Locals int `json:"-"`
 }
 
 // TokenLiteral returns a string representing the opening of a block
@@ -386,3 +611,194 @@ func (c *CallExpr) String() string {
 	buf.WriteByte(')')
 	return buf.String()
 }
+
+// SelectExpr describes a select over channel operations, it evaluates
+// to the body of the case that was able to proceed.
+//
+//	select {
+//	case let v = recv(ch) { v }
+//	case send(out, 1) { true }
+//	default { null }
+//	}
+type SelectExpr struct {
+	// Token holds the `select` token.
+	Token   token.Token
+	Cases   []*SelectCase
+	Default *BlockStmt
+}
+
+// TokenLiteral returns the select token literal.
+func (s *SelectExpr) TokenLiteral() string { return s.Token.Literal }
+
+// String returns a string representing the select code.
+func (s *SelectExpr) String() string {
+	if s == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteString("select {")
+	for _, c := range s.Cases {
+		buf.WriteString(c.String())
+	}
+	if s.Default != nil {
+		buf.WriteString("default ")
+		buf.WriteString(s.Default.String())
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+// SelectCase describes a single case of a select. Comm is a call to
+// either the send or recv builtin. Name is optional and binds the
+// value received.
+type SelectCase struct {
+	// Token holds the `case` token.
+	Token token.Token
+	Name  *Identifier
+	Comm  *CallExpr
+	Body  *BlockStmt
+	// Locals is the number of slots needed by an environment of
+	// a case outside of functions, resolved by the parser.
+	Locals int `json:"-"`
+}
+
+// TokenLiteral returns the case token literal.
+func (c *SelectCase) TokenLiteral() string { return c.Token.Literal }
+
+// String returns a string representing the select case code.
+func (c *SelectCase) String() string {
+	if c == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteString("case ")
+	if c.Name != nil {
+		fmt.Fprintf(&buf, "let %s = ", c.Name)
+	}
+	buf.WriteString(c.Comm.String())
+	buf.WriteByte(' ')
+	buf.WriteString(c.Body.String())
+	return buf.String()
+}
+
+// IsSend returns true when the case sends a value.
+func (c *SelectCase) IsSend() bool {
+	fn, ok := c.Comm.Function.(*Identifier)
+	return ok && fn.Value == "send"
+}
+
+// MatchExpr describes matching a value against patterns, it evaluates
+// to the body of the first arm whose pattern matches.
+//
+//	match v {
+//	0 => "zero",
+//	[x, ...rest] if x > 0 => rest,
+//	{"type": "point", ...} => "point",
+//	_ => "other"
+//	}
+type MatchExpr struct {
+	// Token holds the `match` token.
+	Token token.Token
+	Value Expression
+	Arms  []*MatchArm
+}
+
+// TokenLiteral returns the match token literal.
+func (m *MatchExpr) TokenLiteral() string { return m.Token.Literal }
+
+// String returns a string representing the match code.
+func (m *MatchExpr) String() string {
+	if m == nil {
+		return ""
+	}
+	arms := make([]string, len(m.Arms))
+	for i, a := range m.Arms {
+		arms[i] = a.String()
+	}
+	return fmt.Sprintf("match %s {%s}", m.Value, strings.Join(arms, ", "))
+}
+
+// MatchArm describes a single arm of a match. Pattern is an
+// identifier binding the value, `_` matching anything, an integer,
+// string or boolean literal, an ArrayMatch or a HashMatch. The
+// optional Guard must be truthy for the arm to be taken. Body is
+// either an expression or a *BlockStmt.
+type MatchArm struct {
+	// Token holds the first token of the pattern.
+	Token   token.Token
+	Pattern Expression
+	Guard   Expression
+	Body    Expression
+	// Locals is the number of slots needed by an environment of
+	// an arm outside of functions, resolved by the parser.
+	Locals int `json:"-"`
+}
+
+// TokenLiteral returns the first token literal of the pattern.
+func (a *MatchArm) TokenLiteral() string { return a.Token.Literal }
+
+// String returns a string representing the arm code.
+func (a *MatchArm) String() string {
+	if a == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteString(a.Pattern.String())
+	if a.Guard != nil {
+		buf.WriteString(" if ")
+		buf.WriteString(a.Guard.String())
+	}
+	buf.WriteString(" => ")
+	buf.WriteString(a.Body.String())
+	return buf.String()
+}
+
+// ArrayMatch is a pattern matching arrays element by element. Without
+// a rest the array must have exactly as many elements, Rest binds the
+// remaining elements of the array unless it is `_`.
+type ArrayMatch struct {
+	// Token holds the `[`
+	Token    token.Token
+	Elements []Expression
+	Rest     *Identifier
+}
+
+// TokenLiteral returns the `[` literal
+func (a *ArrayMatch) TokenLiteral() string { return a.Token.Literal }
+
+// String returns a string representation of the pattern
+func (a *ArrayMatch) String() string {
+	es := make([]string, len(a.Elements))
+	for i, e := range a.Elements {
+		es[i] = e.String()
+	}
+	if a.Rest != nil {
+		es = append(es, "..."+a.Rest.String())
+	}
+	return "[" + strings.Join(es, ", ") + "]"
+}
+
+// HashMatch is a pattern matching hashes holding each key with a value
+// matching its pattern. Unless Open, the hash must not hold any other
+// key.
+type HashMatch struct {
+	// Token holds the `{`
+	Token token.Token
+	Pairs []HashPair
+	Open  bool
+}
+
+// TokenLiteral returns the `{` literal
+func (h *HashMatch) TokenLiteral() string { return h.Token.Literal }
+
+// String returns a string representation of the pattern
+func (h *HashMatch) String() string {
+	ps := make([]string, len(h.Pairs))
+	for i, p := range h.Pairs {
+		ps[i] = p.Key.String() + ": " + p.Value.String()
+	}
+	if h.Open {
+		ps = append(ps, "...")
+	}
+	return "{" + strings.Join(ps, ", ") + "}"
+}
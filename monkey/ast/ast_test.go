@@ -1,6 +1,8 @@
 package ast
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/emb/play/monkey/token"
@@ -45,3 +47,123 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() is %q, want %q", program, want)
 	}
 }
+
+func TestModify(t *testing.T) {
+	integer := func(i int64) *IntegerLiteral {
+		return &IntegerLiteral{
+			Token: token.Token{Type: token.INT, Literal: fmt.Sprint(i)},
+			Value: i,
+		}
+	}
+	one := func() Expression { return integer(1) }
+	two := func() Expression { return integer(2) }
+	turnOneIntoTwo := func(node Node) Node {
+		if i, ok := node.(*IntegerLiteral); ok && i.Value == 1 {
+			return integer(2)
+		}
+		return node
+	}
+
+	tests := []struct {
+		input Node
+		want  Node
+	}{
+		{one(), two()},
+		{
+			&Program{Statements: []Statement{&ExpressionStmt{Expression: one()}}},
+			&Program{Statements: []Statement{&ExpressionStmt{Expression: two()}}},
+		},
+		{&InfixExpr{Left: one(), Operator: "+", Right: two()}, &InfixExpr{Left: two(), Operator: "+", Right: two()}},
+		{&PrefixExpr{Operator: "-", Right: one()}, &PrefixExpr{Operator: "-", Right: two()}},
+		{&IndexExpr{Left: one(), Index: one()}, &IndexExpr{Left: two(), Index: two()}},
+		{&SliceExpr{Left: one(), End: one()}, &SliceExpr{Left: two(), End: two()}},
+		{
+			&IfExpr{
+				Condition:   one(),
+				Consequence: &BlockStmt{Statements: []Statement{&ExpressionStmt{Expression: one()}}},
+				Alternative: &BlockStmt{Statements: []Statement{&ExpressionStmt{Expression: one()}}},
+			},
+			&IfExpr{
+				Condition:   two(),
+				Consequence: &BlockStmt{Statements: []Statement{&ExpressionStmt{Expression: two()}}},
+				Alternative: &BlockStmt{Statements: []Statement{&ExpressionStmt{Expression: two()}}},
+			},
+		},
+		{&ReturnStmt{Value: one()}, &ReturnStmt{Value: two()}},
+		{&LetStmt{Name: &Identifier{Value: "x"}, Value: one()}, &LetStmt{Name: &Identifier{Value: "x"}, Value: two()}},
+		{
+			&FunctionLiteral{
+				Parameters: []*Identifier{},
+				Defaults:   []Expression{one()},
+				Body:       &BlockStmt{Statements: []Statement{&ExpressionStmt{Expression: one()}}},
+			},
+			&FunctionLiteral{
+				Parameters: []*Identifier{},
+				Defaults:   []Expression{two()},
+				Body:       &BlockStmt{Statements: []Statement{&ExpressionStmt{Expression: two()}}},
+			},
+		},
+		{&ArrayLiteral{Elements: []Expression{one(), one()}}, &ArrayLiteral{Elements: []Expression{two(), two()}}},
+		{
+			&HashLiteral{Pairs: []HashPair{{Key: one(), Value: one()}}},
+			&HashLiteral{Pairs: []HashPair{{Key: two(), Value: two()}}},
+		},
+		{&YieldExpr{Value: one()}, &YieldExpr{Value: two()}},
+		{
+			&MatchExpr{Value: one(), Arms: []*MatchArm{{Pattern: one(), Guard: one(), Body: one()}}},
+			&MatchExpr{Value: two(), Arms: []*MatchArm{{Pattern: two(), Guard: two(), Body: two()}}},
+		},
+	}
+	for i, tc := range tests {
+		got := Modify(tc.input, turnOneIntoTwo)
+		if got.String() != tc.want.String() {
+			t.Errorf("test[%d] modified to %s, want %s", i, got, tc.want)
+		}
+	}
+}
+
+func TestModifyKeepsIllTypedReplacements(t *testing.T) {
+	body := &BlockStmt{Statements: []Statement{}}
+	fn := &FunctionLiteral{Body: body}
+	Modify(fn, func(node Node) Node {
+		if _, ok := node.(*BlockStmt); ok {
+			return &IntegerLiteral{Value: 1}
+		}
+		return node
+	})
+	if fn.Body != body {
+		t.Errorf("fn.Body replaced by %v, want it kept", fn.Body)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	x := &Identifier{Value: "x"}
+	program := &Program{Statements: []Statement{
+		&LetStmt{Name: x, Value: &InfixExpr{
+			Left:     &IntegerLiteral{Value: 1},
+			Operator: "+",
+			Right:    &CallExpr{Function: &Identifier{Value: "f"}, Arguments: []Expression{x}},
+		}},
+	}}
+	var got []string
+	Inspect(program, func(n Node) bool {
+		if n == nil {
+			got = append(got, "end")
+			return false
+		}
+		if _, ok := n.(*CallExpr); ok {
+			got = append(got, "call")
+			return false
+		}
+		got = append(got, fmt.Sprintf("%T", n))
+		return true
+	})
+	want := []string{
+		"*ast.Program", "*ast.LetStmt", "*ast.Identifier", "end",
+		"*ast.InfixExpr", "*ast.IntegerLiteral", "end", "call",
+		"end", "end", "end",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("visited %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,4 @@
+package ast
+
+// NodeTypes exposes the registry of node types to the external tests.
+var NodeTypes = nodeTypes
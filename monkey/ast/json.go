@@ -0,0 +1,299 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+
+	"github.com/emb/play/monkey/token"
+)
+
+// nodeTypes maps the name of each node type, as found in the "Node"
+// field of its JSON object, to its type.
+var nodeTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, n := range []Node{
+		&Program{}, &LetStmt{}, &ArrayPattern{}, &HashPattern{},
+		&Identifier{}, &ReturnStmt{}, &ExpressionStmt{},
+		&IntegerLiteral{}, &StringLiteral{}, &TemplateLiteral{},
+		&ArrayLiteral{}, &HashLiteral{}, &IndexExpr{}, &SliceExpr{},
+		&MemberExpr{},
+		&PrefixExpr{}, &InfixExpr{}, &Boolean{}, &IfExpr{},
+		&FunctionLiteral{}, &SpreadExpr{}, &BlockStmt{}, &CallExpr{},
+		&SelectExpr{}, &SelectCase{}, &MatchExpr{}, &MatchArm{},
+		&ArrayMatch{}, &HashMatch{}, &YieldExpr{},
+	} {
+		t := reflect.TypeOf(n).Elem()
+		nodeTypes[t.Name()] = t
+	}
+}
+
+// optional holds the children, by node type and field, that a node
+// may go without. Other children are required, as are the elements of
+// lists other than FunctionLiteral.Defaults. A LetStmt requires either
+// its Name or its Pattern.
+var optional = map[string]bool{
+	"LetStmt.Name":             true,
+	"LetStmt.Pattern":          true,
+	"ArrayPattern.Rest":        true,
+	"IntegerLiteral.Big":       true,
+	"SliceExpr.Start":          true,
+	"SliceExpr.End":            true,
+	"IfExpr.Alternative":       true,
+	"FunctionLiteral.Rest":     true,
+	"FunctionLiteral.Defaults": true,
+	"SelectExpr.Default":       true,
+	"SelectCase.Name":          true,
+	"MatchArm.Guard":           true,
+	"ArrayMatch.Rest":          true,
+}
+
+var (
+	tokenType = reflect.TypeOf(token.Token{})
+	bigType   = reflect.TypeOf(big.Int{})
+	nodeType  = reflect.TypeOf((*Node)(nil)).Elem()
+)
+
+// leaf reports whether values of type t are serialized as a whole by
+// encoding/json rather than field by field.
+func leaf(t reflect.Type) bool {
+	return t == tokenType || t == bigType
+}
+
+// ToJSON serializes the tree rooted at node. Each node is an object
+// holding its type name in "Node" along with its fields, tokens keep
+// their position in the source.
+func ToJSON(node Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, reflect.ValueOf(&node).Elem()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FromJSON reads back a tree serialized by ToJSON. Identifiers are not
+// resolved, parser.Resolve must be called before evaluating the tree.
+// Tokens may be left out, nodes printed from their token then get the
+// one the parser would have read.
+func FromJSON(data []byte) (Node, error) {
+	var node Node
+	if err := decode(data, reflect.ValueOf(&node).Elem()); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func encode(buf *bytes.Buffer, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return encode(buf, v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encode(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	case reflect.Struct:
+		if leaf(v.Type()) {
+			break
+		}
+		buf.WriteByte('{')
+		first := true
+		if _, ok := nodeTypes[v.Type().Name()]; ok {
+			fmt.Fprintf(buf, "%q:%q", "Node", v.Type().Name())
+			first = false
+		}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.Tag.Get("json") == "-" {
+				continue
+			}
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			fmt.Fprintf(buf, "%q:", f.Name)
+			if err := encode(buf, v.Field(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	}
+	// Big integers marshal through their pointer.
+	if v.CanAddr() {
+		v = v.Addr()
+	}
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
+
+func decode(data []byte, v reflect.Value) error {
+	null := bytes.Equal(bytes.TrimSpace(data), []byte("null"))
+	switch v.Kind() {
+	case reflect.Interface:
+		if null {
+			return nil
+		}
+		var head struct{ Node string }
+		if err := json.Unmarshal(data, &head); err != nil {
+			return err
+		}
+		t, ok := nodeTypes[head.Node]
+		if !ok {
+			return fmt.Errorf("ast: unknown node type %q", head.Node)
+		}
+		n := reflect.New(t)
+		if !n.Type().Implements(v.Type()) {
+			return fmt.Errorf("ast: %s node can not be used as %s", head.Node, v.Type().Name())
+		}
+		if err := decode(data, n.Elem()); err != nil {
+			return err
+		}
+		v.Set(n)
+		return nil
+	case reflect.Ptr:
+		if null {
+			return nil
+		}
+		n := reflect.New(v.Type().Elem())
+		if err := decode(data, n.Elem()); err != nil {
+			return err
+		}
+		v.Set(n)
+		return nil
+	case reflect.Slice:
+		if null {
+			return nil
+		}
+		var elems []json.RawMessage
+		if err := json.Unmarshal(data, &elems); err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), len(elems), len(elems))
+		for i, e := range elems {
+			if err := decode(e, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Struct:
+		if leaf(v.Type()) {
+			break
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		if name, ok := fields["Node"]; ok && reflect.PtrTo(v.Type()).Implements(nodeType) {
+			var n string
+			if err := json.Unmarshal(name, &n); err != nil {
+				return err
+			}
+			if n != v.Type().Name() {
+				return fmt.Errorf("ast: got %s node, want %s", n, v.Type().Name())
+			}
+		}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			data, ok := fields[f.Name]
+			if !ok || f.Tag.Get("json") == "-" {
+				continue
+			}
+			if err := decode(data, v.Field(i)); err != nil {
+				return fmt.Errorf("%s.%s: %w", v.Type().Name(), f.Name, err)
+			}
+		}
+		if err := complete(v); err != nil {
+			return err
+		}
+		fill(v)
+		return nil
+	}
+	return json.Unmarshal(data, v.Addr().Interface())
+}
+
+// fill sets the token of the decoded struct v when it was left out,
+// for the nodes printed from their token.
+func fill(v reflect.Value) {
+	set := func(tok *token.Token, typ token.Type, literal string) {
+		if tok.Type == "" {
+			tok.Type, tok.Literal = typ, literal
+		}
+	}
+	switch n := v.Addr().Interface().(type) {
+	case *IntegerLiteral:
+		if n.Big != nil {
+			set(&n.Token, token.INT, n.Big.String())
+		} else {
+			set(&n.Token, token.INT, strconv.FormatInt(n.Value, 10))
+		}
+	case *StringLiteral:
+		set(&n.Token, token.STRING, n.Value)
+	case *Boolean:
+		if n.Value {
+			set(&n.Token, token.TRUE, "true")
+		} else {
+			set(&n.Token, token.FALSE, "false")
+		}
+	case *LetStmt:
+		set(&n.Token, token.LET, "let")
+	case *ReturnStmt:
+		set(&n.Token, token.RETURN, "return")
+	case *FunctionLiteral:
+		set(&n.Token, token.FUNCTION, "fn")
+	}
+}
+
+// complete checks that the decoded struct v holds its required
+// children.
+func complete(v reflect.Value) error {
+	name := v.Type().Name()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.Tag.Get("json") == "-" || optional[name+"."+f.Name] {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Interface, reflect.Ptr:
+			if field.IsNil() {
+				return fmt.Errorf("ast: %s node is missing %s", name, f.Name)
+			}
+		case reflect.Slice:
+			for j := 0; j < field.Len(); j++ {
+				e := field.Index(j)
+				if (e.Kind() == reflect.Interface || e.Kind() == reflect.Ptr) && e.IsNil() {
+					return fmt.Errorf("ast: %s node is missing %s[%d]", name, f.Name, j)
+				}
+			}
+		}
+	}
+	if l, ok := v.Addr().Interface().(*LetStmt); ok && l.Name == nil && l.Pattern == nil {
+		return fmt.Errorf("ast: LetStmt node is missing Name or Pattern")
+	}
+	return nil
+}
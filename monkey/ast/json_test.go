@@ -0,0 +1,232 @@
+package ast_test
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/lexer"
+	"github.com/emb/play/monkey/parser"
+)
+
+// everyNode is a program using every type of node.
+const everyNode = `
+let [a, ...b] = [1, ...c];
+let {d} = {"d": "${a}"};
+let f = fn(x, y = 2, ...z) { return x.y(z[0], -y, z[1:]); };
+if (true) { f(1) } else { 2 + 3 };
+select { case let v = recv(ch) { v } case send(ch, 1) { 1 } default { 0 } };
+match a { [1, ...r] if r => r, {"k": _, ...} => 1 };
+let g = fn() { yield a; yield ...b };
+`
+
+func TestWalkEveryNode(t *testing.T) {
+	parse := parser.New(lexer.New(everyNode))
+	program := parse.Program()
+	checkErrors(t, parse)
+
+	seen := map[string]bool{}
+	ast.Inspect(program, func(n ast.Node) bool {
+		if n != nil {
+			seen[fmt.Sprintf("%T", n)[len("*ast."):]] = true
+		}
+		return true
+	})
+	// Nodes are registered for JSON by type name.
+	data, err := ast.ToJSON(program)
+	if err != nil {
+		t.Fatalf("ToJSON failed: %s", err)
+	}
+	var nodes []string
+	for _, m := range regexp.MustCompile(`"Node":"(\w+)"`).FindAllStringSubmatch(string(data), -1) {
+		nodes = append(nodes, m[1])
+	}
+	for _, n := range nodes {
+		if !seen[n] {
+			t.Errorf("%s is not walked", n)
+		}
+	}
+	for name := range ast.NodeTypes {
+		if !seen[name] {
+			t.Errorf("%s is registered but not walked", name)
+		}
+	}
+	if len(seen) != len(ast.NodeTypes) {
+		t.Errorf("walked %d types of node, want %d: %v", len(seen), len(ast.NodeTypes), seen)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for i, input := range []string{everyNode, "", "let x = 1; x * 2", "99999999999999999999 - 1"} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := parser.New(lexer.New(input))
+			program := parse.Program()
+			checkErrors(t, parse)
+
+			data, err := ast.ToJSON(program)
+			if err != nil {
+				t.Fatalf("ToJSON failed: %s", err)
+			}
+			node, err := ast.FromJSON(data)
+			if err != nil {
+				t.Fatalf("FromJSON failed: %s", err)
+			}
+			if node.String() != program.String() {
+				t.Errorf("round trip gives %q, want %q", node, program)
+			}
+			again, err := ast.ToJSON(node)
+			if err != nil {
+				t.Fatalf("ToJSON failed: %s", err)
+			}
+			if string(again) != string(data) {
+				t.Errorf("round trip gives JSON %s, want %s", again, data)
+			}
+		})
+	}
+}
+
+func TestJSONWithoutTokens(t *testing.T) {
+	// Trees written by hand may leave out tokens, they print as the
+	// trees parsed from their source.
+	tests := []struct {
+		data string
+		src  string
+	}{
+		{
+			`{"Node": "InfixExpr", "Operator": "+",
+			  "Left": {"Node": "IntegerLiteral", "Value": 1},
+			  "Right": {"Node": "IntegerLiteral", "Value": 0, "Big": 99999999999999999999}}`,
+			"1 + 99999999999999999999",
+		},
+		{
+			`{"Node": "LetStmt", "Name": {"Node": "Identifier", "Value": "s"},
+			  "Value": {"Node": "StringLiteral", "Value": "hi"}}`,
+			`let s = "hi";`,
+		},
+		{
+			`{"Node": "FunctionLiteral", "Parameters": [], "Body": {"Node": "BlockStmt", "Statements": [
+			  {"Node": "ReturnStmt", "Value": {"Node": "Boolean", "Value": false}}]}}`,
+			"fn() { return false; }",
+		},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := parser.New(lexer.New(tc.src))
+			want := parse.Program().String()
+			checkErrors(t, parse)
+
+			node, err := ast.FromJSON([]byte(tc.data))
+			if err != nil {
+				t.Fatalf("FromJSON failed: %s", err)
+			}
+			if node.String() != want {
+				t.Errorf("node is %q, want %q", node, want)
+			}
+			data, err := ast.ToJSON(node)
+			if err != nil {
+				t.Fatalf("ToJSON failed: %s", err)
+			}
+			again, err := ast.FromJSON(data)
+			if err != nil {
+				t.Fatalf("FromJSON failed: %s", err)
+			}
+			if again.String() != want {
+				t.Errorf("round trip gives %q, want %q", again, want)
+			}
+		})
+	}
+}
+
+func TestJSONPositions(t *testing.T) {
+	parse := parser.New(lexer.New("let x = 1;\n  x"))
+	data, err := ast.ToJSON(parse.Program())
+	if err != nil {
+		t.Fatalf("ToJSON failed: %s", err)
+	}
+	want := `{"Node":"ExpressionStmt","Token":{"Type":"IDENT","Literal":"x","Pos":{"Line":2,"Column":3}}`
+	if !strings.Contains(string(data), want) {
+		t.Errorf("JSON %s does not hold %s", data, want)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []string{
+		`{"Node":"Nope"}`,
+		`{"Node":"Program","Statements":[{"Node":"HashPair"}]}`,
+		`{"Node":"LetStmt","Pattern":{"Node":"IntegerLiteral"}}`,
+		`{"Node":"Program","Statements":{}}`,
+		`[`,
+	}
+	for i, input := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if _, err := ast.FromJSON([]byte(input)); err == nil {
+				t.Errorf("decoding %s succeeded, want an error", input)
+			}
+		})
+	}
+}
+
+func TestJSONMissingChildren(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{
+			`{"Node":"LetStmt","Value":{"Node":"IntegerLiteral","Value":1}}`,
+			"ast: LetStmt node is missing Name or Pattern",
+		},
+		{
+			`{"Node":"LetStmt","Name":{"Node":"Identifier","Value":"x"}}`,
+			"ast: LetStmt node is missing Value",
+		},
+		{
+			`{"Node":"Program","Statements":[{"Node":"ExpressionStmt","Expression":{"Node":"InfixExpr","Operator":"+"}}]}`,
+			"Program.Statements: ExpressionStmt.Expression: ast: InfixExpr node is missing Left",
+		},
+		{
+			`{"Node":"Program","Statements":[null]}`,
+			"ast: Program node is missing Statements[0]",
+		},
+		{
+			`{"Node":"CallExpr","Function":{"Node":"Identifier","Value":"f"},"Arguments":[null]}`,
+			"ast: CallExpr node is missing Arguments[0]",
+		},
+		{
+			`{"Node":"HashLiteral","Pairs":[{"Key":{"Node":"StringLiteral","Value":"a"}}]}`,
+			"HashLiteral.Pairs: ast: HashPair node is missing Value",
+		},
+		{
+			`{"Node":"IfExpr","Condition":{"Node":"Boolean","Value":true}}`,
+			"ast: IfExpr node is missing Consequence",
+		},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			_, err := ast.FromJSON([]byte(tc.input))
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("decoding %s returned %v, want %s", tc.input, err, tc.want)
+			}
+		})
+	}
+
+	// Optional children may be left out.
+	input := `{"Node":"FunctionLiteral","Parameters":[{"Node":"Identifier","Value":"a"}],"Defaults":[null],"Body":{"Node":"BlockStmt"}}`
+	if _, err := ast.FromJSON([]byte(input)); err != nil {
+		t.Errorf("decoding %s failed: %v", input, err)
+	}
+}
+
+func checkErrors(t *testing.T, p *parser.Parser) {
+	t.Helper()
+	errs := p.Errors()
+	if len(errs) == 0 {
+		return
+	}
+	for _, err := range errs {
+		t.Errorf("parser error: %s", err)
+	}
+	t.FailNow()
+}
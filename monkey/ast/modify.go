@@ -0,0 +1,173 @@
+package ast
+
+// ModifierFunc is applied by Modify to each node, the node returned
+// replaces it in the tree.
+type ModifierFunc func(Node) Node
+
+// Modify rewrites an AST bottom up: the children of node are modified
+// first, then node is replaced by the result of modifier(node). A
+// replacement whose type can not take the place of the node in its
+// parent, such as an expression replacing the body of a function, is
+// ignored.
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch n := node.(type) {
+	case *Program:
+		modifyStmts(n.Statements, modifier)
+	case *LetStmt:
+		if n.Pattern != nil {
+			if m, ok := Modify(n.Pattern, modifier).(Pattern); ok {
+				n.Pattern = m
+			}
+		} else if n.Name != nil {
+			n.Name = modifyIdent(n.Name, modifier)
+		}
+		n.Value = modifyExpr(n.Value, modifier)
+	case *ArrayPattern:
+		modifyIdents(n.Elements, modifier)
+		if n.Rest != nil {
+			n.Rest = modifyIdent(n.Rest, modifier)
+		}
+	case *HashPattern:
+		modifyIdents(n.Keys, modifier)
+	case *ReturnStmt:
+		n.Value = modifyExpr(n.Value, modifier)
+	case *ExpressionStmt:
+		n.Expression = modifyExpr(n.Expression, modifier)
+	case *BlockStmt:
+		modifyStmts(n.Statements, modifier)
+	case *TemplateLiteral:
+		modifyExprs(n.Parts, modifier)
+	case *ArrayLiteral:
+		modifyExprs(n.Elements, modifier)
+	case *HashLiteral:
+		modifyPairs(n.Pairs, modifier)
+	case *IndexExpr:
+		n.Left = modifyExpr(n.Left, modifier)
+		n.Index = modifyExpr(n.Index, modifier)
+	case *SliceExpr:
+		n.Left = modifyExpr(n.Left, modifier)
+		n.Start = modifyExpr(n.Start, modifier)
+		n.End = modifyExpr(n.End, modifier)
+	case *MemberExpr:
+		n.Left = modifyExpr(n.Left, modifier)
+		if n.Member != nil {
+			n.Member = modifyIdent(n.Member, modifier)
+		}
+	case *PrefixExpr:
+		n.Right = modifyExpr(n.Right, modifier)
+	case *InfixExpr:
+		n.Left = modifyExpr(n.Left, modifier)
+		n.Right = modifyExpr(n.Right, modifier)
+	case *IfExpr:
+		n.Condition = modifyExpr(n.Condition, modifier)
+		if n.Consequence != nil {
+			n.Consequence = modifyBlock(n.Consequence, modifier)
+		}
+		if n.Alternative != nil {
+			n.Alternative = modifyBlock(n.Alternative, modifier)
+		}
+	case *FunctionLiteral:
+		modifyIdents(n.Parameters, modifier)
+		modifyExprs(n.Defaults, modifier)
+		if n.Rest != nil {
+			n.Rest = modifyIdent(n.Rest, modifier)
+		}
+		if n.Body != nil {
+			n.Body = modifyBlock(n.Body, modifier)
+		}
+	case *SpreadExpr:
+		n.Value = modifyExpr(n.Value, modifier)
+	case *YieldExpr:
+		n.Value = modifyExpr(n.Value, modifier)
+	case *CallExpr:
+		n.Function = modifyExpr(n.Function, modifier)
+		modifyExprs(n.Arguments, modifier)
+	case *SelectExpr:
+		for i, c := range n.Cases {
+			if m, ok := Modify(c, modifier).(*SelectCase); ok {
+				n.Cases[i] = m
+			}
+		}
+		if n.Default != nil {
+			n.Default = modifyBlock(n.Default, modifier)
+		}
+	case *SelectCase:
+		if n.Name != nil {
+			n.Name = modifyIdent(n.Name, modifier)
+		}
+		if n.Comm != nil {
+			if m, ok := Modify(n.Comm, modifier).(*CallExpr); ok {
+				n.Comm = m
+			}
+		}
+		if n.Body != nil {
+			n.Body = modifyBlock(n.Body, modifier)
+		}
+	case *MatchExpr:
+		n.Value = modifyExpr(n.Value, modifier)
+		for i, a := range n.Arms {
+			if m, ok := Modify(a, modifier).(*MatchArm); ok {
+				n.Arms[i] = m
+			}
+		}
+	case *MatchArm:
+		n.Pattern = modifyExpr(n.Pattern, modifier)
+		n.Guard = modifyExpr(n.Guard, modifier)
+		n.Body = modifyExpr(n.Body, modifier)
+	case *ArrayMatch:
+		modifyExprs(n.Elements, modifier)
+		if n.Rest != nil {
+			n.Rest = modifyIdent(n.Rest, modifier)
+		}
+	case *HashMatch:
+		modifyPairs(n.Pairs, modifier)
+	}
+	return modifier(node)
+}
+
+// modifyExpr modifies an optional expression.
+func modifyExpr(n Expression, modifier ModifierFunc) Expression {
+	if n == nil {
+		return nil
+	}
+	return Modify(n, modifier)
+}
+
+func modifyIdent(n *Identifier, modifier ModifierFunc) *Identifier {
+	if m, ok := Modify(n, modifier).(*Identifier); ok {
+		return m
+	}
+	return n
+}
+
+func modifyBlock(n *BlockStmt, modifier ModifierFunc) *BlockStmt {
+	if m, ok := Modify(n, modifier).(*BlockStmt); ok {
+		return m
+	}
+	return n
+}
+
+func modifyStmts(list []Statement, modifier ModifierFunc) {
+	for i, n := range list {
+		list[i] = modifyExpr(n, modifier)
+	}
+}
+
+func modifyExprs(list []Expression, modifier ModifierFunc) {
+	for i, n := range list {
+		list[i] = modifyExpr(n, modifier)
+	}
+}
+
+func modifyIdents(list []*Identifier, modifier ModifierFunc) {
+	for i, n := range list {
+		list[i] = modifyIdent(n, modifier)
+	}
+}
+
+func modifyPairs(pairs []HashPair, modifier ModifierFunc) {
+	for i, p := range pairs {
+		pairs[i].Key = modifyExpr(p.Key, modifier)
+		pairs[i].Value = modifyExpr(p.Value, modifier)
+	}
+}
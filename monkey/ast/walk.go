@@ -0,0 +1,178 @@
+package ast
+
+// A Visitor's Visit method is invoked for each node encountered by
+// Walk. If the result visitor w is not nil, Walk visits each of the
+// children of node with the visitor w, followed by a call of
+// w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order: It starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor w
+// for each of the non-nil children of node, followed by a call of
+// w.Visit(nil).
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStmts(v, n.Statements)
+	case *LetStmt:
+		if n.Pattern != nil {
+			Walk(v, n.Pattern)
+		} else if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		walkExpr(v, n.Value)
+	case *ArrayPattern:
+		walkIdents(v, n.Elements)
+		if n.Rest != nil {
+			Walk(v, n.Rest)
+		}
+	case *HashPattern:
+		walkIdents(v, n.Keys)
+	case *ReturnStmt:
+		walkExpr(v, n.Value)
+	case *ExpressionStmt:
+		walkExpr(v, n.Expression)
+	case *BlockStmt:
+		walkStmts(v, n.Statements)
+	case *Identifier, *IntegerLiteral, *StringLiteral, *Boolean:
+		// leaves
+	case *TemplateLiteral:
+		walkExprs(v, n.Parts)
+	case *ArrayLiteral:
+		walkExprs(v, n.Elements)
+	case *HashLiteral:
+		walkPairs(v, n.Pairs)
+	case *IndexExpr:
+		walkExpr(v, n.Left)
+		walkExpr(v, n.Index)
+	case *SliceExpr:
+		walkExpr(v, n.Left)
+		walkExpr(v, n.Start)
+		walkExpr(v, n.End)
+	case *MemberExpr:
+		walkExpr(v, n.Left)
+		if n.Member != nil {
+			Walk(v, n.Member)
+		}
+	case *PrefixExpr:
+		walkExpr(v, n.Right)
+	case *InfixExpr:
+		walkExpr(v, n.Left)
+		walkExpr(v, n.Right)
+	case *IfExpr:
+		walkExpr(v, n.Condition)
+		if n.Consequence != nil {
+			Walk(v, n.Consequence)
+		}
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+	case *FunctionLiteral:
+		walkIdents(v, n.Parameters)
+		walkExprs(v, n.Defaults)
+		if n.Rest != nil {
+			Walk(v, n.Rest)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+	case *SpreadExpr:
+		walkExpr(v, n.Value)
+	case *YieldExpr:
+		walkExpr(v, n.Value)
+	case *CallExpr:
+		walkExpr(v, n.Function)
+		walkExprs(v, n.Arguments)
+	case *SelectExpr:
+		for _, c := range n.Cases {
+			Walk(v, c)
+		}
+		if n.Default != nil {
+			Walk(v, n.Default)
+		}
+	case *SelectCase:
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		if n.Comm != nil {
+			Walk(v, n.Comm)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+	case *MatchExpr:
+		walkExpr(v, n.Value)
+		for _, a := range n.Arms {
+			Walk(v, a)
+		}
+	case *MatchArm:
+		walkExpr(v, n.Pattern)
+		walkExpr(v, n.Guard)
+		walkExpr(v, n.Body)
+	case *ArrayMatch:
+		walkExprs(v, n.Elements)
+		if n.Rest != nil {
+			Walk(v, n.Rest)
+		}
+	case *HashMatch:
+		walkPairs(v, n.Pairs)
+	}
+
+	v.Visit(nil)
+}
+
+// walkExpr walks n unless it is nil, as optional expressions are.
+func walkExpr(v Visitor, n Node) {
+	if n != nil {
+		Walk(v, n)
+	}
+}
+
+func walkStmts(v Visitor, list []Statement) {
+	for _, n := range list {
+		walkExpr(v, n)
+	}
+}
+
+func walkExprs(v Visitor, list []Expression) {
+	for _, n := range list {
+		walkExpr(v, n)
+	}
+}
+
+func walkIdents(v Visitor, list []*Identifier) {
+	for _, n := range list {
+		Walk(v, n)
+	}
+}
+
+func walkPairs(v Visitor, pairs []HashPair) {
+	for _, p := range pairs {
+		walkExpr(v, p.Key)
+		walkExpr(v, p.Value)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order: It starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the non-nil children of node, followed by a
+// call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/parser"
+)
+
+func init() {
+	commands["ast"] = command{
+		summary: "print the syntax tree of a file",
+		run:     astCmd,
+	}
+}
+
+// astCmd prints the syntax tree of a monkey script, as code or as
+// JSON. With -from-json the file holds a tree serialized to JSON,
+// which is read back and printed. A script with syntax errors has the
+// statements that could be parsed printed.
+func astCmd(args []string) error {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the tree as JSON")
+	fromJSON := flags.Bool("from-json", false, "read the tree from JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s ast [flags] FILE\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		program  *ast.Program
+		parseErr error
+	)
+	if *fromJSON {
+		data, err := ioutil.ReadFile(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		node, err := ast.FromJSON(data)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if program, ok = node.(*ast.Program); !ok {
+			return errors.New("ast: JSON does not hold a Program")
+		}
+		if errs := parser.Resolve(program); len(errs) != 0 {
+			for _, err := range errs {
+				log.Printf("%s: %s", flags.Arg(0), err)
+			}
+			parseErr = fmt.Errorf("%s: %d parser errors", flags.Arg(0), len(errs))
+		}
+	} else {
+		program, parseErr = parseFile(flags.Arg(0))
+		if program == nil {
+			return parseErr
+		}
+	}
+
+	if !*asJSON {
+		fmt.Println(program)
+		return parseErr
+	}
+	data, err := ast.ToJSON(program)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return parseErr
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/transpile"
+)
+
+func init() {
+	commands["build"] = command{
+		summary: "translate a file to JavaScript, Go or Hack VM code",
+		run:     buildCmd,
+	}
+}
+
+// targets maps the names accepted by -target to their transpiler.
+var targets = map[string]func(*ast.Program) ([]byte, error){
+	"js":   transpile.JS,
+	"go":   transpile.Go,
+	"hack": transpile.Hack,
+}
+
+// buildCmd prints the source of a JavaScript or Go program equivalent
+// to a monkey script, or its Hack VM code.
+func buildCmd(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	target := flags.String("target", "js", "target language, js, go or hack")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s build [flags] FILE\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	build, ok := targets[*target]
+	if flags.NArg() != 1 || !ok {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	program, err := parseFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	src, err := build(program)
+	if err != nil {
+		return fmt.Errorf("%s:%s", flags.Arg(0), err)
+	}
+	_, err = os.Stdout.Write(src)
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/doc"
+)
+
+func init() {
+	commands["doc"] = command{
+		summary: "print the documentation of a file or directory",
+		run:     docCmd,
+	}
+}
+
+// docCmd prints the documentation of the functions of a monkey script,
+// or of every .mk script in a directory, followed by the builtins.
+func docCmd(args []string) error {
+	flags := flag.NewFlagSet("doc", flag.ExitOnError)
+	html := flags.Bool("html", false, "print the documentation as HTML")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s doc [flags] FILE|DIR\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	files := []string{flags.Arg(0)}
+	if info, err := os.Stat(flags.Arg(0)); err != nil {
+		return err
+	} else if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(flags.Arg(0), "*.mk")); err != nil {
+			return err
+		}
+	}
+	programs := map[string]*ast.Program{}
+	for _, file := range files {
+		program, err := parseFile(file)
+		if err != nil {
+			return err
+		}
+		programs[file] = program
+	}
+
+	pkg := doc.New(programs)
+	if *html {
+		return pkg.WriteHTML(os.Stdout)
+	}
+	return pkg.WriteText(os.Stdout)
+}
@@ -1,17 +1,108 @@
-// Package main provide a simple REPL for the monkey language.
+// Package main provide a simple REPL for the monkey language. Given a
+// file it runs it as a script instead.
+//
+// Usage:
+//
+//	monkey [--allow-read] [--allow-write] [--allow-run] [-O] [FILE]
+//	monkey [-restore FILE]
+//	monkey COMMAND [ARGS]
+//
+// Programs can not access the host unless allowed by the flags. The
+// REPL resumes a session written by its :snapshot command with
+// -restore. With -O scripts are optimized before they run.
+//
+// The commands are:
+//
+//	ast	print the syntax tree of a file
+//	build	translate a file to JavaScript, Go or Hack VM code
+//	doc	print the documentation of a file or directory
+//	serve	serve a web playground running programs
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/user"
+	"sort"
 
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/evaluator"
+	"github.com/emb/play/monkey/lexer"
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/optimize"
+	"github.com/emb/play/monkey/parser"
 	"github.com/emb/play/monkey/repl"
 )
 
+// rt is the runtime of the programs run by the REPL and the scripts,
+// granted capabilities by the flags.
+var rt evaluator.Runtime
+
 func main() {
 	log.SetFlags(0)
+	flag.BoolVar(&rt.Allowed.Read, "allow-read", false,
+		"allow reading files, directories and environment variables")
+	flag.BoolVar(&rt.Allowed.Write, "allow-write", false,
+		"allow writing files")
+	flag.BoolVar(&rt.Allowed.Run, "allow-run", false,
+		"allow running commands")
+	optimized := flag.Bool("O", false,
+		"fold constants and inline small functions before running FILE")
+	restore := flag.String("restore", "",
+		"resume the REPL session written by :snapshot to `FILE`")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"usage: %s [flags] [FILE]\n       %s COMMAND [ARGS]\n",
+			os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "commands:\n")
+		for _, name := range commandNames() {
+			fmt.Fprintf(flag.CommandLine.Output(), "  %s\t%s\n",
+				name, commands[name].summary)
+		}
+	}
+	flag.Parse()
+
+	if cmd, ok := commands[flag.Arg(0)]; ok {
+		if err := cmd.run(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	switch flag.NArg() {
+	case 0:
+		interactive(*restore)
+	case 1:
+		if err := run(flag.Arg(0), *optimized); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// interactive starts a REPL, resuming the session of the snapshot
+// file restore unless it is empty.
+func interactive(restore string) {
+	env := object.NewEnvironment()
+	if restore != "" {
+		f, err := os.Open(restore)
+		if err != nil {
+			log.Fatal(err)
+		}
+		env, err = repl.Restore(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %s", restore, err)
+		}
+	}
+	env.SetRuntime(&rt)
+
 	user, err := user.Current()
 	if err != nil {
 		log.Fatal(err)
@@ -19,7 +110,62 @@ func main() {
 
 	fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
 	fmt.Println("Feel free to play!")
-	if err := repl.Start(os.Stdin, os.Stdout); err != nil {
+	if err := repl.Start(os.Stdin, os.Stdout, env); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// command is a sub command of monkey, run is given the arguments
+// following the command name.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// run evaluates the monkey script in file, optimized first if opt is
+// set.
+func run(file string, opt bool) error {
+	program, err := parseFile(file)
+	if err != nil {
+		return err
+	}
+	if opt {
+		if errs := optimize.Program(program); len(errs) != 0 {
+			return fmt.Errorf("%s: %s", file, errs[0])
+		}
+	}
+	env := object.NewEnvironment()
+	env.SetRuntime(&rt)
+	_, err = evaluator.Eval(program, env)
+	return err
+}
+
+// parseFile parses the monkey script in file keeping its comments,
+// parser errors are logged. On parser errors the partial program is
+// returned along with an error.
+func parseFile(file string) (*ast.Program, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	parse := parser.New(lexer.NewWithComments(string(src)))
+	program := parse.Program()
+	if errs := parse.Errors(); len(errs) != 0 {
+		for _, err := range errs {
+			log.Printf("%s: %s", file, err)
+		}
+		return program, fmt.Errorf("%s: %d parser errors", file, len(errs))
+	}
+	return program, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/emb/play/monkey/playground"
+)
+
+func init() {
+	commands["serve"] = command{
+		summary: "serve a web playground running programs",
+		run:     serveCmd,
+	}
+}
+
+// serveCmd serves the playground until the server fails. Programs it
+// runs can not access the host whatever the flags given to monkey.
+func serveCmd(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	limits := playground.DefaultLimits
+	flags.DurationVar(&limits.Time, "time", limits.Time, "time limit of a program")
+	flags.Uint64Var(&limits.Memory, "memory", limits.Memory, "memory limit of a program in bytes")
+	flags.IntVar(&limits.Output, "output", limits.Output, "bytes of output kept for a program")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s serve [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	log.Printf("serving the playground on %s", *addr)
+	return http.ListenAndServe(*addr, playground.Handler(limits))
+}
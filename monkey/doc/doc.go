@@ -0,0 +1,276 @@
+// Package doc extracts documentation from Monkey programs and renders
+// it as text or HTML.
+//
+// Functions bound by top level let statements are documented by the
+// comments directly preceding the statement. Doc comments may refer
+// to other functions, builtins included, by writing their name in
+// brackets such as [push] or [io.lines], which become links in HTML.
+package doc
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/evaluator"
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/token"
+)
+
+// Func documents a function.
+type Func struct {
+	// Name is qualified by the module name for module members
+	// such as io.lines.
+	Name   string
+	Params string
+	Doc    string
+	// File and Pos locate functions defined in Monkey, they are
+	// empty for builtins.
+	File string
+	Pos  token.Position
+}
+
+// Const documents a constant member of a builtin module such as
+// time.hour.
+type Const struct {
+	// Name is qualified by the module name.
+	Name string
+	// Type and Value are the type and the value of the constant as
+	// printed by the REPL.
+	Type  string
+	Value string
+	Doc   string
+}
+
+// Package documents a set of Monkey files along with the builtins.
+type Package struct {
+	Funcs     []*Func
+	Builtins  []*Func
+	Constants []*Const
+}
+
+// New documents the functions of files, given as parsed programs
+// keyed by file name. Functions and builtins are sorted by name.
+func New(files map[string]*ast.Program) *Package {
+	p := &Package{Builtins: Builtins(), Constants: Constants()}
+	for file, program := range files {
+		for _, stmt := range program.Statements {
+			let, ok := stmt.(*ast.LetStmt)
+			if !ok || let.Name == nil {
+				continue
+			}
+			fn, ok := let.Value.(*ast.FunctionLiteral)
+			if !ok {
+				continue
+			}
+			p.Funcs = append(p.Funcs, &Func{
+				Name:   let.Name.Value,
+				Params: strings.Join(ast.ParamStrings(fn.Parameters, fn.Defaults, fn.Rest), ", "),
+				Doc:    Text(let.Comments, let.Token.Pos.Line),
+				File:   file,
+				Pos:    let.Token.Pos,
+			})
+		}
+	}
+	sort.Slice(p.Funcs, func(i, j int) bool {
+		a, b := p.Funcs[i], p.Funcs[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.File < b.File
+	})
+	return p
+}
+
+// Builtins documents the builtin functions and the functions of the
+// builtin modules.
+func Builtins() []*Func {
+	var funcs []*Func
+	for name, fn := range evaluator.Builtins() {
+		funcs = append(funcs, &Func{Name: name, Params: fn.Params, Doc: fn.Doc})
+	}
+	for name, mod := range evaluator.Modules() {
+		for member, obj := range mod.Members {
+			if fn, ok := obj.(*object.BuiltinFunct); ok {
+				funcs = append(funcs, &Func{Name: name + "." + member, Params: fn.Params, Doc: fn.Doc})
+			}
+		}
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].Name < funcs[j].Name })
+	return funcs
+}
+
+// Constants documents the members of the builtin modules that are
+// not functions, sorted by name.
+func Constants() []*Const {
+	var consts []*Const
+	for name, mod := range evaluator.Modules() {
+		for member, obj := range mod.Members {
+			if _, ok := obj.(*object.BuiltinFunct); ok {
+				continue
+			}
+			consts = append(consts, &Const{
+				Name:  name + "." + member,
+				Type:  obj.Type().String(),
+				Value: obj.Inspect(),
+				Doc:   mod.Docs[member],
+			})
+		}
+	}
+	sort.Slice(consts, func(i, j int) bool { return consts[i].Name < consts[j].Name })
+	return consts
+}
+
+// Text returns the text of the doc comment among comments, the
+// comments ending on the lines directly above line. Comment markers
+// are removed.
+func Text(comments []token.Token, line int) string {
+	start := len(comments)
+	for i := len(comments) - 1; i >= 0; i-- {
+		c := comments[i]
+		end := c.Pos.Line + strings.Count(c.Literal, "\n")
+		if end != line-1 {
+			break
+		}
+		start, line = i, c.Pos.Line
+	}
+	var lines []string
+	for _, c := range comments[start:] {
+		text := c.Literal
+		if strings.HasPrefix(text, "/*") {
+			text = strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
+		} else {
+			text = strings.TrimPrefix(text, "//")
+		}
+		for _, l := range strings.Split(text, "\n") {
+			l = strings.TrimSpace(l)
+			l = strings.TrimSpace(strings.TrimPrefix(l, "*"))
+			lines = append(lines, l)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// Signature returns how f is called, such as push(arr, value).
+func (f *Func) Signature() string {
+	return fmt.Sprintf("%s(%s)", f.Name, f.Params)
+}
+
+// Declaration returns the constant as declared with its type, such
+// as time.hour Duration = 1h0m0s.
+func (c *Const) Declaration() string {
+	return fmt.Sprintf("%s %s = %s", c.Name, c.Type, c.Value)
+}
+
+// WriteText writes the documentation as plain text.
+func (p *Package) WriteText(w io.Writer) error {
+	var buf strings.Builder
+	section := func(title string, funcs []*Func) {
+		if len(funcs) == 0 {
+			return
+		}
+		fmt.Fprintf(&buf, "%s\n\n", title)
+		for _, f := range funcs {
+			fmt.Fprintf(&buf, "fn %s", f.Signature())
+			if f.File != "" {
+				fmt.Fprintf(&buf, "  // %s:%s", f.File, f.Pos)
+			}
+			buf.WriteByte('\n')
+			if f.Doc != "" {
+				fmt.Fprintf(&buf, "    %s\n", strings.Replace(f.Doc, "\n", "\n    ", -1))
+			}
+			buf.WriteByte('\n')
+		}
+	}
+	section("FUNCTIONS", p.Funcs)
+	section("BUILTINS", p.Builtins)
+	if len(p.Constants) != 0 {
+		buf.WriteString("CONSTANTS\n\n")
+		for _, c := range p.Constants {
+			fmt.Fprintf(&buf, "const %s\n", c.Declaration())
+			if c.Doc != "" {
+				fmt.Fprintf(&buf, "    %s\n", c.Doc)
+			}
+			buf.WriteByte('\n')
+		}
+	}
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// WriteHTML writes the documentation as a static HTML page.
+func (p *Package) WriteHTML(w io.Writer) error {
+	t := template.Must(page.Clone())
+	return t.Funcs(template.FuncMap{"link": p.link}).Execute(w, p)
+}
+
+// docLink matches references to functions in doc comments.
+var docLink = regexp.MustCompile(`\[([A-Za-z_][A-Za-z_.]*)\]`)
+
+// link escapes doc for HTML, turning references to known functions
+// into links to their documentation.
+func (p *Package) link(doc string) template.HTML {
+	known := map[string]bool{}
+	for _, f := range p.Funcs {
+		known[f.Name] = true
+	}
+	for _, f := range p.Builtins {
+		known[f.Name] = true
+	}
+	for _, c := range p.Constants {
+		known[c.Name] = true
+	}
+	var buf strings.Builder
+	last := 0
+	for _, m := range docLink.FindAllStringSubmatchIndex(doc, -1) {
+		name := doc[m[2]:m[3]]
+		if !known[name] {
+			continue
+		}
+		buf.WriteString(template.HTMLEscapeString(doc[last:m[0]]))
+		fmt.Fprintf(&buf, `<a href="#%s">%s</a>`,
+			template.HTMLEscapeString(name), template.HTMLEscapeString(name))
+		last = m[1]
+	}
+	buf.WriteString(template.HTMLEscapeString(doc[last:]))
+	return template.HTML(buf.String())
+}
+
+// page is executed with a link function bound to the package.
+var page = template.Must(template.New("doc").Funcs(template.FuncMap{
+	"link": func(string) template.HTML { return "" },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Monkey documentation</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: auto; }
+pre, code { font-family: monospace; }
+.doc { white-space: pre-wrap; margin-left: 2em; }
+</style>
+</head>
+<body>
+<h1>Index</h1>
+<ul>
+{{range .Funcs}}<li><a href="#{{.Name}}">{{.Signature}}</a></li>
+{{end}}{{range .Builtins}}<li><a href="#{{.Name}}">{{.Signature}}</a></li>
+{{end}}{{range .Constants}}<li><a href="#{{.Name}}">{{.Name}}</a></li>
+{{end}}</ul>
+{{if .Funcs}}<h1>Functions</h1>
+{{range .Funcs}}<h2 id="{{.Name}}"><code>fn {{.Signature}}</code></h2>
+<p><small>{{.File}}:{{.Pos}}</small></p>
+<div class="doc">{{link .Doc}}</div>
+{{end}}{{end}}{{if .Builtins}}<h1>Builtins</h1>
+{{range .Builtins}}<h2 id="{{.Name}}"><code>fn {{.Signature}}</code></h2>
+<div class="doc">{{link .Doc}}</div>
+{{end}}{{end}}{{if .Constants}}<h1>Constants</h1>
+{{range .Constants}}<h2 id="{{.Name}}"><code>const {{.Declaration}}</code></h2>
+<div class="doc">{{link .Doc}}</div>
+{{end}}{{end}}</body>
+</html>
+`))
@@ -0,0 +1,145 @@
+package doc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/lexer"
+	"github.com/emb/play/monkey/parser"
+)
+
+const lib = `// Package comments are not attached to a function.
+
+// sum adds the numbers of an array,
+// see also [add] and [push].
+let sum = fn(xs, acc = 0) {
+  if (len(xs) == 0) { return acc; }
+  sum(rest(xs), add(acc, first(xs)))
+};
+
+/* add returns
+ * x + y, never <nil>. */
+let add = fn(x, y) { x + y };
+
+// not documented as it is not a function
+let answer = 42;
+
+// separated from its function
+
+let all = fn(...args) { args };
+`
+
+func parse(t *testing.T, src string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.NewWithComments(src))
+	program := p.Program()
+	if errs := p.Errors(); len(errs) != 0 {
+		t.Fatalf("parser errors: %v", errs)
+	}
+	return program
+}
+
+func TestNew(t *testing.T) {
+	pkg := New(map[string]*ast.Program{"lib.mk": parse(t, lib)})
+	want := []struct {
+		signature string
+		doc       string
+		pos       string
+	}{
+		{"add(x, y)", "add returns\nx + y, never <nil>.", "12:1"},
+		{"all(...args)", "", "19:1"},
+		{"sum(xs, acc = 0)", "sum adds the numbers of an array,\nsee also [add] and [push].", "5:1"},
+	}
+	if len(pkg.Funcs) != len(want) {
+		t.Fatalf("got %d funcs, want %d", len(pkg.Funcs), len(want))
+	}
+	for i, w := range want {
+		f := pkg.Funcs[i]
+		if f.Signature() != w.signature || f.Doc != w.doc || f.Pos.String() != w.pos || f.File != "lib.mk" {
+			t.Errorf("func[%d] is %s %q at %s:%s, want %s %q at lib.mk:%s",
+				i, f.Signature(), f.Doc, f.File, f.Pos, w.signature, w.doc, w.pos)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	funcs := map[string]*Func{}
+	for _, f := range Builtins() {
+		funcs[f.Name] = f
+	}
+	for _, sig := range []string{"push(arr, value)", "len(x)", "io.lines(path)", "json.stringify(value, indent = \"\")", "spawn(fn, ...args)"} {
+		name := sig[:strings.Index(sig, "(")]
+		f, ok := funcs[name]
+		if !ok {
+			t.Errorf("builtin %s is not documented", name)
+			continue
+		}
+		if f.Signature() != sig || f.Doc == "" {
+			t.Errorf("builtin %s is documented as %s %q", name, f.Signature(), f.Doc)
+		}
+	}
+	if f, ok := funcs["time.hour"]; ok {
+		t.Errorf("constant time.hour is documented as a function %s", f.Signature())
+	}
+}
+
+func TestConstants(t *testing.T) {
+	consts := map[string]*Const{}
+	for _, c := range Constants() {
+		consts[c.Name] = c
+		if c.Doc == "" {
+			t.Errorf("constant %s is not documented", c.Name)
+		}
+	}
+	for _, decl := range []string{"time.second Duration = 1s", "time.hour Duration = 1h0m0s"} {
+		name := decl[:strings.Index(decl, " ")]
+		c, ok := consts[name]
+		if !ok {
+			t.Errorf("constant %s is missing", name)
+			continue
+		}
+		if c.Declaration() != decl {
+			t.Errorf("constant %s is declared as %s, want %s", name, c.Declaration(), decl)
+		}
+	}
+	if _, ok := consts["time.now"]; ok {
+		t.Error("function time.now is documented as a constant")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	pkg := New(map[string]*ast.Program{"lib.mk": parse(t, lib)})
+	var text bytes.Buffer
+	if err := pkg.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"FUNCTIONS\n\nfn add(x, y)  // lib.mk:12:1\n    add returns\n    x + y, never <nil>.\n",
+		"BUILTINS\n\n",
+		"fn push(arr, value)\n    Returns a new array",
+		"CONSTANTS\n\n",
+		"const time.hour Duration = 1h0m0s\n    One hour, sixty minutes.\n",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text does not hold %q:\n%s", want, text.String())
+		}
+	}
+
+	var html bytes.Buffer
+	if err := pkg.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<h2 id="sum"><code>fn sum(xs, acc = 0)</code></h2>`,
+		`see also <a href="#add">add</a> and <a href="#push">push</a>.`,
+		`x + y, never &lt;nil&gt;.`,
+		`<h2 id="io.lines">`,
+		`<h2 id="time.hour"><code>const time.hour Duration = 1h0m0s</code></h2>`,
+	} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("html does not hold %q:\n%s", want, html.String())
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package evaluator
+
+import (
+	"testing"
+
+	"github.com/emb/play/monkey/lexer"
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/parser"
+)
+
+const benchFib = `
+let fib = fn(n) {
+  if (n < 2) { return n; }
+  fib(n - 1) + fib(n - 2);
+};
+fib(20);
+`
+
+const benchStrings = `
+let build = fn(s, n) {
+  if (n == 0) { return s; }
+  build(s + "monkey", n - 1);
+};
+len(build("", 500));
+`
+
+const benchHashes = `
+let fill = fn(acc, n) {
+  if (n == 0) { return acc; }
+  let h = {"n": n, "double": n * 2, [n, n]: true};
+  fill(push(acc, h), n - 1);
+};
+let sum = fn(hs, total) {
+  if (len(hs) == 0) { return total; }
+  let h = first(hs);
+  let n = h["n"];
+  sum(rest(hs), total + h["double"] + len(keys(h)) + n);
+};
+sum(fill([], 200), 0);
+`
+
+func benchProgram(b *testing.B, input string) {
+	parse := parser.New(lexer.New(input))
+	program := parse.Program()
+	if errs := parse.Errors(); len(errs) != 0 {
+		b.Fatalf("parse failed: %v", errs)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Eval(program, object.NewEnvironment()); err != nil {
+			b.Fatalf("eval failed: %s", err)
+		}
+	}
+}
+
+func BenchmarkFib(b *testing.B)     { benchProgram(b, benchFib) }
+func BenchmarkStrings(b *testing.B) { benchProgram(b, benchStrings) }
+func BenchmarkHashes(b *testing.B)  { benchProgram(b, benchHashes) }
+
+func BenchmarkIntegerArithmetic(b *testing.B) {
+	benchProgram(b, "let x = 10; (x * 3 + 7) / 2 - x + 1 * 5 - 100")
+}
@@ -0,0 +1,90 @@
+// Package evaltest reads the tables of programs the evaluator is
+// tested against, which the packages translating or rewriting programs
+// share to check they keep the behavior of the evaluator.
+//
+// A table is a text file of tests separated by blank lines. The last
+// line of a test is the inspected value its program evaluates to, or
+// the message of the error it fails with, and the lines before it are
+// the program. Lines starting with # are comments.
+package evaltest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is the directory holding the tables, relative to the directory
+// of a package of the interpreter.
+const Dir = "../evaluator/testdata"
+
+// Test is a program along with what it evaluates to.
+type Test struct {
+	Pos   string // file and line of the program
+	Input string
+	Want  string
+}
+
+// Load reads the tests of the table in file.
+func Load(file string) ([]Test, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		tests []Test
+		lines []string
+		start int
+	)
+	end := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		if len(lines) == 1 {
+			return fmt.Errorf("%s:%d: test without a program", file, start)
+		}
+		tests = append(tests, Test{
+			Pos:   fmt.Sprintf("%s:%d", filepath.Base(file), start),
+			Input: strings.Join(lines[:len(lines)-1], "\n"),
+			Want:  lines[len(lines)-1],
+		})
+		lines = nil
+		return nil
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		switch {
+		case strings.HasPrefix(line, "#"):
+		case line == "":
+			if err := end(); err != nil {
+				return nil, err
+			}
+		default:
+			if len(lines) == 0 {
+				start = i + 1
+			}
+			lines = append(lines, line)
+		}
+	}
+	if err := end(); err != nil {
+		return nil, err
+	}
+	return tests, nil
+}
+
+// LoadAll reads the tests of every table in dir.
+func LoadAll(dir string) ([]Test, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+	var tests []Test
+	for _, file := range files {
+		t, err := Load(file)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, t...)
+	}
+	return tests, nil
+}
@@ -2,8 +2,12 @@
 package evaluator
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"time"
 
 	"github.com/emb/play/monkey/ast"
 	"github.com/emb/play/monkey/object"
@@ -12,9 +16,9 @@ import (
 
 // easy references that can be used by the evaluator.
 var (
-	null = object.Nul{}
-	yes  = object.Bool(true)
-	no   = object.Bool(false)
+	null = object.Nil
+	yes  = object.True
+	no   = object.False
 )
 
 // OpTypeMismatch describes a type mismatch in an infix operator
@@ -49,7 +53,7 @@ type BadInfixOp struct {
 
 // Error returns a string describing the error
 func (e BadInfixOp) Error() string {
-	return fmt.Sprintf("bad operation: %s %s %s", e.left, e.op, e.left)
+	return fmt.Sprintf("bad operation: %s %s %s", e.left, e.op, e.right)
 }
 
 // UnboundIdent is an error returned if an identifier is not
@@ -63,6 +67,17 @@ func (e UnboundIdent) Error() string {
 	return fmt.Sprintf("unbound identifier: %s", e.ident)
 }
 
+// ConstRebind is an error returned when binding an identifier bound by
+// a const statement.
+type ConstRebind struct {
+	ident string
+}
+
+// Error returns a string describing the error
+func (e ConstRebind) Error() string {
+	return fmt.Sprintf("cannot rebind constant %s", e.ident)
+}
+
 // BadFn is an error that happens when trying to evaluate a call
 // expression on a non function object.
 type BadFn struct {
@@ -101,38 +116,53 @@ func (b BadBuiltinNArgs) Error() string {
 		b.got, b.name, b.nargs)
 }
 
+// BadArity describes calling a function with the wrong number of
+// arguments, max is negative for variadic functions.
+type BadArity struct {
+	min int
+	max int
+	got int
+}
+
+// Error returns a string describing the error
+func (b BadArity) Error() string {
+	switch {
+	case b.max < 0:
+		return fmt.Sprintf("bad number of arguments %d to fn which expects at least %d",
+			b.got, b.min)
+	case b.min == b.max:
+		return fmt.Sprintf("bad number of arguments %d to fn which expects %d",
+			b.got, b.min)
+	default:
+		return fmt.Sprintf("bad number of arguments %d to fn which expects %d to %d",
+			b.got, b.min, b.max)
+	}
+}
+
+// PermissionDenied is an error returned when a builtin needs access to
+// the host that was not allowed.
+type PermissionDenied struct {
+	name string
+	flag string
+}
+
+// Error returns a string describing the error
+func (p PermissionDenied) Error() string {
+	return fmt.Sprintf("permission denied: %s requires %s", p.name, p.flag)
+}
+
 // ErrUnexpected is an unexpected error within the evaluator it should
 // not happen
 var ErrUnexpected = errors.New("unexpected error")
 
-var builtins = map[string]*object.BuiltinFunct{
-	"len": &object.BuiltinFunct{
-		Fn: func(args ...object.Object) (object.Object, error) {
-			if len(args) != 1 {
-				return nil, BadBuiltinNArgs{
-					name:  "len",
-					nargs: 1,
-					got:   len(args),
-				}
-			}
-			switch arg := args[0].(type) {
-			case *object.Str:
-				result := object.Int(len(*arg))
-				return &result, nil
-			case object.Arr:
-				result := object.Int(len(arg))
-				return &result, nil
-			default:
-				return nil, BadBuiltinArg{
-					name:    "len",
-					argtype: args[0].Type(),
-				}
-			}
+// errDivisionByZero is returned by integer divisions by zero.
+var errDivisionByZero = errors.New("division by zero")
 
-		},
-	},
+var builtins = map[string]*object.BuiltinFunct{
 	"first": &object.BuiltinFunct{
-		Fn: func(args ...object.Object) (object.Object, error) {
+		Params: "arr",
+		Doc:    "Returns the first element of an array, or null if it is empty.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
 			if len(args) != 1 {
 				return nil, BadBuiltinNArgs{
 					name:  "first",
@@ -150,11 +180,13 @@ var builtins = map[string]*object.BuiltinFunct{
 			if len(arr) > 0 {
 				return arr[0], nil
 			}
-			return &null, nil
+			return null, nil
 		},
 	},
 	"last": &object.BuiltinFunct{
-		Fn: func(args ...object.Object) (object.Object, error) {
+		Params: "arr",
+		Doc:    "Returns the last element of an array, or null if it is empty.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
 			if len(args) != 1 {
 				return nil, BadBuiltinNArgs{
 					name:  "last",
@@ -172,11 +204,13 @@ var builtins = map[string]*object.BuiltinFunct{
 			if len(arr) > 0 {
 				return arr[len(arr)-1], nil
 			}
-			return &null, nil
+			return null, nil
 		},
 	},
 	"rest": &object.BuiltinFunct{
-		Fn: func(args ...object.Object) (object.Object, error) {
+		Params: "arr",
+		Doc:    "Returns a new array holding every element of arr but the first, or null if it is empty.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
 			if len(args) != 1 {
 				return nil, BadBuiltinNArgs{
 					name:  "rest",
@@ -196,11 +230,13 @@ var builtins = map[string]*object.BuiltinFunct{
 				copy(ret, arr[1:])
 				return object.Arr(ret), nil
 			}
-			return &null, nil
+			return null, nil
 		},
 	},
 	"push": &object.BuiltinFunct{
-		Fn: func(args ...object.Object) (object.Object, error) {
+		Params: "arr, value",
+		Doc:    "Returns a new array holding the elements of arr followed by value.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
 			if len(args) != 2 {
 				return nil, BadBuiltinNArgs{
 					name:  "push",
@@ -221,16 +257,239 @@ var builtins = map[string]*object.BuiltinFunct{
 			return object.Arr(ret), nil
 		},
 	},
-	"puts": &object.BuiltinFunct{
-		Fn: func(args ...object.Object) (object.Object, error) {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
+	"keys": &object.BuiltinFunct{
+		Params: "hash",
+		Doc:    "Returns the keys of a hash in insertion order.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) != 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "keys",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			hash, ok := args[0].(*object.HashMap)
+			if !ok {
+				return nil, BadBuiltinArg{
+					name:    "keys",
+					argtype: args[0].Type(),
+				}
+			}
+			pairs := hash.Pairs()
+			ret := make([]object.Object, len(pairs))
+			for i, p := range pairs {
+				ret[i] = p.Key
 			}
-			return &null, nil
+			return object.Arr(ret), nil
+		},
+	},
+	"values": &object.BuiltinFunct{
+		Params: "hash",
+		Doc:    "Returns the values of a hash in insertion order.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) != 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "values",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			hash, ok := args[0].(*object.HashMap)
+			if !ok {
+				return nil, BadBuiltinArg{
+					name:    "values",
+					argtype: args[0].Type(),
+				}
+			}
+			pairs := hash.Pairs()
+			ret := make([]object.Object, len(pairs))
+			for i, p := range pairs {
+				ret[i] = p.Value
+			}
+			return object.Arr(ret), nil
+		},
+	},
+}
+
+// modules are builtin values grouping related functions, accessed
+// with a member expression such as os.getenv.
+var modules = map[string]*object.Mod{
+	"io": &object.Mod{
+		Name: "io",
+		Members: map[string]object.Object{
+			"read_file": &object.BuiltinFunct{
+				Fn:     ioReadFile,
+				Params: "path",
+				Doc:    "Returns the content of a file.",
+			},
+			"write_file": &object.BuiltinFunct{
+				Fn:     ioWriteFile,
+				Params: "path, data",
+				Doc:    "Writes data to a file, replacing its content.",
+			},
+			"lines": &object.BuiltinFunct{
+				Fn:     ioLines,
+				Params: "path",
+				Doc:    "Returns the lines of a file.",
+			},
+			"read_line": &object.BuiltinFunct{
+				Fn:  ioReadLine,
+				Doc: "Reads a line from stdin, or null at the end of input.",
+			},
+			"read_all": &object.BuiltinFunct{
+				Fn:  ioReadAll,
+				Doc: "Reads the rest of stdin.",
+			},
+		},
+	},
+	"os": &object.Mod{
+		Name: "os",
+		Members: map[string]object.Object{
+			"getenv": &object.BuiltinFunct{
+				Fn:     osGetenv,
+				Params: "name",
+				Doc:    "Returns the value of an environment variable, or null if it is not set.",
+			},
+			"glob": &object.BuiltinFunct{
+				Fn:     osGlob,
+				Params: "pattern",
+				Doc:    "Returns the names of the files matching a pattern.",
+			},
+			"exec": &object.BuiltinFunct{
+				Fn:     osExec,
+				Params: "cmd, ...args",
+				Doc:    "Runs a command and returns a hash holding its stdout, stderr and exit code.",
+			},
+		},
+	},
+	"json": &object.Mod{
+		Name: "json",
+		Members: map[string]object.Object{
+			"parse": &object.BuiltinFunct{
+				Fn:     jsonParse,
+				Params: "s",
+				Doc:    "Parses a JSON document.",
+			},
+			"stringify": &object.BuiltinFunct{
+				Fn:     jsonStringify,
+				Params: "value, indent = \"\"",
+				Doc:    "Converts a value to JSON, pretty printed given an indent.",
+			},
+			"read": &object.BuiltinFunct{
+				Fn:  jsonRead,
+				Doc: "Reads a line of newline delimited JSON from stdin, or null at the end of input.",
+			},
+		},
+	},
+	// re.replace is added by init as it applies functions.
+	"re": &object.Mod{
+		Name: "re",
+		Members: map[string]object.Object{
+			"compile": &object.BuiltinFunct{
+				Fn:     reCompile,
+				Params: "pattern",
+				Doc:    "Compiles a regular expression, the other functions of re also accept a pattern.",
+			},
+			"match": &object.BuiltinFunct{
+				Fn:     reMatch,
+				Params: "re, s",
+				Doc:    "Returns the first match of re in s followed by its groups, or null if there is none.",
+			},
+			"find_all": &object.BuiltinFunct{
+				Fn:     reFindAll,
+				Params: "re, s",
+				Doc:    "Returns the matches of re in s, each followed by its groups in an array if re has groups.",
+			},
+			"split": &object.BuiltinFunct{
+				Fn:     reSplit,
+				Params: "re, s",
+				Doc:    "Returns the parts of s separated by the matches of re, along with the groups of each match.",
+			},
+		},
+	},
+	"time": &object.Mod{
+		Name: "time",
+		Members: map[string]object.Object{
+			"now": &object.BuiltinFunct{
+				Fn:  timeNow,
+				Doc: "Returns the current local time.",
+			},
+			"unix": &object.BuiltinFunct{
+				Fn:     timeUnix,
+				Params: "seconds",
+				Doc:    "Returns the UTC time of a number of seconds since the Unix epoch.",
+			},
+			"to_unix": &object.BuiltinFunct{
+				Fn:     timeToUnix,
+				Params: "t",
+				Doc:    "Returns the number of seconds elapsed since the Unix epoch.",
+			},
+			"parse": &object.BuiltinFunct{
+				Fn:     timeParse,
+				Params: "s, layout = \"RFC3339\"",
+				Doc:    "Parses a time given the name of a layout such as RFC1123 or DateOnly, or a Go layout.",
+			},
+			"format": &object.BuiltinFunct{
+				Fn:     timeFormat,
+				Params: "t, layout = \"RFC3339\"",
+				Doc:    "Formats a time given the name of a layout such as RFC1123 or DateOnly, or a Go layout.",
+			},
+			"in": &object.BuiltinFunct{
+				Fn:     timeIn,
+				Params: "t, zone",
+				Doc:    "Returns the same instant in an IANA zone such as \"Europe/Paris\", \"UTC\" or \"Local\".",
+			},
+			"zone": &object.BuiltinFunct{
+				Fn:     timeZone,
+				Params: "t",
+				Doc:    "Returns the name of the zone of a time and its offset from UTC.",
+			},
+			"add_date": &object.BuiltinFunct{
+				Fn:     timeAddDate,
+				Params: "t, years, months, days",
+				Doc:    "Adds years, months and days to a time.",
+			},
+			"duration": &object.BuiltinFunct{
+				Fn:     timeDuration,
+				Params: "s",
+				Doc:    "Parses a duration such as \"1h30m\" or \"-1.5s\".",
+			},
+			"nanosecond":  objd(time.Nanosecond),
+			"microsecond": objd(time.Microsecond),
+			"millisecond": objd(time.Millisecond),
+			"second":      objd(time.Second),
+			"minute":      objd(time.Minute),
+			"hour":        objd(time.Hour),
+		},
+		Docs: map[string]string{
+			"nanosecond":  "One nanosecond, the smallest duration.",
+			"microsecond": "One microsecond, a thousand nanoseconds.",
+			"millisecond": "One millisecond, a thousand microseconds.",
+			"second":      "One second, a thousand milliseconds.",
+			"minute":      "One minute, sixty seconds.",
+			"hour":        "One hour, sixty minutes.",
 		},
 	},
 }
 
+// Builtins returns the builtin functions by name.
+func Builtins() map[string]*object.BuiltinFunct {
+	m := make(map[string]*object.BuiltinFunct, len(builtins))
+	for name, fn := range builtins {
+		m[name] = fn
+	}
+	return m
+}
+
+// Modules returns the builtin modules by name.
+func Modules() map[string]*object.Mod {
+	m := make(map[string]*object.Mod, len(modules))
+	for name, mod := range modules {
+		m[name] = mod
+	}
+	return m
+}
+
 // Eval evaluates the Monkey AST.
 func Eval(node ast.Node, env *object.Environment) (object.Object, error) {
 	switch n := node.(type) {
@@ -240,6 +499,9 @@ func Eval(node ast.Node, env *object.Environment) (object.Object, error) {
 		// of the last statement in Monkey. Furthermore,
 		// receiving a return object requires the result to be
 		// unwrapped.
+		rt := runtimeOf(env)
+		rt.sched.enter()
+		defer rt.sched.exit()
 		result, err := evalStmts(n.Statements, env)
 		if err != nil {
 			return nil, err
@@ -248,24 +510,34 @@ func Eval(node ast.Node, env *object.Environment) (object.Object, error) {
 	case *ast.ExpressionStmt:
 		return Eval(n.Expression, env)
 	case *ast.BlockStmt:
+		if n.Locals > 0 {
+			env = object.NewEnclosedEnvironment(env, n.Locals)
+		}
 		return evalStmts(n.Statements, env)
 	case *ast.ReturnStmt:
 		v, err := Eval(n.Value, env)
 		return &object.Ret{Value: v}, err
 	case *ast.LetStmt:
-		result, err := Eval(n.Value, env)
-		if err != nil {
-			return nil, err
-		}
-		env.Set(n.Name.Value, result)
-		return nil, nil
+		return nil, evalLet(n, env)
 	// Expressions
 	case *ast.IntegerLiteral:
-		i := object.Int(n.Value)
-		return &i, nil
+		if n.Big != nil {
+			return object.NewBigInt(n.Big), nil
+		}
+		return obji(n.Value), nil
 	case *ast.StringLiteral:
 		s := object.Str(n.Value)
 		return &s, nil
+	case *ast.TemplateLiteral:
+		parts, err := evalExprs(n.Parts, env)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		for _, p := range parts {
+			buf.WriteString(tostr(env, p))
+		}
+		return objs(buf.String()), nil
 	case *ast.Boolean:
 		return objb(n.Value), nil
 	case *ast.ArrayLiteral:
@@ -299,7 +571,7 @@ func Eval(node ast.Node, env *object.Environment) (object.Object, error) {
 		if err != nil {
 			return nil, err
 		}
-		return evalInfix(n.Operator, l, r)
+		return evalInfix(env, n.Operator, l, r)
 	case *ast.IfExpr:
 		condition, err := Eval(n.Condition, env)
 		if err != nil {
@@ -310,23 +582,39 @@ func Eval(node ast.Node, env *object.Environment) (object.Object, error) {
 		} else if n.Alternative != nil {
 			return Eval(n.Alternative, env)
 		} else {
-			return &null, nil
+			return null, nil
 		}
 	case *ast.Identifier:
+		if n.Local {
+			if v := env.Slot(n.Depth, n.Slot); v != nil {
+				return v, nil
+			}
+			return nil, UnboundIdent{ident: n.Value}
+		}
 		if v, ok := env.Get(n.Value); ok {
 			return v, nil
 		}
 		if b, ok := builtins[n.Value]; ok {
 			return b, nil
 		}
+		if m, ok := modules[n.Value]; ok {
+			return m, nil
+		}
 		return nil, UnboundIdent{ident: n.Value}
 	case *ast.FunctionLiteral:
 		return &object.Funct{
 			Env:        env,
 			Parameters: n.Parameters,
+			Defaults:   n.Defaults,
+			Rest:       n.Rest,
 			Body:       n.Body,
+			Locals:     n.Locals,
+			Generator:  n.Generator,
 		}, nil
 	case *ast.CallExpr:
+		if m, ok := n.Function.(*ast.MemberExpr); ok {
+			return evalMethod(m, n.Arguments, env)
+		}
 		fn, err := Eval(n.Function, env)
 		if err != nil {
 			return nil, err
@@ -335,7 +623,36 @@ func Eval(node ast.Node, env *object.Environment) (object.Object, error) {
 		if err != nil {
 			return nil, err
 		}
-		return apply(fn, args)
+		return apply(env, fn, args)
+	case *ast.YieldExpr:
+		return evalYield(n, env)
+	case *ast.SelectExpr:
+		return evalSelect(n, env)
+	case *ast.MatchExpr:
+		return evalMatch(n, env)
+	case *ast.MemberExpr:
+		left, err := Eval(n.Left, env)
+		if err != nil {
+			return nil, err
+		}
+		return evalMember(left, n.Member.Value)
+	case *ast.SliceExpr:
+		left, err := Eval(n.Left, env)
+		if err != nil {
+			return nil, err
+		}
+		var start, end object.Object
+		if n.Start != nil {
+			if start, err = Eval(n.Start, env); err != nil {
+				return nil, err
+			}
+		}
+		if n.End != nil {
+			if end, err = Eval(n.End, env); err != nil {
+				return nil, err
+			}
+		}
+		return evalSlice(left, start, end)
 	case *ast.IndexExpr:
 		left, err := Eval(n.Left, env)
 		if err != nil {
@@ -345,22 +662,73 @@ func Eval(node ast.Node, env *object.Environment) (object.Object, error) {
 		if err != nil {
 			return nil, err
 		}
-		return evalIndex(left, index)
+		return evalIndex(env, left, index)
 	}
 
 	return nil, ErrUnexpected
 }
 
-func objb(b bool) *object.Bool {
-	if b {
-		return &yes
+func objb(b bool) *object.Bool { return object.NewBool(b) }
+
+func obji(i int64) *object.Int { return object.NewInt(i) }
+
+func objs(s string) *object.Str {
+	r := object.Str(s)
+	return &r
+}
+
+// tostr converts an object to a string for display in env, strings
+// are not quoted.
+func tostr(env *object.Environment, o object.Object) string {
+	if s, ok := o.(*object.Str); ok {
+		return string(*s)
 	}
-	return &no
+	return Inspect(env, o)
 }
 
-func obji(i int64) *object.Int {
-	r := object.Int(i)
-	return &r
+// evalLet binds the value of a let or const statement. Rebinding a
+// local is rejected by the parser, rebinding a global constant is
+// checked here since later programs, such as the lines of a REPL, are
+// evaluated in the same environment.
+func evalLet(n *ast.LetStmt, env *object.Environment) error {
+	result, err := Eval(n.Value, env)
+	if err != nil {
+		return err
+	}
+	names := []*ast.Identifier{n.Name}
+	if n.Pattern != nil {
+		names = n.Pattern.Names()
+	}
+	for _, i := range names {
+		if !i.Local && env.Constant(i.Value) {
+			return ConstRebind{ident: i.Value}
+		}
+	}
+	if n.Pattern != nil {
+		if err := destructure(env, n.Pattern, result); err != nil {
+			return err
+		}
+	} else {
+		bind(env, n.Name, result)
+	}
+	if n.Const() {
+		for _, i := range names {
+			if !i.Local {
+				env.SetConstant(i.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// bind binds value v to the identifier i, either in the slot resolved
+// for it or by name.
+func bind(env *object.Environment, i *ast.Identifier, v object.Object) {
+	if i.Local {
+		env.SetSlot(i.Depth, i.Slot, v)
+	} else {
+		env.Set(i.Value, v)
+	}
 }
 
 // evalStmts evaluate each statement and returns the result of the
@@ -391,28 +759,44 @@ func evalStmts(stmts []ast.Statement, env *object.Environment) (object.Object, e
 func evalBang(operand object.Object) (object.Object, error) {
 	switch o := operand.(type) {
 	case *object.Bool:
-		if *o == yes {
-			return &no, nil
-		}
-		return &yes, nil
+		return objb(!bool(*o)), nil
 	case *object.Nul:
-		return &yes, nil
+		return yes, nil
 	default:
-		return &no, nil
+		return no, nil
 	}
 }
 
 func evalMinus(operand object.Object) (object.Object, error) {
-	if i, ok := operand.(*object.Int); ok {
-		negated := -(int64(*i))
-		r := object.Int(negated)
-		return &r, nil
+	switch o := operand.(type) {
+	case *object.Int:
+		if *o == math.MinInt64 {
+			return object.NewBigInt(new(big.Int).Neg(bigint(o))), nil
+		}
+		return obji(-int64(*o)), nil
+	case *object.BigInt:
+		return object.NewBigInt(new(big.Int).Neg(o.Value)), nil
+	case *object.Flt:
+		return objf(-float64(*o)), nil
 	}
 	return nil, BadPrefixOp{op: "-", right: operand.Type()}
 }
 
-func evalInfix(op string, left object.Object, right object.Object) (object.Object, error) {
+func evalInfix(env *object.Environment, op string, left object.Object, right object.Object) (object.Object, error) {
+	if v, ok, err := evalInfixProtocol(env, op, left, right); ok {
+		return v, err
+	}
 	switch {
+	case numeric(left) && numeric(right) &&
+		(left.Type() == object.Float || right.Type() == object.Float):
+		if op == token.EQ || op == token.NEQ {
+			// Integers are compared to floats exactly, as
+			// they are when used as hash keys.
+			return objb(object.Equal(nil, left, right) == (op == token.EQ)), nil
+		}
+		return evalInfixFloats(op, tofloat(left), tofloat(right))
+	case timely(left) || timely(right):
+		return evalInfixTimes(op, left, right)
 	case left.Type() != right.Type():
 		return nil, OpTypeMismatch{
 			left:  left.Type(),
@@ -424,14 +808,13 @@ func evalInfix(op string, left object.Object, right object.Object) (object.Objec
 	case left.Type() == object.String:
 		return evalInfixStrs(op, left, right)
 
-	// NOTE: the following comparisons are pointer
-	// comparisons and since we deal with all other types
-	// before we reach here we assume that what remains is
-	// a comparison of the Boolean type.
+	// NOTE: the remaining types only support equality which
+	// compares values structurally, hashes are equal regardless
+	// of the order their pairs were inserted.
 	case op == token.EQ:
-		return objb(left == right), nil
+		return objb(object.Equal(Caller(env), left, right)), nil
 	case op == token.NEQ:
-		return objb(left != right), nil
+		return objb(!object.Equal(Caller(env), left, right)), nil
 
 	default:
 		return nil, BadInfixOp{
@@ -442,17 +825,38 @@ func evalInfix(op string, left object.Object, right object.Object) (object.Objec
 	}
 }
 
+// evalInfixInts applies op to integers. Arithmetic overflowing an Int
+// is done again on big integers.
 func evalInfixInts(op string, left, right object.Object) (object.Object, error) {
-	l := int64(*left.(*object.Int))
-	r := int64(*right.(*object.Int))
+	li, lok := left.(*object.Int)
+	ri, rok := right.(*object.Int)
+	if !lok || !rok {
+		return evalInfixBigInts(op, bigint(left), bigint(right))
+	}
+	l, r := int64(*li), int64(*ri)
 	switch op {
 	case token.PLUS:
-		return obji(l + r), nil
+		if s := l + r; (s > l) == (r > 0) {
+			return obji(s), nil
+		}
+		return evalInfixBigInts(op, big.NewInt(l), big.NewInt(r))
 	case token.MINUS:
-		return obji(l - r), nil
+		if d := l - r; (d < l) == (r > 0) {
+			return obji(d), nil
+		}
+		return evalInfixBigInts(op, big.NewInt(l), big.NewInt(r))
 	case token.ASTERISK:
-		return obji(l * r), nil
+		if p := l * r; l == 0 || (p/l == r && !(l == -1 && r == math.MinInt64)) {
+			return obji(p), nil
+		}
+		return evalInfixBigInts(op, big.NewInt(l), big.NewInt(r))
 	case token.SLASH:
+		if r == 0 {
+			return nil, errDivisionByZero
+		}
+		if l == math.MinInt64 && r == -1 {
+			return evalInfixBigInts(op, big.NewInt(l), big.NewInt(r))
+		}
 		return obji(l / r), nil
 	case token.LT:
 		return objb(l < r), nil
@@ -467,6 +871,91 @@ func evalInfixInts(op string, left, right object.Object) (object.Object, error)
 	}
 }
 
+func evalInfixBigInts(op string, l, r *big.Int) (object.Object, error) {
+	switch op {
+	case token.PLUS:
+		return object.NewBigInt(new(big.Int).Add(l, r)), nil
+	case token.MINUS:
+		return object.NewBigInt(new(big.Int).Sub(l, r)), nil
+	case token.ASTERISK:
+		return object.NewBigInt(new(big.Int).Mul(l, r)), nil
+	case token.SLASH:
+		if r.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		// Quo truncates like the division of Ints.
+		return object.NewBigInt(new(big.Int).Quo(l, r)), nil
+	case token.LT:
+		return objb(l.Cmp(r) < 0), nil
+	case token.GT:
+		return objb(l.Cmp(r) > 0), nil
+	case token.EQ:
+		return objb(l.Cmp(r) == 0), nil
+	case token.NEQ:
+		return objb(l.Cmp(r) != 0), nil
+	default:
+		return nil, ErrUnexpected
+	}
+}
+
+// bigint converts an integer object to a big integer.
+func bigint(o object.Object) *big.Int {
+	switch o := o.(type) {
+	case *object.Int:
+		return big.NewInt(int64(*o))
+	case *object.BigInt:
+		return o.Value
+	}
+	return new(big.Int)
+}
+
+// numeric returns true for objects supporting arithmetic.
+func numeric(o object.Object) bool {
+	return o.Type() == object.Integer || o.Type() == object.Float
+}
+
+// tofloat converts a numeric object to a float.
+func tofloat(o object.Object) float64 {
+	switch o := o.(type) {
+	case *object.Int:
+		return float64(*o)
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(o.Value).Float64()
+		return f
+	case *object.Flt:
+		return float64(*o)
+	}
+	return 0
+}
+
+func objf(f float64) *object.Flt {
+	r := object.Flt(f)
+	return &r
+}
+
+func evalInfixFloats(op string, l, r float64) (object.Object, error) {
+	switch op {
+	case token.PLUS:
+		return objf(l + r), nil
+	case token.MINUS:
+		return objf(l - r), nil
+	case token.ASTERISK:
+		return objf(l * r), nil
+	case token.SLASH:
+		return objf(l / r), nil
+	case token.LT:
+		return objb(l < r), nil
+	case token.GT:
+		return objb(l > r), nil
+	case token.EQ:
+		return objb(l == r), nil
+	case token.NEQ:
+		return objb(l != r), nil
+	default:
+		return nil, ErrUnexpected
+	}
+}
+
 func evalInfixStrs(op string, l, r object.Object) (object.Object, error) {
 	if op != token.PLUS {
 		return nil, BadInfixOp{left: l.Type(), op: op, right: r.Type()}
@@ -480,10 +969,7 @@ func evalInfixStrs(op string, l, r object.Object) (object.Object, error) {
 func truthy(obj object.Object) bool {
 	switch o := obj.(type) {
 	case *object.Bool:
-		if *o == yes {
-			return true
-		}
-		return false
+		return bool(*o)
 	case *object.Nul:
 		return false
 	default:
@@ -491,37 +977,111 @@ func truthy(obj object.Object) bool {
 	}
 }
 
+// evalExprs evaluates a list of expressions, spread arrays are
+// expanded into the result.
 func evalExprs(exps []ast.Expression, env *object.Environment) ([]object.Object, error) {
-	result := make([]object.Object, len(exps))
-	for i, exp := range exps {
+	result := make([]object.Object, 0, len(exps))
+	for _, exp := range exps {
+		if spread, ok := exp.(*ast.SpreadExpr); ok {
+			r, err := Eval(spread.Value, env)
+			if err != nil {
+				return nil, err
+			}
+			arr, ok := r.(object.Arr)
+			if !ok {
+				return nil, fmt.Errorf("can not spread value of type %s", r.Type())
+			}
+			result = append(result, arr...)
+			continue
+		}
 		r, err := Eval(exp, env)
 		if err != nil {
 			return nil, err
 		}
-		result[i] = r
+		result = append(result, r)
 	}
 	return result, nil
 }
 
-func evalIndex(left, index object.Object) (object.Object, error) {
+// destructure binds the identifiers of pattern to the parts of v.
+// Missing elements and keys are bound to null.
+func destructure(env *object.Environment, pattern ast.Pattern, v object.Object) error {
+	switch pattern := pattern.(type) {
+	case *ast.ArrayPattern:
+		arr, ok := v.(object.Arr)
+		if !ok {
+			return fmt.Errorf("can not destructure %s as an array", v.Type())
+		}
+		for i, e := range pattern.Elements {
+			if i < len(arr) {
+				bind(env, e, arr[i])
+			} else {
+				bind(env, e, null)
+			}
+		}
+		if pattern.Rest != nil {
+			rest := object.Arr{}
+			if len(arr) > len(pattern.Elements) {
+				rest = append(rest, arr[len(pattern.Elements):]...)
+			}
+			bind(env, pattern.Rest, rest)
+		}
+	case *ast.HashPattern:
+		hash, ok := v.(*object.HashMap)
+		if !ok {
+			return fmt.Errorf("can not destructure %s as a hash", v.Type())
+		}
+		for _, k := range pattern.Keys {
+			pair, ok, err := hash.Get(nil, objs(k.Value))
+			if err != nil {
+				return err
+			}
+			if ok {
+				bind(env, k, pair.Value)
+			} else {
+				bind(env, k, null)
+			}
+		}
+	default:
+		return ErrUnexpected
+	}
+	return nil
+}
+
+func evalIndex(env *object.Environment, left, index object.Object) (object.Object, error) {
 	switch {
 	case left.Type() == object.Array && index.Type() == object.Integer:
 		arr := left.(object.Arr)
-		i := int64(*index.(*object.Int))
+		// A BigInt is out of the bounds of any array.
+		n, ok := index.(*object.Int)
+		if !ok {
+			return null, nil
+		}
+		i := int64(*n)
 		max := int64(len(arr) - 1)
 		if i < 0 || i > max {
-			return &null, nil
+			return null, nil
 		}
 		return arr[i], nil
+	case left.Type() == object.String && index.Type() == object.Integer:
+		// Strings are indexed by character.
+		runes := []rune(string(*left.(*object.Str)))
+		n, ok := index.(*object.Int)
+		if !ok || *n < 0 || int64(*n) >= int64(len(runes)) {
+			return null, nil
+		}
+		return objs(string(runes[*n])), nil
 	case left.Type() == object.Hash:
 		hash := left.(*object.HashMap)
-		key, ok := index.(object.Hashable)
-		if !ok {
-			return nil, badkey(index.Type())
+		pair, ok, err := hash.Get(Caller(env), index)
+		if err != nil {
+			return nil, err
 		}
-		pair, ok := hash.Pairs[key.HashKey()]
 		if !ok {
-			return &null, nil
+			if fn := hash.Protocol("__index__"); fn != nil {
+				return apply(env, fn, []object.Object{hash, index})
+			}
+			return null, nil
 		}
 		return pair.Value, nil
 	default:
@@ -530,51 +1090,195 @@ func evalIndex(left, index object.Object) (object.Object, error) {
 	}
 }
 
-func badkey(t object.Type) error {
-	return fmt.Errorf("bad key %s for a hash", t)
+// evalSlice returns the elements of an array or the characters of a
+// string from start up to end excluded. Bounds default to the start
+// and the end of the value and are clamped to them, nil stands for a
+// bound left out.
+func evalSlice(left, start, end object.Object) (object.Object, error) {
+	var n int
+	var runes []rune
+	switch l := left.(type) {
+	case object.Arr:
+		n = len(l)
+	case *object.Str:
+		runes = []rune(string(*l))
+		n = len(runes)
+	default:
+		return nil, fmt.Errorf("bad slice operator on type %s", left.Type())
+	}
+	lo, err := sliceBound(start, 0, n)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := sliceBound(end, n, n)
+	if err != nil {
+		return nil, err
+	}
+	if lo > hi {
+		lo = hi
+	}
+	if runes != nil {
+		return objs(string(runes[lo:hi])), nil
+	}
+	return append(object.Arr{}, left.(object.Arr)[lo:hi]...), nil
+}
+
+// sliceBound converts a bound of a slice of n elements to an index
+// between 0 and n, def is used for a bound left out.
+func sliceBound(o object.Object, def, n int) (int, error) {
+	switch o := o.(type) {
+	case nil:
+		return def, nil
+	case *object.Int:
+		if *o < 0 {
+			return 0, nil
+		}
+		if int64(*o) > int64(n) {
+			return n, nil
+		}
+		return int(*o), nil
+	case *object.BigInt:
+		if o.Value.Sign() < 0 {
+			return 0, nil
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("bad slice bound of type %s", o.Type())
+}
+
+func evalMember(left object.Object, name string) (object.Object, error) {
+	mod, ok := left.(*object.Mod)
+	if !ok {
+		return nil, fmt.Errorf("bad member access .%s on type %s",
+			name, left.Type())
+	}
+	member, ok := mod.Members[name]
+	if !ok {
+		return nil, fmt.Errorf("module %s has no member %s", mod.Name, name)
+	}
+	return member, nil
+}
+
+// evalMethod evaluates the call of a member. The members of modules
+// are their functions, calling the member of another value such as
+// a.push(1) applies the function bound to the name of the member with
+// the value as first argument, push(a, 1).
+func evalMethod(m *ast.MemberExpr, arguments []ast.Expression, env *object.Environment) (object.Object, error) {
+	left, err := Eval(m.Left, env)
+	if err != nil {
+		return nil, err
+	}
+	_, module := left.(*object.Mod)
+	var fn object.Object
+	if module {
+		fn, err = evalMember(left, m.Member.Value)
+	} else {
+		fn, err = Eval(m.Member, env)
+	}
+	if err != nil {
+		return nil, err
+	}
+	args, err := evalExprs(arguments, env)
+	if err != nil {
+		return nil, err
+	}
+	if !module {
+		args = append([]object.Object{left}, args...)
+	}
+	return apply(env, fn, args)
 }
 
 func evalHash(n *ast.HashLiteral, env *object.Environment) (object.Object, error) {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for kn, vn := range n.Pairs {
-		k, err := Eval(kn, env)
+	hash := object.NewHashMap()
+	for _, pn := range n.Pairs {
+		k, err := Eval(pn.Key, env)
 		if err != nil {
 			return nil, err
 		}
-		hk, ok := k.(object.Hashable)
-		if !ok {
-			return nil, badkey(k.Type())
-		}
-		v, err := Eval(vn, env)
+		v, err := Eval(pn.Value, env)
 		if err != nil {
 			return nil, err
 		}
-		pairs[hk.HashKey()] = object.HashPair{Key: k, Value: v}
+		if err := hash.Set(Caller(env), k, v); err != nil {
+			return nil, err
+		}
 	}
-	return &object.HashMap{Pairs: pairs}, nil
+	return hash, nil
 }
 
-func apply(fn object.Object, args []object.Object) (object.Object, error) {
+// apply applies fn to args, env is the environment of the call given
+// to builtins. Functions are applied in the runtime of the environment
+// they were defined in when env is nil.
+func apply(env *object.Environment, fn object.Object, args []object.Object) (object.Object, error) {
+	if f, ok := fn.(*object.Funct); ok && env == nil {
+		env = f.Env
+	}
+	if err := runtimeOf(env).interruptErr(); err != nil {
+		return nil, err
+	}
 	switch fn := fn.(type) {
 	case *object.Funct:
-		return Eval(fn.Body, makeFnEnv(fn, args))
+		env, err := makeFnEnv(env, fn, args)
+		if err != nil {
+			return nil, err
+		}
+		if fn.Generator {
+			return generate(fn, env), nil
+		}
+		result, err := Eval(fn.Body, env)
+		if err != nil {
+			return nil, err
+		}
+		// A return only exits the function being applied.
+		return unwrap(result), nil
 	case *object.BuiltinFunct:
-		return fn.Fn(args...)
+		return fn.Fn(env, args...)
 	default:
 		return nil, BadFn{exp: fn.Type()}
 	}
 }
 
-func makeFnEnv(fn *object.Funct, args []object.Object) *object.Environment {
-	env := object.NewEnvironment().Extend(fn.Env)
+// makeFnEnv binds args to the parameters of fn in a new environment,
+// which carries on the call state of from, the environment fn is
+// called from.
+// Missing arguments take the default of their parameter, evaluated in
+// the new environment so earlier parameters are visible.
+func makeFnEnv(from *object.Environment, fn *object.Funct, args []object.Object) (*object.Environment, error) {
+	min, max := len(fn.Parameters), len(fn.Parameters)
+	for min > 0 && min <= len(fn.Defaults) && fn.Defaults[min-1] != nil {
+		min--
+	}
+	if fn.Rest != nil {
+		max = -1
+	}
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return nil, BadArity{min: min, max: max, got: len(args)}
+	}
+	env := object.NewEnclosedEnvironment(fn.Env, fn.Locals)
+	env.SetCallState(from.CallState())
 	for i, p := range fn.Parameters {
 		// NOTE: assuming parameter evaluation order. Args are
 		// the result of evaluating the arguments of a
 		// function call, the order of the parameters and
 		// their results should match.
-		env.Set(p.Value, args[i])
+		if i < len(args) {
+			env.SetSlot(0, p.Slot, args[i])
+			continue
+		}
+		v, err := Eval(fn.Defaults[i], env)
+		if err != nil {
+			return nil, err
+		}
+		env.SetSlot(0, p.Slot, v)
+	}
+	if fn.Rest != nil {
+		rest := object.Arr{}
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		env.SetSlot(0, fn.Rest.Slot, rest)
 	}
-	return env
+	return env, nil
 }
 
 // nnwrap a return value if o is a object.ReturnValue
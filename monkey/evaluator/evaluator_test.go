@@ -1,9 +1,16 @@
 package evaluator
 
 import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/emb/play/monkey/evaluator/evaltest"
 	"github.com/emb/play/monkey/lexer"
 	"github.com/emb/play/monkey/object"
 	"github.com/emb/play/monkey/parser"
@@ -173,7 +180,7 @@ func TestErrorHandling(t *testing.T) {
 		{`"hi" - "ho"`, infixErr(object.String, "-", object.String)},
 		{
 			`{"name": "Monkey"}[fn(x) {x}]`,
-			badkey(object.Function),
+			object.Unhashable{Type: object.Function},
 		},
 	}
 	for i, tc := range tests {
@@ -260,6 +267,843 @@ addTwo(3);
 	testIntObj(t, r, 5)
 }
 
+func TestLocalBindings(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(15)", 610},
+		{"let f = fn(x) { return x; }; f(1) + f(2)", 3},
+		{"let f = fn(a) { let b = a * 2; let c = b + 1; c }; f(3)", 7},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", 6},
+		{"let x = 10; let f = fn(x) { x }; f(1) + x", 11},
+		{"let f = fn() { let g = fn(n) { if (n == 0) { 0 } else { h(n - 1) } }; let h = fn(n) { g(n) + 1 }; g(4) }; f()", 4},
+		{"let f = fn() { if (true) { let y = 5; } y }; f()", UnboundIdent{ident: "y"}},
+		{"let f = fn() { let y = y; y }; f()", UnboundIdent{ident: "y"}},
+		{"let a = fn() { b }; let b = 2; a()", 2},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			switch want := tc.want.(type) {
+			case int:
+				if err != nil {
+					t.Fatalf("eval failed: %s", err)
+				}
+				testIntObj(t, result, int64(want))
+			case error:
+				if err != want {
+					t.Errorf("error is %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockScopes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let x = 1; if (true) { let x = 2; } x", "1"},
+		{"let x = 1; let y = if (true) { let x = x + 1; x * 10 }; [x, y]", "[1, 20]"},
+		{"if (true) { let y = 5; } y", "unbound identifier: y"},
+		{"let f = fn(a) { if (a) { let b = 1; } else { let b = 2; } b }; f(true)", "unbound identifier: b"},
+		{"let f = fn(x) { let x = x * 2; x }; f(3)", "6"},
+		{"let f = if (true) { let n = 10; fn(m) { n + m } }; f(5)", "15"},
+		{"let mk = fn(n) { if (n > 0) { let k = n * 2; fn() { k } } else { let k = -1; fn() { k } } }; [mk(1)(), mk(2)(), mk(0)()]", "[2, 4, -1]"},
+		{"let f = fn() { let g = if (true) { let v = 1; fn() { v } }; let v = 2; [g(), v] }; f()", "[1, 2]"},
+		{"match [1, 2] { [a, b] => a + b }; a", "unbound identifier: a"},
+		{"const c = 3; let f = fn() { const c = 4; c }; [c, f()]", "[3, 4]"},
+		{"const [a, b] = [1, 2]; a + b", "3"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestConstRebind(t *testing.T) {
+	// Each input is a program evaluated in the same environment,
+	// as a REPL does.
+	tests := []struct {
+		input string
+		want  error
+	}{
+		{"let a = 1; const b = 2;", nil},
+		{"let a = 3;", nil},
+		{"let b = 4;", ConstRebind{ident: "b"}},
+		{"const [c, b] = [5, 6];", ConstRebind{ident: "b"}},
+		{"if (true) { let b = 7; b }", nil},
+	}
+	env := object.NewEnvironment()
+	for _, tc := range tests {
+		parse := parser.New(lexer.New(tc.input))
+		if _, err := Eval(parse.Program(), env); err != tc.want {
+			t.Errorf("evaluating %q returned error %v, want %v", tc.input, err, tc.want)
+		}
+	}
+	if v, _ := env.Get("b"); v.Inspect() != "2" {
+		t.Errorf("b is %s, want 2", v.Inspect())
+	}
+}
+
+func TestParameters(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"let f = fn(x, y = 10) { x + y }; f(1)", "11"},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2)", "3"},
+		{"let f = fn(x, y = x * 2) { x + y }; f(3)", "9"},
+		{"let f = fn(x, ...rest) { rest }; f(1, 2, 3)", "[2, 3]"},
+		{"let f = fn(x, ...rest) { rest }; f(1)", "[]"},
+		{"let f = fn(a, b, c) { a + b + c }; let xs = [1, 2, 3]; f(...xs)", "6"},
+		{"let f = fn(...xs) { len(xs) }; f(0, ...[1, 2], ...[], 3)", "4"},
+		{"let xs = [2, 3]; [1, ...xs, 4]", "[1, 2, 3, 4]"},
+		{"let f = fn(x) { x }; f()", BadArity{min: 1, max: 1, got: 0}},
+		{"let f = fn(x) { x }; f(1, 2)", BadArity{min: 1, max: 1, got: 2}},
+		{"let f = fn(x, y = 1) { x }; f(1, 2, 3)", BadArity{min: 1, max: 2, got: 3}},
+		{"let f = fn(x, ...r) { x }; f()", BadArity{min: 1, max: -1, got: 0}},
+		{"let f = fn(x) { x }; f(...1)", "can not spread value of type Integer"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			switch want := tc.want.(type) {
+			case string:
+				if err != nil {
+					if err.Error() != want {
+						t.Fatalf("eval failed: %s", err)
+					}
+					return
+				}
+				if result.Inspect() != want {
+					t.Errorf("result is %s, want %s", result.Inspect(), want)
+				}
+			case error:
+				if err != want {
+					t.Errorf("error is %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let [a, b] = [1, 2]; a + b", "3"},
+		{"let [a, b, c] = [1, 2]; c", "null"},
+		{"let [a, ...rest] = [1, 2, 3]; rest", "[2, 3]"},
+		{"let [a, b, ...rest] = [1]; rest", "[]"},
+		{`let {x, y} = {"y": 2, "x": 1}; [x, y]`, "[1, 2]"},
+		{`let {x, z} = {"x": 1}; z`, "null"},
+		{"let f = fn(p) { let [x, y] = p; x * y }; f([3, 4])", "12"},
+		{"let [a] = 1;", "can not destructure Integer as an array"},
+		{"let {a} = [1];", "can not destructure Array as a hash"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`match 1 { 1 => "one", _ => "other" }`, `"one"`},
+		{`match 2 { 1 => "one", _ => "other" }`, `"other"`},
+		{`match -1 { -1 => "minus one", _ => "other" }`, `"minus one"`},
+		{`match "a" { "a" => 1, "b" => 2 }`, "1"},
+		{`match true { false => 0, true => 1 }`, "1"},
+		{`match 5 { n => n * 2 }`, "10"},
+		{`match [1, 2] { [x] => x, [x, y] => x + y }`, "3"},
+		{`match [1, 2, 3] { [x, ...rest] => rest }`, "[2, 3]"},
+		{`match [1, [2, 3]] { [a, [b, c]] => a + b + c }`, "6"},
+		{`match [1, 2] { [1, _] => "starts with one", _ => "other" }`, `"starts with one"`},
+		{`match {"type": "a", "n": 1} { {"type": "b", ...} => "b", {"type": "a", ...} => "a" }`, `"a"`},
+		{`match {"x": 1, "y": 2} { {"x": x} => x, {"x": x, "y": y} => x + y }`, "3"},
+		{`match 3 { n if n > 5 => "big", n => "small" }`, `"small"`},
+		{`match 7 { n if n > 5 => "big", n => "small" }`, `"big"`},
+		{`let f = fn(v) { match v { [] => 0, [x, ...xs] => x + f(xs) } }; f([1, 2, 3])`, "6"},
+		{`match 1 { 1 => { let y = 2; y + 1 } }`, "3"},
+		{`match 1 { 1 => ({"a": 1}) }`, `{"a": 1}`},
+		{"let v = 3;\nmatch v {\n  1 => 1\n}", "non-exhaustive match at 2:1: no pattern matches 3"},
+		{`match "s" { [x] => x }`, `non-exhaustive match at 1:1: no pattern matches "s"`},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestSharedValues(t *testing.T) {
+	a, _ := testEval("1 + 1")
+	b, _ := testEval("4 / 2")
+	if a != b {
+		t.Errorf("small integers are not shared")
+	}
+	c, _ := testEval("!false")
+	if c != yes {
+		t.Errorf("booleans are not shared")
+	}
+}
+
+func TestTasks(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{"wait(spawn(fn(x) { x * 2 }, 21))", 42},
+		{"let t = spawn(fn() { return 3; }); wait(t) + wait(t)", 6},
+		{"wait(spawn(len, [1, 2]))", 2},
+		{`
+let ch = chan();
+let produce = fn(n) {
+  if (n > 0) { send(ch, n); produce(n - 1); }
+};
+let consume = fn(acc, n) {
+  if (n == 0) { return acc; }
+  consume(acc + recv(ch), n - 1);
+};
+spawn(produce, 10);
+consume(0, 10);
+`, 55},
+		{`
+let results = chan(3);
+let work = fn(x) { send(results, x * x) };
+spawn(work, 1); spawn(work, 2); spawn(work, 3);
+recv(results) + recv(results) + recv(results);
+`, 14},
+		{"let ch = chan(); select { case let v = recv(ch) { v } default { 7 } }", 7},
+		{"let ch = chan(1); send(ch, 3); select { case let v = recv(ch) { v * 2 } default { 0 } }", 6},
+		{"let ch = chan(1); select { case send(ch, 5) { recv(ch) } }", 5},
+		{`
+let a = chan(); let b = chan();
+spawn(fn() { send(b, 2) });
+select { case let v = recv(a) { v } case let v = recv(b) { v * 10 } }
+`, 20},
+		{"let ch = chan(1); close(ch); select { case let v = recv(ch) { v } }", nil},
+		{"let ch = chan(1); close(ch); recv(ch)", nil},
+		{"wait(spawn(fn() { 1 + true }))", OpTypeMismatch{left: object.Integer, op: "+", right: object.Boolean}},
+		{"recv(chan())", ErrDeadlock},
+		{"select {}", ErrDeadlock},
+		{"let ch = chan(); spawn(fn() { recv(ch) }); recv(ch)", ErrDeadlock},
+		{"let ch = chan(); wait(spawn(fn() { recv(ch) }))", ErrDeadlock},
+		{"let ch = chan(); close(ch); close(ch)", "close of closed channel"},
+		{"let ch = chan(1); close(ch); send(ch, 1)", "send on closed channel"},
+		{"chan(-1)", "negative channel size -1"},
+		{"send(1, 2)", BadBuiltinArg{name: "send", argtype: object.Integer}},
+		{"select { case recv(1) { 1 } }", BadBuiltinArg{name: "recv", argtype: object.Integer}},
+		{"spawn(1)", BadBuiltinArg{name: "spawn", argtype: object.Integer}},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			switch want := tc.want.(type) {
+			case int:
+				if err != nil {
+					t.Fatalf("eval failed: %s", err)
+				}
+				testIntObj(t, result, int64(want))
+			case error:
+				if err == nil || err.Error() != want.Error() {
+					t.Errorf("error is %v, want %q", err, want)
+				}
+			case string:
+				if err == nil || err.Error() != want {
+					t.Errorf("error is %v, want %q", err, want)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("eval failed: %s", err)
+				}
+				testIsNull(t, result)
+			}
+		})
+	}
+}
+
+func TestIterators(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"collect(range(4))", "[0, 1, 2, 3]"},
+		{"collect(range(2, 5))", "[2, 3, 4]"},
+		{"collect(range(10, 0, -4))", "[10, 6, 2]"},
+		{"collect(range(3, 3))", "[]"},
+		{"collect(range(9223372036854775806, 9223372036854775807, 5))", "[9223372036854775806]"},
+		{"range(1, 2, 0)", "range step can not be zero"},
+		{`range("a")`, "bad argument type String for bultin in 'range'"},
+		{`collect("añb")`, `["a", "ñ", "b"]`},
+		{`collect({"b": 1, "a": 2})`, `["b", "a"]`},
+		{"collect(map([1, 2, 3], fn(x) { x * 10 }))", "[10, 20, 30]"},
+		{"collect(filter(range(10), fn(x) { x / 3 * 3 == x }))", "[0, 3, 6, 9]"},
+		{"collect(take(map(range(1000000000000), fn(x) { x * x }), 4))", "[0, 1, 4, 9]"},
+		{"collect(take([1, 2], 5))", "[1, 2]"},
+		{`collect(zip(range(100), "ab", [true, false, true]))`, `[[0, "a", true], [1, "b", false]]`},
+		{"collect(map([1, 2], fn(x) { x + true }))", "type mismatch: Integer + Boolean"},
+		{"map([1], 2)", "bad argument type Integer for bultin in 'map'"},
+		{"map(1, fn(x) { x })", "bad argument type Integer for bultin in 'map'"},
+		{"let it = range(3); let a = first(collect(take(it, 1))); [a, collect(it)]", "[0, [1, 2]]"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestPipesAndMethods(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let a = [1, 2]; a |> push(3) |> rest |> len", "2"},
+		{"[1, 2].push(3).rest()", "[2, 3]"},
+		{"range(5) |> map(fn(x) { x * x }) |> filter(fn(x) { x > 3 }) |> collect", "[4, 9, 16]"},
+		{"let double = fn(x) { x * 2 }; let n = 5; [n.double(), n |> double]", "[10, 10]"},
+		{"let f = fn(n) { let add = fn(x, by) { x + by }; n.add(2) }; f(1)", "3"},
+		{`"[1, 2]" |> json.parse |> len`, "2"},
+		{`json.parse("3")`, "3"},
+		{`let h = {"a": 1}; h.keys()`, `["a"]`},
+		{"[1].nope()", "unbound identifier: nope"},
+		{"let a = 1; a.b", "bad member access .b on type Integer"},
+		{"1 |> 2", "bad fn call, Integer is not a function"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestStringsAndSlices(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`["añb"[1], "abc"[0], "abc"[3], "abc"[-1]]`, `["ñ", "a", null, null]`},
+		{`"añbc"[1:3]`, `"ñb"`},
+		{`let s = "hello"; [s[:2], s[3:], s[:], s[4:2], s[-5:100]]`, `["he", "lo", "hello", "", "hello"]`},
+		{"[1, 2, 3, 4][1:3]", "[2, 3]"},
+		{"let a = [1, 2, 3]; let b = a[:2]; [a, b, a[9223372036854775808:], a[:-9223372036854775809]]", "[[1, 2, 3], [1, 2], [], []]"},
+		{"[1, 2][true:]", "bad slice bound of type Boolean"},
+		{"5[1:2]", "bad slice operator on type Integer"},
+		{`{"a": 1}[:1]`, "bad slice operator on type Hash"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestRegexp(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`re.compile("a(b+)")`, `re.compile("a(b+)")`},
+		{`re.match("(\\w+)@(\\w+)?", "mail: monkey@ and more")`, `["monkey@", "monkey", null]`},
+		{`re.match(re.compile("x"), "abc")`, "null"},
+		{`re.find_all("[0-9]+", "a1 b22 c333")`, `["1", "22", "333"]`},
+		{`re.find_all("(\\w)=(\\d)", "a=1, b=2")`, `[["a=1", "a", "1"], ["b=2", "b", "2"]]`},
+		{`re.find_all("z", "abc")`, "[]"},
+		{`re.split(",\\s*", "a, b,c")`, `["a", "b", "c"]`},
+		{`re.split("(-)", "1-2")`, `["1", "-", "2"]`},
+		{`re.replace("(\\w+)@(\\w+)", "joe@home", "$2 of $1")`, `"home of joe"`},
+		{`re.replace("[0-9]+", "a1b22", fn(m) { str(len(m[0])) })`, `"a1b2"`},
+		{`let words = re.compile("[a-z]+"); re.find_all(words, "one two")`, `["one", "two"]`},
+		{`re.split("-", "2024-01-02") |> len`, "3"},
+		{`re.compile("(")`, "re.compile: error parsing regexp: missing closing ): `(`"},
+		{`re.match("(", "")`, "re.match: error parsing regexp: missing closing ): `(`"},
+		{`re.match(1, "")`, "bad argument type Integer for bultin in 're.match'"},
+		{`re.replace("a", "a", fn(m) { 1 })`, "re.replace: function returned Integer, want String"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestTime(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"time.unix(0)", "1970-01-01T00:00:00Z"},
+		{`time.to_unix(time.parse("2009-11-10T23:00:00Z"))`, "1257894000"},
+		{`time.parse("10 Nov 09 23:00 UTC", "RFC822")`, "2009-11-10T23:00:00Z"},
+		{`time.parse("2009/11/10", "2006/01/02") |> time.format("DateOnly")`, `"2009-11-10"`},
+		{`time.format(time.unix(3600), "Kitchen")`, `"1:00AM"`},
+		{`time.format(time.unix(3600))`, `"1970-01-01T01:00:00Z"`},
+		{`time.unix(0) |> time.in("Asia/Tokyo")`, "1970-01-01T09:00:00+09:00"},
+		{`time.unix(0) |> time.in("Asia/Tokyo") |> time.zone`, `["JST", 9h0m0s]`},
+		{"time.add_date(time.unix(0), 1, 1, 1)", "1971-02-02T00:00:00Z"},
+		{"time.unix(60) - time.unix(0)", "1m0s"},
+		{"time.unix(0) + time.hour", "1970-01-01T01:00:00Z"},
+		{"time.hour + time.unix(0)", "1970-01-01T01:00:00Z"},
+		{"time.unix(0) - time.second", "1969-12-31T23:59:59Z"},
+		{"[time.unix(0) < time.unix(1), time.unix(0) > time.unix(1), time.unix(0) == time.unix(0), time.unix(0) != time.unix(0)]", "[true, false, true, false]"},
+		{`time.unix(0) == time.in(time.unix(0), "Asia/Tokyo")`, "true"},
+		{`{time.unix(0): 1}[time.in(time.unix(0), "Asia/Tokyo")]`, "1"},
+		{"[time.hour + time.minute, time.hour - time.minute, 90 * time.minute, time.hour * 2, time.hour / 4]", "[1h1m0s, 59m0s, 1h30m0s, 2h0m0s, 15m0s]"},
+		{"time.hour / time.minute", "60"},
+		{`[time.minute < time.hour, time.minute == time.duration("60s"), {time.second: 1}[time.duration("1s")]]`, "[true, true, 1]"},
+		{`time.duration("1h30m")`, "1h30m0s"},
+		{"time.hour * 9223372036854775807", "duration out of range"},
+		{"time.hour / 0", "division by zero"},
+		{"time.hour / (time.hour - time.hour)", "division by zero"},
+		{"time.hour / (time.unix(0) - time.unix(0))", "division by zero"},
+		{"time.unix(0) + time.unix(0)", "bad operation: Time + Time"},
+		{"time.unix(0) + 1", "type mismatch: Time + Integer"},
+		{"time.hour - 1", "type mismatch: Duration - Integer"},
+		{`time.parse("yesterday")`, `time.parse: parsing time "yesterday" as "2006-01-02T15:04:05Z07:00": cannot parse "yesterday" as "2006"`},
+		{`time.in(time.unix(0), "Mars/Olympus")`, "time.in: unknown time zone Mars/Olympus"},
+		{`time.duration("soon")`, `time.duration: time: invalid duration "soon"`},
+		{"time.format(0)", "bad argument type Integer for bultin in 'time.format'"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestEach(t *testing.T) {
+	var out bytes.Buffer
+	result, err := testEvalRuntime(`each(zip(range(2), "xy"), fn(p) { puts(p) })`, &Runtime{Stdout: &out})
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	testIsNull(t, result)
+	if want := "[0, \"x\"]\n[1, \"y\"]\n"; out.String() != want {
+		t.Errorf("each printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestInterruptIterators(t *testing.T) {
+	// The iterators are made before the interruption, which the
+	// call to collect would see otherwise.
+	rt := &Runtime{}
+	var its []object.Object
+	for _, input := range []string{
+		"range(3)",
+		`zip("ab", [1, 2])`,
+		"take(range(10), 2)",
+		"map([1, 2], fn(x) { x })",
+	} {
+		it, err := testEvalRuntime(input, rt)
+		if err != nil {
+			t.Fatalf("eval failed: %s", err)
+		}
+		its = append(its, it)
+	}
+	rt.Interrupt(errors.New("stop"))
+	for _, it := range its {
+		if _, err := builtins["collect"].Fn(nil, it); err == nil || err.Error() != "stop" {
+			t.Errorf("collect returned %v, want stop", err)
+		}
+	}
+}
+
+func TestRuntimes(t *testing.T) {
+	var out1, out2 bytes.Buffer
+	rt1, rt2 := &Runtime{Stdout: &out1}, &Runtime{Stdout: &out2}
+	if _, err := testEvalRuntime(`puts("one")`, rt1); err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	rt1.Interrupt(errors.New("stop"))
+	if _, err := testEvalRuntime("let f = fn() { 1 }; f()", rt1); err == nil || err.Error() != "stop" {
+		t.Errorf("interrupted runtime returned %v, want stop", err)
+	}
+	result, err := testEvalRuntime(`puts("two"); let f = fn() { 2 }; f()`, rt2)
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	testIntObj(t, result, 2)
+	if out1.String() != "\"one\"\n" || out2.String() != "\"two\"\n" {
+		t.Errorf("runtimes printed %q and %q, want \"one\" and \"two\"", out1.String(), out2.String())
+	}
+}
+
+func TestRecoverTasks(t *testing.T) {
+	builtins["boom"] = &object.BuiltinFunct{
+		Fn: func(*object.Environment, ...object.Object) (object.Object, error) {
+			panic("boom")
+		},
+	}
+	defer delete(builtins, "boom")
+	for _, input := range []string{
+		"wait(spawn(boom))",
+		"let g = fn() { yield boom() }; collect(g())",
+	} {
+		if _, err := testEval(input); err == nil || err.Error() != "panic: boom" {
+			t.Errorf("%q returned %v, want panic: boom", input, err)
+		}
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let g = fn() { yield 1; yield 2 }; collect(g())", "[1, 2]"},
+		{"let g = fn(n) { if (n > 0) { yield n; yield ...[n, n] } }; [collect(g(1)), collect(g(0))]", "[[1, 1, 1], []]"},
+		{"let nat = fn(n) { yield n; yield ...nat(n + 1) }; collect(take(nat(5), 3))", "[5, 6, 7]"},
+		{"let fib = fn(a, b) { yield a; yield ...fib(b, a + b) }; collect(take(fib(0, 1), 10))", "[0, 1, 1, 2, 3, 5, 8, 13, 21, 34]"},
+		{"let g = fn() { let x = yield 1; yield x }; collect(g())", "[1, null]"},
+		{"let g = fn() { yield 1; return 5; yield 2 }; collect(g())", "[1]"},
+		{"let g = fn() { yield 1; yield 1 + true }; collect(g())", "type mismatch: Integer + Boolean"},
+		{"let g = fn() { yield ...5 }; collect(g())", "can not spread value of type Integer"},
+		{"let g = fn() { yield 1 }; let it = g(); [collect(it), collect(it)]", "[[1], []]"},
+		{"let g = fn(xs) { yield ...map(xs, fn(x) { x * 2 }) }; collect(filter(g(range(5)), fn(x) { x > 4 }))", "[6, 8]"},
+		{"let g = fn() { let c = chan(); yield 1; recv(c) }; collect(g())", ErrDeadlock.Error()},
+		{"let g = fn(c) { yield recv(c); yield recv(c) }; let c = chan(2); send(c, 1); send(c, 2); collect(g(c))", "[1, 2]"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestConcurrentEnvironment(t *testing.T) {
+	input := `
+let done = chan();
+let worker = fn(id) {
+  let loop = fn(n, acc) {
+    if (n == 0) { return acc; }
+    let k = id * 1000 + n;
+    loop(n - 1, acc + k);
+  };
+  send(done, loop(50, 0));
+};
+let start = fn(n) { if (n > 0) { spawn(worker, n); start(n - 1); } };
+start(8);
+let collect = fn(n, acc) { if (n == 0) { return acc; } collect(n - 1, acc + recv(done)) };
+collect(8, 0);
+`
+	result, err := testEval(input)
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	// sum over id 1..8 of (50 * id * 1000 + 1275)
+	testIntObj(t, result, 36*50*1000+8*1275)
+}
+
+func TestOSModules(t *testing.T) {
+	dir := t.TempDir()
+	hello := filepath.Join(dir, "hello.txt")
+	if err := ioutil.WriteFile(hello, []byte("hello\nmonkey\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("MONKEY_TEST_VAR", "banana")
+	defer os.Unsetenv("MONKEY_TEST_VAR")
+
+	out := filepath.Join(dir, "out.txt")
+	tests := []struct {
+		input string
+		allow Capabilities
+		want  interface{}
+	}{
+		{`io.read_file("` + hello + `")`, Capabilities{Read: true}, `"hello\nmonkey\n"`},
+		{`io.lines("` + hello + `")`, Capabilities{Read: true}, `["hello", "monkey"]`},
+		{`len(os.glob("` + dir + `/*.txt"))`, Capabilities{Read: true}, `1`},
+		{`os.getenv("MONKEY_TEST_VAR")`, Capabilities{Read: true}, `"banana"`},
+		{`os.getenv("MONKEY_TEST_UNSET")`, Capabilities{Read: true}, `null`},
+		{
+			`io.write_file("` + out + `", "written"); io.read_file("` + out + `")`,
+			Capabilities{Read: true, Write: true},
+			`"written"`,
+		},
+		{`os.exec("echo", "hi")`, Capabilities{Run: true}, `{"stdout": "hi\n", "stderr": "", "code": 0}`},
+		{`os.exec("sh", "-c", "exit 3")["code"]`, Capabilities{Run: true}, `3`},
+		{`io.read_file("` + hello + `")`, Capabilities{}, PermissionDenied{name: "io.read_file", flag: "--allow-read"}},
+		{`io.lines("` + hello + `")`, Capabilities{Write: true}, PermissionDenied{name: "io.lines", flag: "--allow-read"}},
+		{`os.getenv("HOME")`, Capabilities{}, PermissionDenied{name: "os.getenv", flag: "--allow-read"}},
+		{`os.glob("*")`, Capabilities{}, PermissionDenied{name: "os.glob", flag: "--allow-read"}},
+		{`io.write_file("` + out + `", "x")`, Capabilities{Read: true}, PermissionDenied{name: "io.write_file", flag: "--allow-write"}},
+		{`os.exec("echo")`, Capabilities{Read: true, Write: true}, PermissionDenied{name: "os.exec", flag: "--allow-run"}},
+		{`io.read_file(1)`, Capabilities{Read: true}, BadBuiltinArg{name: "io.read_file", argtype: object.Integer}},
+		{`os.nope`, Capabilities{}, "module os has no member nope"},
+		{`let x = 1; x.y`, Capabilities{}, "bad member access .y on type Integer"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEvalRuntime(tc.input, &Runtime{Allowed: tc.allow})
+			switch want := tc.want.(type) {
+			case string:
+				if err != nil {
+					if err.Error() != want {
+						t.Errorf("error is %q, want %q", err, want)
+					}
+					return
+				}
+				if result.Inspect() != want {
+					t.Errorf("result is %s, want %s", result.Inspect(), want)
+				}
+			case error:
+				if err != want {
+					t.Errorf("error is %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStdin(t *testing.T) {
+	rt := &Runtime{Stdin: strings.NewReader("one\r\ntwo\nthree")}
+	result, err := testEvalRuntime("[io.read_line(), io.read_line(), io.read_line(), io.read_line()]", rt)
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	want := `["one", "two", "three", null]`
+	if result.Inspect() != want {
+		t.Errorf("result is %s, want %s", result.Inspect(), want)
+	}
+
+	rt = &Runtime{Stdin: strings.NewReader("all\nof it")}
+	result, err = testEvalRuntime("io.read_all()", rt)
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	if want := `"all\nof it"`; result.Inspect() != want {
+		t.Errorf("result is %s, want %s", result.Inspect(), want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		src   string // bound to src
+		input string
+		want  string
+		err   string
+	}{
+		{`{"b": 1, "a": [true, null, 2.5, "x"]}`, `json.parse(src)`, `{"b": 1, "a": [true, null, 2.5, "x"]}`, ""},
+		{`[1, 1e3, -7, 12345678901234]`, `json.parse(src)`, `[1, 1000, -7, 12345678901234]`, ""},
+		{`{"n": {"m": {}}}`, `json.parse(src)["n"]["m"]`, `{}`, ""},
+		{`"\u00e9"`, `json.parse(src)`, `"é"`, ""},
+		{``, `json.stringify({"b": 1, "a": [true, "x<y"]})`, `"{\"b\":1,\"a\":[true,\"x<y\"]}"`, ""},
+		{``, `json.stringify([1, {"k": []}], "  ")`, `"[\n  1,\n  {\n    \"k\": []\n  }\n]"`, ""},
+		{`[null]`, `json.stringify(json.parse(src))`, `"[null]"`, ""},
+		{`2.5`, `json.stringify(json.parse(src))`, `"2.5"`, ""},
+		{``, `let v = {"a": [1, 2], "b": {"c": "d"}}; json.parse(json.stringify(v)) == v`, `true`, ""},
+		{`[0.0, -0.0]`, `let [z, nz] = json.parse(src); [z == nz, {z: 1}[nz]]`, `[true, 1]`, ""},
+		{`1.0`, `let one = json.parse(src); [one == 1, {1: "one"}[one], {one: "one"}[1]]`, `[true, "one", "one"]`, ""},
+		{`[1.0]`, `json.parse(src) == [1]`, `true`, ""},
+		{`[1, 2`, `json.parse(src)`, "", "json: unexpected end of JSON input"},
+		{``, `json.parse(src)`, "", "json: unexpected end of input"},
+		{`1 2`, `json.parse(src)`, "", "json: unexpected data after top-level value"},
+		{`{x}`, `json.parse(src)`, "", "json: invalid character 'x' looking for beginning of value"},
+		{``, `json.stringify(fn(x) { x })`, "", "json: value of type Function is not serializable"},
+		{``, `json.stringify([len])`, "", "json: value of type Builtin is not serializable"},
+		{``, `json.stringify({1: 2})`, "", "json: hash key of type Integer is not serializable, keys must be strings"},
+		{``, `json.stringify(1, 2)`, "", BadBuiltinArg{name: "json.stringify", argtype: object.Integer}.Error()},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			env := object.NewEnvironment()
+			env.Set("src", objs(tc.src))
+			parse := parser.New(lexer.New(tc.input))
+			result, err := Eval(parse.Program(), env)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("error is %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("eval failed: %s", err)
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestJSONRead(t *testing.T) {
+	rt := &Runtime{Stdin: strings.NewReader("{\"id\": 1}\n\n[2]\n\"three\"")}
+	result, err := testEvalRuntime("[json.read(), json.read(), json.read(), json.read()]", rt)
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	want := `[{"id": 1}, [2], "three", null]`
+	if result.Inspect() != want {
+		t.Errorf("result is %s, want %s", result.Inspect(), want)
+	}
+}
+
+func TestBigInts(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4611686018427387904 * 2", "9223372036854775808"},
+		{"-1 * (-9223372036854775807 - 1)", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) * -1", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"3037000500 * 3037000500", "9223372037000250000"},
+		{"123456789012345678901234567890", "123456789012345678901234567890"},
+		{"-123456789012345678901234567890 / 10", "-12345678901234567890123456789"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(25)", "15511210043330985984000000"},
+		{"99999999999999999999 - 99999999999999999998", "1"},
+		{"[18446744073709551616 > 5, 5 < 18446744073709551616, -18446744073709551616 < 5]", "[true, true, true]"},
+		{"[18446744073709551616 == 18446744073709551616, 18446744073709551616 != 2 * 9223372036854775808]", "[true, false]"},
+		{"9223372036854775808 + 1 - 1 == 9223372036854775808", "true"},
+		{`let h = {18446744073709551616: "big"}; [h[9223372036854775808 * 2], [1][18446744073709551616]]`, `["big", null]`},
+		{"[9223372036854775808] == [9223372036854775807 + 1]", "true"},
+		{"str(18446744073709551616) + format(\" %d %x\", 18446744073709551616, 18446744073709551616)", `"18446744073709551616 18446744073709551616 10000000000000000"`},
+		{`18446744073709551616 * json.parse("2.5")`, "4.611686018427388e+19"},
+		{`18446744073709551616 + "a"`, "type mismatch: Integer + String"},
+		{"1 / 0", "division by zero"},
+		{"let f = fn(x) { 10 / x }; f(1 - 1)", "division by zero"},
+		{"18446744073709551616 / 0", "division by zero"},
+		{"1 / (18446744073709551616 - 18446744073709551616)", "division by zero"},
+		{`json.stringify(json.parse("[18446744073709551616]"))`, `"[18446744073709551616]"`},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
+func TestFloats(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`half + 1`, "1.5"},
+		{`5 * half`, "2.5"},
+		{`-half`, "-0.5"},
+		{`half < 1`, "true"},
+		{`half * 4 == 2`, "true"},
+		{`1 / (half * 8)`, "0.25"},
+		{`half == half`, "true"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			env := object.NewEnvironment()
+			env.Set("half", objf(0.5))
+			parse := parser.New(lexer.New(tc.input))
+			result, err := Eval(parse.Program(), env)
+			if err != nil {
+				t.Fatalf("eval failed: %s", err)
+			}
+			if result.Inspect() != tc.want {
+				t.Errorf("result is %s, want %s", result.Inspect(), tc.want)
+			}
+		})
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	tests := []struct {
 		input string
@@ -285,6 +1129,38 @@ func TestStringLiteral(t *testing.T) {
 
 }
 
+func TestStringInterpolation(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`let name = "Monkey"; let age = 5; "hello ${name}, you are ${age + 1}"`, "hello Monkey, you are 6"},
+		{`"${[1, "two"]} ${{"k": true}} ${len}"`, `[1, "two"] {"k": true} builtin function`},
+		{`let f = fn(x) { "<${x}>" }; "${f("${1 + 1}")}"`, "<2>"},
+		{`"\${not} ${"\"quoted\""}"`, `${not} "quoted"`},
+		{"`line one\n  ${raw}`", "line one\n  ${raw}"},
+		{`str(42) + str("s") + str(true) + str([1])`, "42strue[1]"},
+		{`format("%d-%05d %s %q %t %v", 1, 42, "s", "q", false, [1])`, `1-00042 s "q" false [1]`},
+		{`format("%x %v %%", 255, {"a": 1})`, `ff {"a": 1} %`},
+		{`format("none")`, "none"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				t.Fatalf("eval failed: %s", err)
+			}
+			str, ok := result.(*object.Str)
+			if !ok {
+				t.Fatalf("result is of type %T, want *object.Str", result)
+			}
+			if string(*str) != tc.want {
+				t.Errorf("str has %q, want %q", *str, tc.want)
+			}
+		})
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input string
@@ -293,6 +1169,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("héllo, 世界")`, 9},
 		{"len(1)", BadBuiltinArg{name: "len", argtype: object.Integer}},
 		{
 			`len("one", "two")`,
@@ -430,29 +1307,219 @@ func TestHashExpr(t *testing.T) {
   false: 6
 };
 `
-	want := map[object.Hashable]int64{
-		object.Str("one"):   1,
-		object.Str("two"):   2,
-		object.Str("three"): 3,
-		object.Int(4):       4,
-		object.Bool(true):   5,
-		object.Bool(false):  6,
+	str := func(s string) object.Object {
+		r := object.Str(s)
+		return &r
+	}
+	want := []struct {
+		key   object.Object
+		value int64
+	}{
+		{str("one"), 1},
+		{str("two"), 2},
+		{str("three"), 3},
+		{obji(4), 4},
+		{objb(true), 5},
+		{objb(false), 6},
 	}
 	result, _ := testEval(input)
 	hash, ok := result.(*object.HashMap)
 	if !ok {
 		t.Fatalf("hash is of type %T, want *object.HashMap", result)
 	}
-	if len(hash.Pairs) != len(want) {
+	if hash.Len() != len(want) {
 		t.Fatalf("len(hash) is %d elements, want %d",
-			len(hash.Pairs), len(want))
+			hash.Len(), len(want))
 	}
-	for k, v := range want {
-		pair, ok := hash.Pairs[k.HashKey()]
-		if !ok {
-			t.Errorf("pair not found for key %#v", k)
+	for _, w := range want {
+		pair, ok, err := hash.Get(nil, w.key)
+		if err != nil || !ok {
+			t.Errorf("pair not found for key %s", w.key.Inspect())
+			continue
 		}
-		testIntObj(t, pair.Value, v)
+		testIntObj(t, pair.Value, w.value)
+	}
+}
+
+func TestCompositeHashKeys(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{}
+	}{
+		{`{[1, 2]: 3}[[1, 2]]`, 3},
+		{`{[1, 2]: 3}[[2, 1]]`, nil},
+		{`{[]: 1}[[]]`, 1},
+		{`{[1, [2, "x"]]: 4}[[1, [2, "x"]]]`, 4},
+		{`{{"a": 1, "b": 2}: 5}[{"b": 2, "a": 1}]`, 5},
+		{`{{"a": 1}: 5}[{"a": 2}]`, nil},
+		{`{{"a": [1]}: 6}[{"a": [1]}]`, 6},
+		{`{1: 1, [1]: 2}[[1]]`, 2},
+		{`{"1": 1, 1: 2}[1]`, 2},
+		{`len(keys({[1]: 1, [1]: 2}))`, 1},
+		{`{fn() {}: 1}`, object.Unhashable{Type: object.Function}},
+		{`{[1, fn() {}]: 1}`, object.Unhashable{Type: object.Function}},
+		{`{{"f": len}: 1}`, object.Unhashable{Type: object.Builtin}},
+		{`{}[[fn() {}]]`, object.Unhashable{Type: object.Function}},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Logf("input %s", tc.input)
+			result, err := testEval(tc.input)
+			switch want := tc.want.(type) {
+			case int:
+				testIntObj(t, result, int64(want))
+			case error:
+				if err == nil || err.Error() != want.Error() {
+					t.Errorf("error is %v, want %q", err, want)
+				}
+			default:
+				testIsNull(t, result)
+			}
+		})
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{"c": 1, "a": 2, "b": 3}`, `{"c": 1, "a": 2, "b": 3}`},
+		{`{3: "x", 1: "y", true: "z"}`, `{3: "x", 1: "y", true: "z"}`},
+		{`{"a": 1, "b": 2, "a": 3}`, `{"a": 3, "b": 2}`},
+		{`keys({"z": 1, "y": 2, "x": 3})`, `["z", "y", "x"]`},
+		{`values({"z": 1, "y": 2, "x": 3})`, `[1, 2, 3]`},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			// Repeat evaluation to catch nondeterministic
+			// iteration order.
+			for n := 0; n < 10; n++ {
+				result, err := testEval(tc.input)
+				if err != nil {
+					t.Fatalf("eval failed: %s", err)
+				}
+				if result.Inspect() != tc.want {
+					t.Fatalf("result is %s, want %s",
+						result.Inspect(), tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEquality(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{`{"a": 1, "b": 2} == {"b": 2, "a": 1}`, true},
+		{`{"a": 1, "b": 2} != {"b": 2, "a": 1}`, false},
+		{`{"a": 1, "b": 2} == {"a": 1, "b": 3}`, false},
+		{`{"a": 1} == {"a": 1, "b": 2}`, false},
+		{`{"a": [1, {"x": true}]} == {"a": [1, {"x": true}]}`, true},
+		{`[1, 2, 3] == [1, 2, 3]`, true},
+		{`[1, 2, 3] == [3, 2, 1]`, false},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`let f = fn() {}; f == f`, true},
+		{`fn() {} == fn() {}`, false},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				t.Fatalf("eval failed: %s", err)
+			}
+			testBoolObj(t, result, tc.want)
+		})
+	}
+}
+
+func TestProtocols(t *testing.T) {
+	vec := `let vec = fn(x, y) { {
+		"x": x, "y": y,
+		"__add__": fn(a, b) { vec(a["x"] + b["x"], a["y"] + b["y"]) },
+		"__eq__": fn(a, b) { [a["x"], a["y"]] == [b["x"], b["y"]] },
+		"__str__": fn(v) { "vec(" + str(v["x"]) + ", " + str(v["y"]) + ")" },
+		"__hash__": fn(v) { [v["x"], v["y"]] },
+	} };`
+	num := `let num = fn(n) { {"n": n, "__lt__": fn(a, b) { a["n"] < b }} };`
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{vec + "vec(1, 2) + vec(3, 4)", "vec(4, 6)"},
+		{vec + "[str(vec(1, 2)), vec(0, 0)]", `["vec(1, 2)", vec(0, 0)]`},
+		{vec + "[vec(1, 2) == vec(1, 2), vec(1, 2) != vec(1, 2), vec(1, 2) == vec(2, 1)]", "[true, false, false]"},
+		{vec + `let h = {vec(1, 2): "a"}; [h[vec(1, 2)], h[vec(2, 1)]]`, `["a", null]`},
+		{vec + "[[vec(1, 2)] == [vec(1, 2)], {\"v\": vec(1, 2)} == {\"v\": vec(2, 1)}]", "[true, false]"},
+		{num + "[num(1) < 2, num(3) < 2, 2 > num(1), 0 > num(1)]", "[true, false, true, false]"},
+		{`len({"items": [1, 2, 3], "__len__": fn(h) { len(h["items"]) }})`, "3"},
+		{`len({"a": 1, "b": 2})`, "2"},
+		{`let d = {"a": 1, "__index__": fn(h, k) { k + "?" }}; [d["a"], d["b"]]`, `[1, "b?"]`},
+		{`{"__add__": 1} + 1`, "type mismatch: Hash + Integer"},
+		{`{"__eq__": fn(a, b) { 1 }} == 1`, "__eq__ returned Integer, want Boolean"},
+		{`len({"__len__": fn(h) { "3" }})`, "len: __len__ returned String, want Integer"},
+		{`{"__str__": fn(h) { 1 }, "a": 2}["a"]`, "2"},
+		{`let h = {"a": 1, "__str__": fn(h) { "<" + str(h["a"]) + ">" }}; {"h": h}`, `{"h": <1>}`},
+		{`let h = {"__str__": fn(h) { "<" + str(len(str(h)) > 0) + ">" }}; [str(h), str(h)]`, `["<true>", "<true>"]`},
+		{vec + `[{"x": 1, "y": 2} == vec(1, 2), [{"x": 1, "y": 2}] == [vec(1, 2)], {"v": {"x": 2, "y": 1}} == {"v": vec(1, 2)}]`, "[true, true, false]"},
+		{`{{"f": fn() {}}: 1}`, "unhashable type Function can not be used as a hash key"},
+		{`let h = {"__hash__": fn(h) { h }}; {h: 1}`, "__hash__ returned a value holding a hash with a __hash__ protocol"},
+		{`let h = {"__hash__": fn(h) { [1, {"h": h}] }}; {h: 1}`, "__hash__ returned a value holding a hash with a __hash__ protocol"},
+		{`let h = {"__hash__": fn(h) { [1, {"a": 2}] }}; {h: 1}[h]`, "1"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := testEval(tc.input)
+			if err != nil {
+				if err.Error() != tc.want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if got := Inspect(nil, result); got != tc.want {
+				t.Errorf("result is %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConcurrentStr(t *testing.T) {
+	// Tasks inspecting the same hash at once all call its __str__.
+	input := `
+let spin = fn(n) { if (n > 0) { spin(n - 1) } };
+let p = {"__str__": fn(h) { spin(5000); "p" }};
+let tasks = collect(map(range(8), fn(i) { spawn(fn() { str(p) }) }));
+collect(map(tasks, wait))`
+	result, err := testEval(input)
+	if err != nil {
+		t.Fatalf("eval failed: %s", err)
+	}
+	want := `["p", "p", "p", "p", "p", "p", "p", "p"]`
+	if result.Inspect() != want {
+		t.Errorf("result is %s, want %s", result.Inspect(), want)
+	}
+}
+
+func TestProtocolRuntime(t *testing.T) {
+	// Protocols apply in the runtime of the program using the hash,
+	// even builtins which do not close over an environment.
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`str({"__str__": puts, "a": 1})`, "{\"__str__\": builtin function, \"a\": 1}\n"},
+		{`len({"__len__": puts})`, "{\"__len__\": builtin function}\n"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var out bytes.Buffer
+			testEvalRuntime(tc.input, &Runtime{Stdout: &out})
+			if out.String() != tc.want {
+				t.Errorf("printed %q, want %q", out.String(), tc.want)
+			}
+		})
 	}
 }
 
@@ -461,6 +1528,14 @@ func testEval(input string) (object.Object, error) {
 	return Eval(parse.Program(), object.NewEnvironment())
 }
 
+// testEvalRuntime evaluates input in an environment running with rt.
+func testEvalRuntime(input string, rt *Runtime) (object.Object, error) {
+	env := object.NewEnvironment()
+	env.SetRuntime(rt)
+	parse := parser.New(lexer.New(input))
+	return Eval(parse.Program(), env)
+}
+
 func testIntObj(t *testing.T, obj object.Object, want int64) {
 	val, ok := obj.(*object.Int)
 	if !ok {
@@ -482,7 +1557,32 @@ func testBoolObj(t *testing.T, obj object.Object, want bool) {
 }
 
 func testIsNull(t *testing.T, obj object.Object) {
-	if obj != &null {
+	if obj != null {
 		t.Errorf("obj is %+v, want object.Null", obj)
 	}
 }
+
+// TestTables evaluates the programs of the tables in testdata, which
+// the transpile and optimize packages check their output against.
+func TestTables(t *testing.T) {
+	tests, err := evaltest.LoadAll(evaltest.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range tests {
+		t.Run(tc.Pos, func(t *testing.T) {
+			env := object.NewEnvironment()
+			env.SetRuntime(&Runtime{Stdout: ioutil.Discard})
+			result, err := Eval(parser.New(lexer.New(tc.Input)).Program(), env)
+			if err != nil {
+				if err.Error() != tc.Want {
+					t.Fatalf("eval failed: %s", err)
+				}
+				return
+			}
+			if got := Inspect(env, result); got != tc.Want {
+				t.Errorf("result is %s, want %s", got, tc.Want)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/object"
+)
+
+// errStopped unwinds a generator whose iterator was dropped before
+// its end.
+var errStopped = errors.New("generator stopped")
+
+// newIter returns an iterator calling next for each value, calls are
+// serialized so tasks may share an iterator. Each value checks for an
+// interruption of the runtime of env, so builtins such as collect
+// looping over an iterator stop with the program.
+func newIter(env *object.Environment, next func() (object.Object, bool, error)) *object.Iter {
+	rt := runtimeOf(env)
+	var mu sync.Mutex
+	return &object.Iter{Next: func() (object.Object, bool, error) {
+		if err := rt.interruptErr(); err != nil {
+			return nil, false, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return next()
+	}}
+}
+
+// iterate returns an iterator over the elements of an array, the keys
+// of a hash in insertion order, the characters of a string or the
+// values of an iterator.
+func iterate(env *object.Environment, name string, o object.Object) (*object.Iter, error) {
+	switch o := o.(type) {
+	case *object.Iter:
+		return o, nil
+	case object.Arr:
+		return sliceIter(env, o), nil
+	case *object.HashMap:
+		pairs := o.Pairs()
+		keys := make([]object.Object, len(pairs))
+		for i, p := range pairs {
+			keys[i] = p.Key
+		}
+		return sliceIter(env, keys), nil
+	case *object.Str:
+		runes := []rune(string(*o))
+		chars := make([]object.Object, len(runes))
+		for i, r := range runes {
+			chars[i] = objs(string(r))
+		}
+		return sliceIter(env, chars), nil
+	default:
+		return nil, BadBuiltinArg{name: name, argtype: o.Type()}
+	}
+}
+
+func sliceIter(env *object.Environment, values []object.Object) *object.Iter {
+	i := 0
+	return newIter(env, func() (object.Object, bool, error) {
+		if i >= len(values) {
+			return nil, false, nil
+		}
+		i++
+		return values[i-1], true, nil
+	})
+}
+
+// rangeIter counts from start up to, or down to for a negative step,
+// stop excluded.
+func rangeIter(env *object.Environment, start, stop, step int64) *object.Iter {
+	i, done := start, false
+	return newIter(env, func() (object.Object, bool, error) {
+		if done || (step > 0 && i >= stop) || (step < 0 && i <= stop) {
+			return nil, false, nil
+		}
+		v := i
+		// The next value would overflow past stop.
+		if (step > 0 && i > math.MaxInt64-step) || (step < 0 && i < math.MinInt64-step) {
+			done = true
+		}
+		i += step
+		return obji(v), true, nil
+	})
+}
+
+// generator is the state of a call to a generator function, kept in
+// the last slot of the call environment where its yields find it.
+// The function runs in a goroutine of its own, resumed by the
+// iterator for each value and waiting at a yield meanwhile.
+type generator struct {
+	values chan yielded
+	resume chan struct{}
+	// stop is closed once the iterator is garbage collected.
+	stop chan struct{}
+}
+
+// yielded is a value sent to the iterator of a generator, or the end
+// of the function along with its error.
+type yielded struct {
+	value object.Object
+	err   error
+	done  bool
+}
+
+// Type returns the object type
+func (*generator) Type() object.Type { return object.Iterator }
+
+// Inspect provides a string representation of a generator
+func (*generator) Inspect() string { return "generator" }
+
+// generate returns the iterator of a call to the generator function fn
+// with the environment env holding its arguments. The function starts
+// running on the first call to Next.
+func generate(fn *object.Funct, env *object.Environment) *object.Iter {
+	g := &generator{
+		// The end of a stopped generator is not received.
+		values: make(chan yielded, 1),
+		resume: make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	env.SetSlot(0, fn.Locals-1, g)
+	started, done := false, false
+	it := newIter(env, func() (object.Object, bool, error) {
+		if done {
+			return nil, false, nil
+		}
+		if started {
+			g.resume <- struct{}{}
+		} else {
+			started = true
+			go func() {
+				var err error
+				defer func() { g.values <- yielded{err: err, done: true} }()
+				defer recovered(&err)
+				_, err = Eval(fn.Body, env)
+			}()
+		}
+		y := <-g.values
+		if y.done {
+			done = true
+			return nil, false, y.err
+		}
+		return y.value, true, nil
+	})
+	// The goroutine of a generator dropped before its end would
+	// wait at a yield forever. It does not refer to the iterator,
+	// so it can be stopped once the iterator is collected.
+	runtime.SetFinalizer(it, func(*object.Iter) { close(g.stop) })
+	return it
+}
+
+// yield hands v to the iterator and waits until the next value is
+// asked for.
+func (g *generator) yield(v object.Object) (object.Object, error) {
+	g.values <- yielded{value: v}
+	select {
+	case <-g.resume:
+		return null, nil
+	case <-g.stop:
+		return nil, errStopped
+	}
+}
+
+// evalYield hands the value of a yield to the iterator of the
+// generator, or each value of a spread iterable.
+func evalYield(n *ast.YieldExpr, env *object.Environment) (object.Object, error) {
+	// Blocks within a function share its environment.
+	g, ok := env.Slot(0, n.Slot).(*generator)
+	if !ok {
+		return nil, ErrUnexpected
+	}
+	spread, ok := n.Value.(*ast.SpreadExpr)
+	if !ok {
+		v, err := Eval(n.Value, env)
+		if err != nil {
+			return nil, err
+		}
+		return g.yield(v)
+	}
+	v, err := Eval(spread.Value, env)
+	if err != nil {
+		return nil, err
+	}
+	it, err := iterate(env, "yield", v)
+	if err != nil {
+		return nil, fmt.Errorf("can not spread value of type %s", v.Type())
+	}
+	for {
+		v, ok, err := it.Next()
+		if !ok || err != nil {
+			return null, err
+		}
+		if _, err := g.yield(v); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fnArg checks that the argument of a builtin is a function.
+func fnArg(name string, o object.Object) error {
+	switch o.(type) {
+	case *object.Funct, *object.BuiltinFunct:
+		return nil
+	default:
+		return BadBuiltinArg{name: name, argtype: o.Type()}
+	}
+}
+
+// intArgs converts args of a builtin to integers.
+func intArgs(name string, args []object.Object) ([]int64, error) {
+	ns := make([]int64, len(args))
+	for i, arg := range args {
+		n, ok := arg.(*object.Int)
+		if !ok {
+			return nil, BadBuiltinArg{name: name, argtype: arg.Type()}
+		}
+		ns[i] = int64(*n)
+	}
+	return ns, nil
+}
+
+// iterFnArgs checks the arguments of builtins taking an iterable and a
+// function.
+func iterFnArgs(env *object.Environment, name string, args []object.Object) (*object.Iter, object.Object, error) {
+	if len(args) != 2 {
+		return nil, nil, BadBuiltinNArgs{name: name, nargs: 2, got: len(args)}
+	}
+	it, err := iterate(env, name, args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := fnArg(name, args[1]); err != nil {
+		return nil, nil, err
+	}
+	return it, args[1], nil
+}
+
+var iterBuiltins = map[string]*object.BuiltinFunct{
+	"range": &object.BuiltinFunct{
+		Params: "start = 0, stop, step = 1",
+		Doc:    "Returns an iterator counting from start to stop excluded, range(stop) counts from 0.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) < 1 || len(args) > 3 {
+				return nil, BadBuiltinNArgs{
+					name:  "range",
+					nargs: 3,
+					got:   len(args),
+				}
+			}
+			ns, err := intArgs("range", args)
+			if err != nil {
+				return nil, err
+			}
+			if len(ns) == 1 {
+				ns = []int64{0, ns[0]}
+			}
+			if len(ns) == 2 {
+				ns = append(ns, 1)
+			}
+			if ns[2] == 0 {
+				return nil, errors.New("range step can not be zero")
+			}
+			return rangeIter(env, ns[0], ns[1], ns[2]), nil
+		},
+	},
+	"take": &object.BuiltinFunct{
+		Params: "iterable, n",
+		Doc:    "Returns an iterator over the first n values of iterable.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) != 2 {
+				return nil, BadBuiltinNArgs{
+					name:  "take",
+					nargs: 2,
+					got:   len(args),
+				}
+			}
+			it, err := iterate(env, "take", args[0])
+			if err != nil {
+				return nil, err
+			}
+			ns, err := intArgs("take", args[1:])
+			if err != nil {
+				return nil, err
+			}
+			left := ns[0]
+			return newIter(env, func() (object.Object, bool, error) {
+				if left <= 0 {
+					return nil, false, nil
+				}
+				left--
+				return it.Next()
+			}), nil
+		},
+	},
+	"zip": &object.BuiltinFunct{
+		Params: "...iterables",
+		Doc:    "Returns an iterator over arrays holding a value of each iterable, until one of them is exhausted.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) < 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "zip",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			its := make([]*object.Iter, len(args))
+			for i, arg := range args {
+				it, err := iterate(env, "zip", arg)
+				if err != nil {
+					return nil, err
+				}
+				its[i] = it
+			}
+			return newIter(env, func() (object.Object, bool, error) {
+				values := make(object.Arr, len(its))
+				for i, it := range its {
+					v, ok, err := it.Next()
+					if !ok || err != nil {
+						return nil, false, err
+					}
+					values[i] = v
+				}
+				return values, true, nil
+			}), nil
+		},
+	},
+	"collect": &object.BuiltinFunct{
+		Params: "iterable",
+		Doc:    "Returns an array holding the values of iterable.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) != 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "collect",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			it, err := iterate(env, "collect", args[0])
+			if err != nil {
+				return nil, err
+			}
+			values := object.Arr{}
+			for {
+				v, ok, err := it.Next()
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return values, nil
+				}
+				values = append(values, v)
+			}
+		},
+	},
+}
+
+func init() {
+	for name, fn := range iterBuiltins {
+		builtins[name] = fn
+	}
+	// The builtins applying functions are registered here as they
+	// refer back to apply, which refers to the builtins table.
+	builtins["map"] = &object.BuiltinFunct{
+		Params: "iterable, fn",
+		Doc:    "Returns an iterator over the results of fn applied to the values of iterable.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			it, fn, err := iterFnArgs(env, "map", args)
+			if err != nil {
+				return nil, err
+			}
+			return newIter(env, func() (object.Object, bool, error) {
+				v, ok, err := it.Next()
+				if !ok || err != nil {
+					return nil, false, err
+				}
+				v, err = apply(env, fn, []object.Object{v})
+				return v, err == nil, err
+			}), nil
+		},
+	}
+	builtins["filter"] = &object.BuiltinFunct{
+		Params: "iterable, fn",
+		Doc:    "Returns an iterator over the values of iterable for which fn is truthy.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			it, fn, err := iterFnArgs(env, "filter", args)
+			if err != nil {
+				return nil, err
+			}
+			return newIter(env, func() (object.Object, bool, error) {
+				for {
+					v, ok, err := it.Next()
+					if !ok || err != nil {
+						return nil, false, err
+					}
+					keep, err := apply(env, fn, []object.Object{v})
+					if err != nil {
+						return nil, false, err
+					}
+					if truthy(keep) {
+						return v, true, nil
+					}
+				}
+			}), nil
+		},
+	}
+	builtins["each"] = &object.BuiltinFunct{
+		Params: "iterable, fn",
+		Doc:    "Applies fn to each value of iterable and returns null.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			it, fn, err := iterFnArgs(env, "each", args)
+			if err != nil {
+				return nil, err
+			}
+			for {
+				v, ok, err := it.Next()
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return null, nil
+				}
+				if _, err := apply(env, fn, []object.Object{v}); err != nil {
+					return nil, err
+				}
+			}
+		},
+	}
+}
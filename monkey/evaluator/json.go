@@ -0,0 +1,225 @@
+package evaluator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/emb/play/monkey/object"
+)
+
+// NotSerializable is an error returned when converting a value that
+// has no JSON representation.
+type NotSerializable struct {
+	argtype object.Type
+}
+
+// Error returns a string describing the error
+func (n NotSerializable) Error() string {
+	return fmt.Sprintf("json: value of type %s is not serializable", n.argtype)
+}
+
+// fromJSON decodes a single JSON value from dec. Objects become hashes
+// keeping the order of their keys, numbers become integers unless they
+// have a fraction or exponent.
+func fromJSON(dec *json.Decoder) (object.Object, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		switch tok {
+		case '[':
+			arr := object.Arr{}
+			for dec.More() {
+				e, err := fromJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, e)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return arr, nil
+		case '{':
+			hash := object.NewHashMap()
+			for dec.More() {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := fromJSON(dec)
+				if err != nil {
+					return nil, err
+				}
+				hash.Set(nil, objs(k.(string)), v)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return hash, nil
+		}
+	case string:
+		return objs(tok), nil
+	case json.Number:
+		if i, err := tok.Int64(); err == nil {
+			return obji(i), nil
+		}
+		if i, ok := new(big.Int).SetString(tok.String(), 10); ok {
+			return object.NewBigInt(i), nil
+		}
+		f, err := tok.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return objf(f), nil
+	case bool:
+		return objb(tok), nil
+	case nil:
+		return null, nil
+	}
+	return nil, fmt.Errorf("json: unexpected token %v", tok)
+}
+
+// parseJSON decodes src which must hold exactly one JSON value.
+func parseJSON(src string) (object.Object, error) {
+	dec := json.NewDecoder(strings.NewReader(src))
+	dec.UseNumber()
+	v, err := fromJSON(dec)
+	if err == io.EOF {
+		return nil, fmt.Errorf("json: unexpected end of input")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("json: %s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("json: unexpected data after top-level value")
+	}
+	return v, nil
+}
+
+// toJSON writes the JSON representation of o to buf.
+func toJSON(buf *bytes.Buffer, o object.Object) error {
+	switch o := o.(type) {
+	case *object.Int:
+		fmt.Fprintf(buf, "%d", int64(*o))
+	case *object.BigInt:
+		buf.WriteString(o.Value.String())
+	case *object.Flt:
+		f := float64(*o)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("json: unsupported float value %s", o.Inspect())
+		}
+		buf.WriteString(o.Inspect())
+	case *object.Str:
+		return jsonString(buf, string(*o))
+	case *object.Bool:
+		fmt.Fprintf(buf, "%t", bool(*o))
+	case *object.Nul:
+		buf.WriteString("null")
+	case object.Arr:
+		buf.WriteByte('[')
+		for i, e := range o {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := toJSON(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case *object.HashMap:
+		buf.WriteByte('{')
+		for i, p := range o.Pairs() {
+			k, ok := p.Key.(*object.Str)
+			if !ok {
+				return fmt.Errorf("json: hash key of type %s is not serializable, keys must be strings",
+					p.Key.Type())
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := jsonString(buf, string(*k)); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := toJSON(buf, p.Value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return NotSerializable{argtype: o.Type()}
+	}
+	return nil
+}
+
+func jsonString(buf *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	// Encode terminates each value with a newline.
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+func jsonParse(env *object.Environment, args ...object.Object) (object.Object, error) {
+	ss, err := strArgs("json.parse", 1, args)
+	if err != nil {
+		return nil, err
+	}
+	return parseJSON(ss[0])
+}
+
+// jsonStringify converts a value to JSON, given an indent string as a
+// second argument the output is pretty printed.
+func jsonStringify(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, BadBuiltinNArgs{name: "json.stringify", nargs: 1, got: len(args)}
+	}
+	var buf bytes.Buffer
+	if err := toJSON(&buf, args[0]); err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		return objs(buf.String()), nil
+	}
+	indent, ok := args[1].(*object.Str)
+	if !ok {
+		return nil, BadBuiltinArg{name: "json.stringify", argtype: args[1].Type()}
+	}
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, buf.Bytes(), "", string(*indent)); err != nil {
+		return nil, err
+	}
+	return objs(pretty.String()), nil
+}
+
+// jsonRead decodes the next line of newline delimited JSON from stdin,
+// blank lines are skipped and null is returned once stdin is
+// exhausted.
+func jsonRead(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 0 {
+		return nil, BadBuiltinNArgs{name: "json.read", nargs: 0, got: len(args)}
+	}
+	for {
+		line, err := runtimeOf(env).stdin.ReadString('\n')
+		if strings.TrimSpace(line) != "" {
+			return parseJSON(line)
+		}
+		if err == io.EOF {
+			return null, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
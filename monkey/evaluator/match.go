@@ -0,0 +1,117 @@
+package evaluator
+
+import (
+	"fmt"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/token"
+)
+
+// NoMatch is an error returned when no arm of a match expression
+// matches its value.
+type NoMatch struct {
+	pos   token.Position
+	value string
+}
+
+// Error returns a string describing the error
+func (n NoMatch) Error() string {
+	return fmt.Sprintf("non-exhaustive match at %s: no pattern matches %s",
+		n.pos, n.value)
+}
+
+// binding is a value to bind to an identifier once a pattern matched.
+type binding struct {
+	ident *ast.Identifier
+	value object.Object
+}
+
+func evalMatch(n *ast.MatchExpr, env *object.Environment) (object.Object, error) {
+	v, err := Eval(n.Value, env)
+	if err != nil {
+		return nil, err
+	}
+	for _, arm := range n.Arms {
+		bindings, ok, err := match(arm.Pattern, v, nil)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		env := env
+		if arm.Locals > 0 {
+			env = object.NewEnclosedEnvironment(env, arm.Locals)
+		}
+		for _, b := range bindings {
+			bind(env, b.ident, b.value)
+		}
+		if arm.Guard != nil {
+			g, err := Eval(arm.Guard, env)
+			if err != nil {
+				return nil, err
+			}
+			if !truthy(g) {
+				continue
+			}
+		}
+		return Eval(arm.Body, env)
+	}
+	return nil, NoMatch{pos: n.Token.Pos, value: v.Inspect()}
+}
+
+// match checks v against pattern, appending the bindings it makes to
+// bindings.
+func match(pattern ast.Expression, v object.Object, bindings []binding) ([]binding, bool, error) {
+	switch p := pattern.(type) {
+	case *ast.Identifier:
+		if p.Value != "_" {
+			bindings = append(bindings, binding{ident: p, value: v})
+		}
+		return bindings, true, nil
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.PrefixExpr:
+		lit, err := Eval(p, nil)
+		if err != nil {
+			return nil, false, err
+		}
+		return bindings, object.Equal(nil, lit, v), nil
+	case *ast.ArrayMatch:
+		arr, ok := v.(object.Arr)
+		if !ok || len(arr) < len(p.Elements) ||
+			(p.Rest == nil && len(arr) != len(p.Elements)) {
+			return bindings, false, nil
+		}
+		var err error
+		for i, e := range p.Elements {
+			if bindings, ok, err = match(e, arr[i], bindings); err != nil || !ok {
+				return bindings, ok, err
+			}
+		}
+		if p.Rest != nil {
+			rest := append(object.Arr{}, arr[len(p.Elements):]...)
+			return match(p.Rest, rest, bindings)
+		}
+		return bindings, true, nil
+	case *ast.HashMatch:
+		hash, ok := v.(*object.HashMap)
+		if !ok || (!p.Open && hash.Len() != len(p.Pairs)) {
+			return bindings, false, nil
+		}
+		for _, pair := range p.Pairs {
+			k, err := Eval(pair.Key, nil)
+			if err != nil {
+				return nil, false, err
+			}
+			found, ok, err := hash.Get(nil, k)
+			if err != nil || !ok {
+				return bindings, false, err
+			}
+			if bindings, ok, err = match(pair.Value, found.Value, bindings); err != nil || !ok {
+				return bindings, ok, err
+			}
+		}
+		return bindings, true, nil
+	}
+	return nil, false, ErrUnexpected
+}
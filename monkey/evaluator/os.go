@@ -0,0 +1,219 @@
+package evaluator
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/emb/play/monkey/object"
+)
+
+// Capabilities describes which kind of host access the io and os
+// modules are allowed.
+type Capabilities struct {
+	Read  bool // read files, list directories and environment variables
+	Write bool // write files
+	Run   bool // run commands
+}
+
+func allowRead(env *object.Environment, name string) error {
+	if !runtimeOf(env).Allowed.Read {
+		return PermissionDenied{name: name, flag: "--allow-read"}
+	}
+	return nil
+}
+
+func allowWrite(env *object.Environment, name string) error {
+	if !runtimeOf(env).Allowed.Write {
+		return PermissionDenied{name: name, flag: "--allow-write"}
+	}
+	return nil
+}
+
+func allowRun(env *object.Environment, name string) error {
+	if !runtimeOf(env).Allowed.Run {
+		return PermissionDenied{name: name, flag: "--allow-run"}
+	}
+	return nil
+}
+
+// strArgs checks that args are n strings and returns them.
+func strArgs(name string, n int, args []object.Object) ([]string, error) {
+	if len(args) != n {
+		return nil, BadBuiltinNArgs{name: name, nargs: n, got: len(args)}
+	}
+	return strs(name, args)
+}
+
+// strs converts args into strings.
+func strs(name string, args []object.Object) ([]string, error) {
+	ss := make([]string, len(args))
+	for i, arg := range args {
+		s, ok := arg.(*object.Str)
+		if !ok {
+			return nil, BadBuiltinArg{name: name, argtype: arg.Type()}
+		}
+		ss[i] = string(*s)
+	}
+	return ss, nil
+}
+
+func strArr(ss []string) object.Arr {
+	arr := make(object.Arr, len(ss))
+	for i, s := range ss {
+		arr[i] = objs(s)
+	}
+	return arr
+}
+
+func ioReadFile(env *object.Environment, args ...object.Object) (object.Object, error) {
+	ss, err := strArgs("io.read_file", 1, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := allowRead(env, "io.read_file"); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(ss[0])
+	if err != nil {
+		return nil, err
+	}
+	return objs(string(b)), nil
+}
+
+func ioWriteFile(env *object.Environment, args ...object.Object) (object.Object, error) {
+	ss, err := strArgs("io.write_file", 2, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := allowWrite(env, "io.write_file"); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(ss[0], []byte(ss[1]), 0666); err != nil {
+		return nil, err
+	}
+	return null, nil
+}
+
+func ioLines(env *object.Environment, args ...object.Object) (object.Object, error) {
+	ss, err := strArgs("io.lines", 1, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := allowRead(env, "io.lines"); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(ss[0])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := object.Arr{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, objs(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// ioReadLine reads a line from stdin without its line ending, null is
+// returned once stdin is exhausted.
+func ioReadLine(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 0 {
+		return nil, BadBuiltinNArgs{name: "io.read_line", nargs: 0, got: len(args)}
+	}
+	line, err := runtimeOf(env).stdin.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return null, nil
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return objs(strings.TrimSuffix(line, "\r")), nil
+}
+
+func ioReadAll(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 0 {
+		return nil, BadBuiltinNArgs{name: "io.read_all", nargs: 0, got: len(args)}
+	}
+	b, err := ioutil.ReadAll(runtimeOf(env).stdin)
+	if err != nil {
+		return nil, err
+	}
+	return objs(string(b)), nil
+}
+
+// osGetenv returns the value of an environment variable, or null if
+// it is not set.
+func osGetenv(env *object.Environment, args ...object.Object) (object.Object, error) {
+	ss, err := strArgs("os.getenv", 1, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := allowRead(env, "os.getenv"); err != nil {
+		return nil, err
+	}
+	v, ok := os.LookupEnv(ss[0])
+	if !ok {
+		return null, nil
+	}
+	return objs(v), nil
+}
+
+func osGlob(env *object.Environment, args ...object.Object) (object.Object, error) {
+	ss, err := strArgs("os.glob", 1, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := allowRead(env, "os.glob"); err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(ss[0])
+	if err != nil {
+		return nil, err
+	}
+	return strArr(matches), nil
+}
+
+// osExec runs a command to completion and returns a hash holding its
+// stdout, stderr and exit code. A command exiting with a non zero code
+// is not an error.
+func osExec(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) < 1 {
+		return nil, BadBuiltinNArgs{name: "os.exec", nargs: 1, got: len(args)}
+	}
+	ss, err := strs("os.exec", args)
+	if err != nil {
+		return nil, err
+	}
+	if err := allowRun(env, "os.exec"); err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(ss[0], ss[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	code := 0
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) {
+			return nil, err
+		}
+		code = exit.ExitCode()
+	}
+	result := object.NewHashMap()
+	result.Set(nil, objs("stdout"), objs(stdout.String()))
+	result.Set(nil, objs("stderr"), objs(stderr.String()))
+	result.Set(nil, objs("code"), obji(int64(code)))
+	return result, nil
+}
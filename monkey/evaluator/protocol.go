@@ -0,0 +1,257 @@
+package evaluator
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/token"
+)
+
+// Hashes overload operators and builtins by binding protocol functions
+// to their keys, each applied to the operands:
+//
+//	__add__(a, b)    a + b
+//	__eq__(a, b)     a == b and a != b, returns a boolean
+//	__lt__(a, b)     a < b and b > a, returns a boolean
+//	__str__(h)       str, puts and the REPL, returns a string
+//	__hash__(h)      h as a hash key, returns a hashable value
+//	__len__(h)       len, returns an integer
+//	__index__(h, k)  h[k] when h does not hold k
+//
+// The value returned by __hash__ may not hold a hash with a __hash__
+// protocol, such as the hash itself.
+//
+// The protocol of the left operand is preferred to the one of the
+// right operand.
+var infixProtocols = map[string]string{
+	token.PLUS: "__add__",
+	token.EQ:   "__eq__",
+	token.NEQ:  "__eq__",
+	token.LT:   "__lt__",
+	token.GT:   "__lt__",
+}
+
+func init() {
+	// The builtins dispatching to protocols are registered here as
+	// they refer back to apply, which refers to the builtins table.
+	builtins["len"] = &object.BuiltinFunct{
+		Params: "x",
+		Doc:    "Returns the number of characters of a string, or the length of an array or hash, given by __len__ if the hash has one.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) != 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "len",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			switch arg := args[0].(type) {
+			case *object.Str:
+				// Strings are indexed and sliced by
+				// characters, not bytes.
+				return obji(int64(utf8.RuneCountInString(string(*arg)))), nil
+			case object.Arr:
+				return obji(int64(len(arg))), nil
+			case *object.HashMap:
+				fn := arg.Protocol("__len__")
+				if fn == nil {
+					return obji(int64(arg.Len())), nil
+				}
+				n, err := apply(env, fn, args)
+				if err != nil {
+					return nil, err
+				}
+				if n.Type() != object.Integer {
+					return nil, fmt.Errorf("len: __len__ returned %s, want Integer", n.Type())
+				}
+				return n, nil
+			default:
+				return nil, BadBuiltinArg{
+					name:    "len",
+					argtype: args[0].Type(),
+				}
+			}
+		},
+	}
+	builtins["str"] = &object.BuiltinFunct{
+		Params: "value",
+		Doc:    "Converts a value to a string, strings are returned as they are.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) != 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "str",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			return objs(tostr(env, args[0])), nil
+		},
+	}
+	builtins["format"] = &object.BuiltinFunct{
+		Params: "format, ...args",
+		Doc:    "Formats args according to the Go style format string.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) < 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "format",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			format, ok := args[0].(*object.Str)
+			if !ok {
+				return nil, BadBuiltinArg{
+					name:    "format",
+					argtype: args[0].Type(),
+				}
+			}
+			// Convert to go values so verbs such as %d, %x
+			// and %q apply, the rest are formatted as they
+			// are displayed.
+			values := make([]interface{}, len(args)-1)
+			for i, arg := range args[1:] {
+				switch arg := arg.(type) {
+				case *object.Int:
+					values[i] = int64(*arg)
+				case *object.BigInt:
+					values[i] = arg.Value
+				case *object.Flt:
+					values[i] = float64(*arg)
+				case *object.Str:
+					values[i] = string(*arg)
+				case *object.Bool:
+					values[i] = bool(*arg)
+				default:
+					values[i] = Inspect(env, arg)
+				}
+			}
+			return objs(fmt.Sprintf(string(*format), values...)), nil
+		},
+	}
+	builtins["puts"] = &object.BuiltinFunct{
+		Params: "...args",
+		Doc:    "Prints each argument on its own line and returns null.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			for _, arg := range args {
+				fmt.Fprintln(runtimeOf(env).stdout(), Inspect(env, arg))
+			}
+			return null, nil
+		},
+	}
+}
+
+// Caller returns the object.Caller applying the protocols of hashes
+// for the programs running in env.
+func Caller(env *object.Environment) object.Caller {
+	return func(fn object.Object, args []object.Object) (object.Object, error) {
+		return apply(env, fn, args)
+	}
+}
+
+// Inspect returns the string representation of o as puts prints it in
+// env. A hash with a __str__ protocol is represented by the string it
+// returns, unless __str__ fails or inspects the hash again, which gets
+// the pairs of the hash instead of recursing.
+func Inspect(env *object.Environment, o object.Object) string {
+	switch o := o.(type) {
+	case object.Arr:
+		es := make([]string, len(o))
+		for i, e := range o {
+			es[i] = Inspect(env, e)
+		}
+		return "[" + strings.Join(es, ", ") + "]"
+	case *object.HashMap:
+		if s, ok := str(env, o); ok {
+			return s
+		}
+		pairs := o.Pairs()
+		ps := make([]string, len(pairs))
+		for i, p := range pairs {
+			ps[i] = Inspect(env, p.Key) + ": " + Inspect(env, p.Value)
+		}
+		return "{" + strings.Join(ps, ", ") + "}"
+	}
+	return o.Inspect()
+}
+
+// inspection is the call state of the calls made from a __str__
+// protocol, listing the hashes inspected by the calls they were made
+// from, innermost first. Each task carries its own, so tasks
+// inspecting a hash at once all apply its __str__.
+type inspection struct {
+	hash  *object.HashMap
+	outer *inspection
+}
+
+// str applies the __str__ protocol of h, reporting false when h has
+// none, when the call running in env is inspecting h already, or when
+// __str__ fails or does not return a string.
+func str(env *object.Environment, h *object.HashMap) (string, bool) {
+	fn := h.Protocol("__str__")
+	if fn == nil {
+		return "", false
+	}
+	in, _ := env.CallState().(*inspection)
+	for i := in; i != nil; i = i.outer {
+		if i.hash == h {
+			return "", false
+		}
+	}
+	call := object.NewEnclosedEnvironment(env, 0)
+	call.SetCallState(&inspection{hash: h, outer: in})
+	v, err := apply(call, fn, []object.Object{h})
+	if err != nil {
+		return "", false
+	}
+	s, ok := v.(*object.Str)
+	if !ok {
+		return "", false
+	}
+	return string(*s), true
+}
+
+// protocol returns the function o binds to a protocol when o is a
+// hash, nil otherwise.
+func protocol(o object.Object, name string) object.Object {
+	h, ok := o.(*object.HashMap)
+	if !ok {
+		return nil
+	}
+	return h.Protocol(name)
+}
+
+// evalInfixProtocol applies the protocol overloading op, reporting
+// false when neither operand has one.
+func evalInfixProtocol(env *object.Environment, op string, left, right object.Object) (object.Object, bool, error) {
+	name, ok := infixProtocols[op]
+	if !ok {
+		return nil, false, nil
+	}
+	fn := protocol(left, name)
+	if fn == nil {
+		fn = protocol(right, name)
+	}
+	if fn == nil {
+		return nil, false, nil
+	}
+	if op == token.GT {
+		left, right = right, left
+	}
+	v, err := apply(env, fn, []object.Object{left, right})
+	if err != nil {
+		return nil, true, err
+	}
+	if op == token.PLUS {
+		return v, true, nil
+	}
+	b, ok := v.(*object.Bool)
+	if !ok {
+		return nil, true, fmt.Errorf("%s returned %s, want Boolean", name, v.Type())
+	}
+	if op == token.NEQ {
+		return objb(!bool(*b)), true, nil
+	}
+	return b, true, nil
+}
@@ -0,0 +1,160 @@
+package evaluator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/emb/play/monkey/object"
+)
+
+// reArg returns the regular expression given to a builtin, compiled by
+// re.compile or a pattern compiled on the spot.
+func reArg(name string, o object.Object) (*regexp.Regexp, error) {
+	switch o := o.(type) {
+	case *object.Re:
+		return o.Value, nil
+	case *object.Str:
+		re, err := regexp.Compile(string(*o))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		return re, nil
+	}
+	return nil, BadBuiltinArg{name: name, argtype: o.Type()}
+}
+
+// reArgs checks the arguments of the builtins taking a regular
+// expression followed by a string.
+func reArgs(name string, args []object.Object) (*regexp.Regexp, string, error) {
+	if len(args) != 2 {
+		return nil, "", BadBuiltinNArgs{name: name, nargs: 2, got: len(args)}
+	}
+	re, err := reArg(name, args[0])
+	if err != nil {
+		return nil, "", err
+	}
+	ss, err := strs(name, args[1:])
+	if err != nil {
+		return nil, "", err
+	}
+	return re, ss[0], nil
+}
+
+// submatches returns the match of s at loc followed by its groups,
+// null for the groups which did not take part in the match.
+func submatches(s string, loc []int) object.Arr {
+	arr := make(object.Arr, len(loc)/2)
+	for i := range arr {
+		if loc[2*i] < 0 {
+			arr[i] = null
+			continue
+		}
+		arr[i] = objs(s[loc[2*i]:loc[2*i+1]])
+	}
+	return arr
+}
+
+func reCompile(env *object.Environment, args ...object.Object) (object.Object, error) {
+	ss, err := strArgs("re.compile", 1, args)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(ss[0])
+	if err != nil {
+		return nil, fmt.Errorf("re.compile: %s", err)
+	}
+	return &object.Re{Value: re}, nil
+}
+
+func reMatch(env *object.Environment, args ...object.Object) (object.Object, error) {
+	re, s, err := reArgs("re.match", args)
+	if err != nil {
+		return nil, err
+	}
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return null, nil
+	}
+	return submatches(s, loc), nil
+}
+
+// reFindAll returns the matches as strings, or as arrays holding their
+// groups when the expression has any.
+func reFindAll(env *object.Environment, args ...object.Object) (object.Object, error) {
+	re, s, err := reArgs("re.find_all", args)
+	if err != nil {
+		return nil, err
+	}
+	matches := object.Arr{}
+	for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+		if re.NumSubexp() == 0 {
+			matches = append(matches, objs(s[loc[0]:loc[1]]))
+		} else {
+			matches = append(matches, submatches(s, loc))
+		}
+	}
+	return matches, nil
+}
+
+// reSplit splits like Python's re.split, the groups of a separator are
+// kept between the parts it separates.
+func reSplit(env *object.Environment, args ...object.Object) (object.Object, error) {
+	re, s, err := reArgs("re.split", args)
+	if err != nil {
+		return nil, err
+	}
+	parts := object.Arr{}
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+		parts = append(parts, objs(s[last:loc[0]]))
+		parts = append(parts, submatches(s, loc)[1:]...)
+		last = loc[1]
+	}
+	return append(parts, objs(s[last:])), nil
+}
+
+// reReplace replaces the matches of a regular expression by a string,
+// where $1 or ${name} stand for a group, or by the result of a function
+// given the match followed by its groups.
+func reReplace(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 3 {
+		return nil, BadBuiltinNArgs{name: "re.replace", nargs: 3, got: len(args)}
+	}
+	re, s, err := reArgs("re.replace", args[:2])
+	if err != nil {
+		return nil, err
+	}
+	if repl, ok := args[2].(*object.Str); ok {
+		return objs(re.ReplaceAllString(s, string(*repl))), nil
+	}
+	if err := fnArg("re.replace", args[2]); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+		v, err := apply(env, args[2], []object.Object{submatches(s, loc)})
+		if err != nil {
+			return nil, err
+		}
+		repl, ok := v.(*object.Str)
+		if !ok {
+			return nil, fmt.Errorf("re.replace: function returned %s, want String", v.Type())
+		}
+		b.WriteString(s[last:loc[0]])
+		b.WriteString(string(*repl))
+		last = loc[1]
+	}
+	b.WriteString(s[last:])
+	return objs(b.String()), nil
+}
+
+func init() {
+	// re.replace refers back to apply, which refers to the modules.
+	modules["re"].Members["replace"] = &object.BuiltinFunct{
+		Fn:     reReplace,
+		Params: "re, s, repl",
+		Doc:    "Replaces the matches of re in s by repl, where $1 stands for a group, or by the result of the function repl given the match and its groups.",
+	}
+}
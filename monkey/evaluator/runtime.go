@@ -0,0 +1,94 @@
+package evaluator
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/emb/play/monkey/object"
+)
+
+// Runtime is the state of the programs evaluated in a global
+// environment and of the tasks they spawn: where they print and read,
+// the capabilities they are granted and whether they are interrupted.
+// Hosts store a runtime in the environment with SetRuntime, programs
+// evaluated in an environment without one share a runtime printing to
+// and reading from the process without any capability.
+//
+// The zero Runtime is ready to use, its fields must not change once
+// programs run.
+type Runtime struct {
+	// Stdout is where puts prints, the standard output of the
+	// process when nil.
+	Stdout io.Writer
+	// Stdin is read by the io and json modules, the standard input
+	// of the process when nil.
+	Stdin io.Reader
+	// Allowed holds the capabilities granted to programs. Programs
+	// are sandboxed by default, the monkey command grants
+	// capabilities with the --allow-read, --allow-write and
+	// --allow-run flags.
+	Allowed Capabilities
+
+	once  sync.Once
+	stdin *bufio.Reader
+	sched *scheduler
+	// interruption holds an interrupted struct, the error returned
+	// by function calls while programs are interrupted.
+	interruption atomic.Value
+}
+
+type interrupted struct{ err error }
+
+// defaultRuntime runs the programs of environments without a runtime.
+var defaultRuntime = &Runtime{}
+
+// runtimeOf returns the runtime of the programs evaluated in env.
+func runtimeOf(env *object.Environment) *Runtime {
+	rt, ok := env.Runtime().(*Runtime)
+	if !ok {
+		rt = defaultRuntime
+	}
+	rt.once.Do(rt.init)
+	return rt
+}
+
+func (rt *Runtime) init() {
+	in := rt.Stdin
+	if in == nil {
+		in = os.Stdin
+	}
+	rt.stdin = bufio.NewReader(in)
+	rt.sched = newScheduler()
+}
+
+func (rt *Runtime) stdout() io.Writer {
+	if rt.Stdout == nil {
+		return os.Stdout
+	}
+	return rt.Stdout
+}
+
+// Interrupt stops the programs running with rt along with their tasks,
+// their next function call or iteration returns err. Programs run
+// again once Interrupt is called with a nil error.
+func (rt *Runtime) Interrupt(err error) {
+	rt.interruption.Store(interrupted{err: err})
+}
+
+// Wait blocks until every task spawned by the programs running with rt
+// has returned. Hosts interrupt a program and wait for its tasks
+// before dropping its runtime.
+func (rt *Runtime) Wait() {
+	rt.once.Do(rt.init)
+	rt.sched.wait()
+}
+
+// interruptErr returns the error programs are interrupted with, if
+// any.
+func (rt *Runtime) interruptErr() error {
+	i, _ := rt.interruption.Load().(interrupted)
+	return i.err
+}
@@ -0,0 +1,390 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/object"
+)
+
+// ErrDeadlock is returned by a channel operation when every running
+// task, including the main program, is blocked.
+var ErrDeadlock = errors.New("deadlock: all tasks are blocked")
+
+// deadlockGrace is how long every task has to stay blocked before a
+// deadlock is reported. Two tasks may block on either side of the
+// same channel at once, the grace lets the runtime pair them up.
+const deadlockGrace = 20 * time.Millisecond
+
+// scheduler counts running and blocked tasks to detect deadlocks.
+type scheduler struct {
+	mu sync.Mutex
+	// idle is signaled when the last task exits.
+	idle    *sync.Cond
+	tasks   int
+	blocked int
+	// progress is bumped whenever a task starts or unblocks.
+	progress uint64
+	// deadlock is closed to wake every blocked task once a
+	// deadlock is detected.
+	deadlock chan struct{}
+}
+
+func newScheduler() *scheduler {
+	s := &scheduler{deadlock: make(chan struct{})}
+	s.idle = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *scheduler) enter() {
+	s.mu.Lock()
+	s.tasks++
+	s.progress++
+	s.mu.Unlock()
+}
+
+func (s *scheduler) exit() {
+	s.mu.Lock()
+	s.tasks--
+	if s.tasks == 0 {
+		s.idle.Broadcast()
+	}
+	s.check()
+	s.mu.Unlock()
+}
+
+// wait blocks until no task is running.
+func (s *scheduler) wait() {
+	s.mu.Lock()
+	for s.tasks > 0 {
+		s.idle.Wait()
+	}
+	s.mu.Unlock()
+}
+
+func (s *scheduler) block() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked++
+	s.check()
+	return s.deadlock
+}
+
+func (s *scheduler) unblock() {
+	s.mu.Lock()
+	s.blocked--
+	s.progress++
+	s.mu.Unlock()
+}
+
+// check arms a deadlock check when every task is blocked, it must be
+// called with s.mu held.
+func (s *scheduler) check() {
+	if s.tasks == 0 || s.blocked < s.tasks {
+		return
+	}
+	progress := s.progress
+	time.AfterFunc(deadlockGrace, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.progress == progress && s.tasks > 0 && s.blocked >= s.tasks {
+			close(s.deadlock)
+			s.deadlock = make(chan struct{})
+		}
+	})
+}
+
+// do performs one of the channel operations in cases, blocking until
+// one can proceed unless nonblocking is set. The index of the chosen
+// case is returned, or -1 if nonblocking and none was ready.
+func (s *scheduler) do(cases []reflect.SelectCase, nonblocking bool) (chosen int, v reflect.Value, ok bool, err error) {
+	defer func() {
+		// Sending to or closing a closed channel panics.
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	try := make([]reflect.SelectCase, len(cases), len(cases)+1)
+	copy(try, cases)
+	try = append(try, reflect.SelectCase{Dir: reflect.SelectDefault})
+	if chosen, v, ok = reflect.Select(try); chosen < len(cases) {
+		return chosen, v, ok, nil
+	}
+	if nonblocking {
+		return -1, v, false, nil
+	}
+
+	deadlock := s.block()
+	defer s.unblock()
+	try[len(cases)] = reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(deadlock),
+	}
+	if chosen, v, ok = reflect.Select(try); chosen == len(cases) {
+		return chosen, v, ok, ErrDeadlock
+	}
+	return chosen, v, ok, nil
+}
+
+// received converts a value received from a channel to an object,
+// receiving from a closed channel results in null.
+func received(v reflect.Value, ok bool) object.Object {
+	if !ok {
+		return null
+	}
+	return v.Interface().(object.Object)
+}
+
+func sendCase(ch *object.Chan, v object.Object) reflect.SelectCase {
+	return reflect.SelectCase{
+		Dir:  reflect.SelectSend,
+		Chan: reflect.ValueOf(ch.C),
+		Send: reflect.ValueOf(&v).Elem(),
+	}
+}
+
+func recvCase(ch *object.Chan) reflect.SelectCase {
+	return reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(ch.C),
+	}
+}
+
+// spawn starts applying fn to args in a new task of the runtime of
+// env. A panic of the task is returned by wait as its error.
+func spawn(env *object.Environment, fn object.Object, args []object.Object) *object.TaskHandle {
+	task := &object.TaskHandle{Done: make(chan struct{})}
+	rt := runtimeOf(env)
+	rt.sched.enter()
+	go func() {
+		defer rt.sched.exit()
+		defer close(task.Done)
+		defer recovered(&task.Err)
+		task.Result, task.Err = apply(env, fn, args)
+	}()
+	return task
+}
+
+// recovered sets *err to the panic being recovered from, if any. Tasks
+// and generators run in goroutines of their own, where a panic would
+// take the host down along with the program.
+func recovered(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("panic: %v", r)
+	}
+}
+
+func evalSelect(n *ast.SelectExpr, env *object.Environment) (object.Object, error) {
+	cases := make([]reflect.SelectCase, len(n.Cases))
+	for i, c := range n.Cases {
+		args, err := evalExprs(c.Comm.Arguments, env)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != len(c.Comm.Arguments) {
+			return nil, BadBuiltinNArgs{
+				name:  c.Comm.Function.String(),
+				nargs: len(c.Comm.Arguments),
+				got:   len(args),
+			}
+		}
+		ch, ok := args[0].(*object.Chan)
+		if !ok {
+			return nil, BadBuiltinArg{
+				name:    c.Comm.Function.String(),
+				argtype: args[0].Type(),
+			}
+		}
+		if c.IsSend() {
+			cases[i] = sendCase(ch, args[1])
+		} else {
+			cases[i] = recvCase(ch)
+		}
+	}
+	chosen, v, ok, err := runtimeOf(env).sched.do(cases, n.Default != nil)
+	if err != nil {
+		return nil, err
+	}
+	if chosen < 0 {
+		return Eval(n.Default, env)
+	}
+	c := n.Cases[chosen]
+	if c.Locals > 0 {
+		env = object.NewEnclosedEnvironment(env, c.Locals)
+	}
+	if c.Name != nil {
+		bind(env, c.Name, received(v, ok))
+	}
+	return Eval(c.Body, env)
+}
+
+var taskBuiltins = map[string]*object.BuiltinFunct{
+	"wait": &object.BuiltinFunct{
+		Params: "task",
+		Doc:    "Waits for a task started by spawn and returns its result.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) != 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "wait",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			task, ok := args[0].(*object.TaskHandle)
+			if !ok {
+				return nil, BadBuiltinArg{
+					name:    "wait",
+					argtype: args[0].Type(),
+				}
+			}
+			done := reflect.SelectCase{
+				Dir:  reflect.SelectRecv,
+				Chan: reflect.ValueOf(task.Done),
+			}
+			if _, _, _, err := runtimeOf(env).sched.do([]reflect.SelectCase{done}, false); err != nil {
+				return nil, err
+			}
+			return task.Result, task.Err
+		},
+	},
+	"chan": &object.BuiltinFunct{
+		Params: "size = 0",
+		Doc:    "Returns a new channel buffering up to size values.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) > 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "chan",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			var size int64
+			if len(args) == 1 {
+				n, ok := args[0].(*object.Int)
+				if !ok {
+					return nil, BadBuiltinArg{
+						name:    "chan",
+						argtype: args[0].Type(),
+					}
+				}
+				size = int64(*n)
+			}
+			if size < 0 {
+				return nil, fmt.Errorf("negative channel size %d", size)
+			}
+			return &object.Chan{C: make(chan object.Object, size)}, nil
+		},
+	},
+	"send": &object.BuiltinFunct{
+		Params: "ch, value",
+		Doc:    "Sends value on a channel, blocking until it is received or buffered.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) != 2 {
+				return nil, BadBuiltinNArgs{
+					name:  "send",
+					nargs: 2,
+					got:   len(args),
+				}
+			}
+			ch, ok := args[0].(*object.Chan)
+			if !ok {
+				return nil, BadBuiltinArg{
+					name:    "send",
+					argtype: args[0].Type(),
+				}
+			}
+			cases := []reflect.SelectCase{sendCase(ch, args[1])}
+			if _, _, _, err := runtimeOf(env).sched.do(cases, false); err != nil {
+				return nil, err
+			}
+			return null, nil
+		},
+	},
+	"recv": &object.BuiltinFunct{
+		Params: "ch",
+		Doc:    "Receives a value from a channel, or null once it is closed.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) != 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "recv",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			ch, ok := args[0].(*object.Chan)
+			if !ok {
+				return nil, BadBuiltinArg{
+					name:    "recv",
+					argtype: args[0].Type(),
+				}
+			}
+			cases := []reflect.SelectCase{recvCase(ch)}
+			_, v, ok, err := runtimeOf(env).sched.do(cases, false)
+			if err != nil {
+				return nil, err
+			}
+			return received(v, ok), nil
+		},
+	},
+	"close": &object.BuiltinFunct{
+		Params: "ch",
+		Doc:    "Closes a channel.",
+		Fn: func(env *object.Environment, args ...object.Object) (result object.Object, err error) {
+			if len(args) != 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "close",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			ch, ok := args[0].(*object.Chan)
+			if !ok {
+				return nil, BadBuiltinArg{
+					name:    "close",
+					argtype: args[0].Type(),
+				}
+			}
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("%v", r)
+				}
+			}()
+			close(ch.C)
+			return null, nil
+		},
+	},
+}
+
+func init() {
+	for name, fn := range taskBuiltins {
+		builtins[name] = fn
+	}
+	// spawn is registered here as it refers back to apply, which
+	// refers to the builtins table.
+	builtins["spawn"] = &object.BuiltinFunct{
+		Params: "fn, ...args",
+		Doc:    "Starts applying fn to args in a new task and returns the task.",
+		Fn: func(env *object.Environment, args ...object.Object) (object.Object, error) {
+			if len(args) < 1 {
+				return nil, BadBuiltinNArgs{
+					name:  "spawn",
+					nargs: 1,
+					got:   len(args),
+				}
+			}
+			switch args[0].(type) {
+			case *object.Funct, *object.BuiltinFunct:
+				return spawn(env, args[0], args[1:]), nil
+			default:
+				return nil, BadBuiltinArg{
+					name:    "spawn",
+					argtype: args[0].Type(),
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,287 @@
+package evaluator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/token"
+)
+
+func objt(t time.Time) *object.Tm { return &object.Tm{Value: t} }
+
+func objd(d time.Duration) *object.Dur {
+	o := object.Dur(d)
+	return &o
+}
+
+// errDuration is returned by arithmetic on durations, and differences
+// of times, overflowing the range of a duration, about 292 years.
+var errDuration = errors.New("duration out of range")
+
+// layouts maps the names accepted by time.parse and time.format to
+// the layouts of the time package, other layouts are given as Go
+// layouts such as "2006-01-02 15:04".
+var layouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"UnixDate":    time.UnixDate,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// timely reports whether o is a time or a duration.
+func timely(o object.Object) bool {
+	return o.Type() == object.Time || o.Type() == object.Duration
+}
+
+// evalInfixTimes applies op to times, durations and integers scaling
+// durations. The difference of two times is a duration, which can be
+// added to or subtracted from a time.
+func evalInfixTimes(op string, left, right object.Object) (object.Object, error) {
+	switch l := left.(type) {
+	case *object.Tm:
+		switch r := right.(type) {
+		case *object.Tm:
+			switch op {
+			case token.MINUS:
+				// Sub saturates differences out of the range
+				// of a duration, which adding them back shows.
+				d := l.Value.Sub(r.Value)
+				if !r.Value.Add(d).Equal(l.Value) {
+					return nil, errDuration
+				}
+				return objd(d), nil
+			case token.LT:
+				return objb(l.Value.Before(r.Value)), nil
+			case token.GT:
+				return objb(l.Value.After(r.Value)), nil
+			case token.EQ:
+				return objb(l.Value.Equal(r.Value)), nil
+			case token.NEQ:
+				return objb(!l.Value.Equal(r.Value)), nil
+			}
+		case *object.Dur:
+			switch op {
+			case token.PLUS:
+				return objt(l.Value.Add(time.Duration(*r))), nil
+			case token.MINUS:
+				d, err := durInfix(token.MINUS, objd(0), r)
+				if err != nil {
+					return nil, err
+				}
+				return objt(l.Value.Add(time.Duration(*d.(*object.Dur)))), nil
+			}
+		}
+	case *object.Dur:
+		switch r := right.(type) {
+		case *object.Dur:
+			switch op {
+			case token.PLUS, token.MINUS:
+				return durInfix(op, l, r)
+			case token.SLASH:
+				// The ratio of two durations is an integer.
+				return evalInfixInts(op, obji(int64(*l)), obji(int64(*r)))
+			case token.LT:
+				return objb(*l < *r), nil
+			case token.GT:
+				return objb(*l > *r), nil
+			case token.EQ:
+				return objb(*l == *r), nil
+			case token.NEQ:
+				return objb(*l != *r), nil
+			}
+		case *object.Tm:
+			if op == token.PLUS {
+				return objt(r.Value.Add(time.Duration(*l))), nil
+			}
+		case *object.Int, *object.BigInt:
+			switch op {
+			case token.ASTERISK, token.SLASH:
+				return durInfix(op, l, r)
+			}
+		}
+	case *object.Int, *object.BigInt:
+		if r, ok := right.(*object.Dur); ok && op == token.ASTERISK {
+			return durInfix(op, r, l)
+		}
+	}
+	if left.Type() != right.Type() {
+		return nil, OpTypeMismatch{left: left.Type(), op: op, right: right.Type()}
+	}
+	return nil, BadInfixOp{left: left.Type(), op: op, right: right.Type()}
+}
+
+// durInfix applies op to a duration and a duration or an integer with
+// the arithmetic of integers, failing when the result is not a
+// duration.
+func durInfix(op string, l *object.Dur, r object.Object) (object.Object, error) {
+	if d, ok := r.(*object.Dur); ok {
+		r = obji(int64(*d))
+	}
+	v, err := evalInfixInts(op, obji(int64(*l)), r)
+	if err != nil {
+		return nil, err
+	}
+	i, ok := v.(*object.Int)
+	if !ok {
+		return nil, errDuration
+	}
+	return objd(time.Duration(*i)), nil
+}
+
+// timeArg converts the argument of a builtin to a time.
+func timeArg(name string, o object.Object) (time.Time, error) {
+	t, ok := o.(*object.Tm)
+	if !ok {
+		return time.Time{}, BadBuiltinArg{name: name, argtype: o.Type()}
+	}
+	return t.Value, nil
+}
+
+// layoutArg returns the layout of time.parse and time.format, given
+// by name or as a Go layout, RFC3339 when args holds none.
+func layoutArg(name string, args []object.Object) (string, error) {
+	if len(args) == 0 {
+		return time.RFC3339, nil
+	}
+	ss, err := strs(name, args)
+	if err != nil {
+		return "", err
+	}
+	if layout, ok := layouts[ss[0]]; ok {
+		return layout, nil
+	}
+	return ss[0], nil
+}
+
+func timeNow(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 0 {
+		return nil, BadBuiltinNArgs{name: "time.now", nargs: 0, got: len(args)}
+	}
+	return objt(time.Now()), nil
+}
+
+func timeUnix(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 1 {
+		return nil, BadBuiltinNArgs{name: "time.unix", nargs: 1, got: len(args)}
+	}
+	ns, err := intArgs("time.unix", args)
+	if err != nil {
+		return nil, err
+	}
+	return objt(time.Unix(ns[0], 0).UTC()), nil
+}
+
+func timeToUnix(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 1 {
+		return nil, BadBuiltinNArgs{name: "time.to_unix", nargs: 1, got: len(args)}
+	}
+	t, err := timeArg("time.to_unix", args[0])
+	if err != nil {
+		return nil, err
+	}
+	return obji(t.Unix()), nil
+}
+
+func timeParse(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, BadBuiltinNArgs{name: "time.parse", nargs: 2, got: len(args)}
+	}
+	ss, err := strs("time.parse", args[:1])
+	if err != nil {
+		return nil, err
+	}
+	layout, err := layoutArg("time.parse", args[1:])
+	if err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(layout, ss[0])
+	if err != nil {
+		return nil, fmt.Errorf("time.parse: %s", err)
+	}
+	return objt(t), nil
+}
+
+func timeFormat(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, BadBuiltinNArgs{name: "time.format", nargs: 2, got: len(args)}
+	}
+	t, err := timeArg("time.format", args[0])
+	if err != nil {
+		return nil, err
+	}
+	layout, err := layoutArg("time.format", args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return objs(t.Format(layout)), nil
+}
+
+func timeIn(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 2 {
+		return nil, BadBuiltinNArgs{name: "time.in", nargs: 2, got: len(args)}
+	}
+	t, err := timeArg("time.in", args[0])
+	if err != nil {
+		return nil, err
+	}
+	ss, err := strs("time.in", args[1:])
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(ss[0])
+	if err != nil {
+		return nil, fmt.Errorf("time.in: %s", err)
+	}
+	return objt(t.In(loc)), nil
+}
+
+// timeZone returns the abbreviated name of the zone of a time and its
+// offset from UTC.
+func timeZone(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 1 {
+		return nil, BadBuiltinNArgs{name: "time.zone", nargs: 1, got: len(args)}
+	}
+	t, err := timeArg("time.zone", args[0])
+	if err != nil {
+		return nil, err
+	}
+	name, offset := t.Zone()
+	return object.Arr{objs(name), objd(time.Duration(offset) * time.Second)}, nil
+}
+
+func timeAddDate(env *object.Environment, args ...object.Object) (object.Object, error) {
+	if len(args) != 4 {
+		return nil, BadBuiltinNArgs{name: "time.add_date", nargs: 4, got: len(args)}
+	}
+	t, err := timeArg("time.add_date", args[0])
+	if err != nil {
+		return nil, err
+	}
+	ns, err := intArgs("time.add_date", args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return objt(t.AddDate(int(ns[0]), int(ns[1]), int(ns[2]))), nil
+}
+
+func timeDuration(env *object.Environment, args ...object.Object) (object.Object, error) {
+	ss, err := strArgs("time.duration", 1, args)
+	if err != nil {
+		return nil, err
+	}
+	d, err := time.ParseDuration(ss[0])
+	if err != nil {
+		return nil, fmt.Errorf("time.duration: %s", err)
+	}
+	return objd(d), nil
+}
@@ -3,6 +3,8 @@
 package lexer
 
 import (
+	"strings"
+
 	"github.com/emb/play/monkey/token"
 )
 
@@ -17,22 +19,37 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-// New creates a new lexer
+// New creates a new lexer, comments are skipped.
 func New(input string) *Lexer {
 	l := &Lexer{input: input}
 	l.readChar()
 	return l
 }
 
+// NewWithComments creates a new lexer returning comments as
+// token.COMMENT tokens.
+func NewWithComments(input string) *Lexer {
+	l := New(input)
+	l.comments = true
+	return l
+}
+
 // Lexer a type used to tokenize the monkey language.
 type Lexer struct {
 	input        string
 	position     int  // current position
 	readPosition int  // reading position after current char
 	ch           byte // char being examined
+	line         int  // line of ch
+	column       int  // column of ch
+	comments     bool // return comments as tokens
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' || l.line == 0 {
+		l.line++
+		l.column = 0
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -40,6 +57,7 @@ func (l *Lexer) readChar() {
 	}
 	l.position = l.readPosition
 	l.readPosition++
+	l.column++
 }
 
 func (l *Lexer) peekChar() byte {
@@ -56,6 +74,35 @@ func (l *Lexer) skip() {
 	}
 }
 
+// comment reads a line comment or a block comment, block comments
+// nest. ok is false for an unterminated block comment.
+func (l *Lexer) comment() (text string, ok bool) {
+	pos := l.position
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return l.input[pos:l.position], true
+	}
+	l.readChar()
+	depth := 1
+	for depth > 0 {
+		l.readChar()
+		switch {
+		case l.ch == 0:
+			return l.input[pos:l.position], false
+		case l.ch == '/' && l.peekChar() == '*':
+			l.readChar()
+			depth++
+		case l.ch == '*' && l.peekChar() == '/':
+			l.readChar()
+			depth--
+		}
+	}
+	l.readChar()
+	return l.input[pos:l.position], true
+}
+
 // ident reads an identifier
 func (l *Lexer) ident() string {
 	pos := l.position
@@ -73,30 +120,187 @@ func (l *Lexer) number() string {
 	return l.input[pos:l.position]
 }
 
-func (l *Lexer) string() string {
+// string reads a double quoted string. Escape sequences are replaced
+// unless the string holds ${} interpolations, in which case it is
+// returned raw to be split with Segments and template is true. ok is
+// false for an unterminated string, lit then holds its text from the
+// opening quote.
+func (l *Lexer) string() (lit string, template, ok bool) {
 	pos := l.position + 1
+	var buf strings.Builder
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+		switch {
+		case l.ch == 0:
+			return l.input[pos-1 : l.position], template, false
+		case l.ch == '"':
+			if template {
+				return l.input[pos:l.position], true, true
+			}
+			return buf.String(), false, true
+		case l.ch == '\\' && l.peekChar() != 0:
+			l.readChar()
+			buf.WriteString(unescape(l.ch))
+		case l.ch == '$' && l.peekChar() == '{':
+			template = true
+			l.readChar()
+			if !l.interpolation() {
+				return l.input[pos-1 : l.position], true, false
+			}
+		default:
+			buf.WriteByte(l.ch)
+		}
+	}
+}
+
+// raw reads a back quoted string which may span multiple lines, its
+// content is taken as is. ok is false for an unterminated string, lit
+// then holds its text from the opening quote.
+func (l *Lexer) raw() (lit string, ok bool) {
+	pos := l.position + 1
+	for {
+		l.readChar()
+		switch l.ch {
+		case 0:
+			return l.input[pos-1 : l.position], false
+		case '`':
+			return l.input[pos:l.position], true
+		}
+	}
+}
+
+// interpolation skips the expression of a ${} interpolation leaving
+// the lexer at its closing brace. Strings nested in the expression are
+// skipped so their braces and quotes are not mistaken for the end. ok
+// is false when the input ends before the closing brace, the lexer is
+// then left at the end of the input.
+func (l *Lexer) interpolation() (ok bool) {
+	depth := 1
+	for {
+		l.readChar()
+		switch l.ch {
+		case 0:
+			return false
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return true
+			}
+		case '"':
+			if _, _, ok := l.string(); !ok {
+				return false
+			}
+		case '`':
+			if _, ok := l.raw(); !ok {
+				return false
+			}
 		}
 	}
-	return l.input[pos:l.position]
+}
+
+// unescape returns the character described by the escape sequence
+// \ch. Unknown escape sequences are kept as is.
+func unescape(ch byte) string {
+	switch ch {
+	case 'n':
+		return "\n"
+	case 't':
+		return "\t"
+	case 'r':
+		return "\r"
+	case '"', '\\', '$':
+		return string(ch)
+	default:
+		return "\\" + string(ch)
+	}
+}
+
+// Segment is a part of an interpolated string, either text or the
+// source of an interpolated expression.
+type Segment struct {
+	Text string
+	Expr bool
+}
+
+// Segments splits the literal of a token.TEMPLATE into text, with
+// escape sequences replaced, and the source of the ${} expressions.
+func Segments(template string) []Segment {
+	l := New(template)
+	segs := []Segment{}
+	var buf strings.Builder
+	for l.ch != 0 {
+		switch {
+		case l.ch == '\\' && l.peekChar() != 0:
+			l.readChar()
+			buf.WriteString(unescape(l.ch))
+		case l.ch == '$' && l.peekChar() == '{':
+			if buf.Len() > 0 {
+				segs = append(segs, Segment{Text: buf.String()})
+				buf.Reset()
+			}
+			l.readChar()
+			pos := l.position + 1
+			l.interpolation()
+			segs = append(segs, Segment{
+				Text: l.input[pos:l.position],
+				Expr: true,
+			})
+		default:
+			buf.WriteByte(l.ch)
+		}
+		if l.ch != 0 {
+			l.readChar()
+		}
+	}
+	if buf.Len() > 0 {
+		segs = append(segs, Segment{Text: buf.String()})
+	}
+	return segs
 }
 
 // NextToken returns a next token every time it is called on a given
 // input. When tokens run out token.EOF is returned.
 func (l *Lexer) NextToken() token.Token {
+	if l.position == 0 && l.ch == '#' && l.peekChar() == '!' {
+		// Skip the shebang line of a script.
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+	}
+	for {
+		l.skip()
+		pos := token.Position{Line: l.line, Column: l.column}
+		if l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*') {
+			text, ok := l.comment()
+			if !ok {
+				return token.Token{Type: token.ILLEGAL, Literal: text, Pos: pos}
+			}
+			if !l.comments {
+				continue
+			}
+			return token.Token{Type: token.COMMENT, Literal: text, Pos: pos}
+		}
+		tok := l.token()
+		tok.Pos = pos
+		return tok
+	}
+}
+
+func (l *Lexer) token() token.Token {
 	new := func(t token.Type, ch byte) token.Token {
 		return token.Token{Type: t, Literal: string(ch)}
 	}
 
-	l.skip()
-
 	var tok token.Token
 	switch l.ch {
 	case '=':
-		if l.peekChar() == '=' {
+		if l.peekChar() == '>' {
+			l.readChar()
+			tok.Type = token.ARROW
+			tok.Literal = "=>"
+		} else if l.peekChar() == '=' {
 			eq := string(l.ch)
 			l.readChar()
 			eq += string(l.ch)
@@ -127,12 +331,30 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = new(token.BANG, l.ch)
 		}
+	case '|':
+		if l.peekChar() == '>' {
+			l.readChar()
+			tok.Type = token.PIPE
+			tok.Literal = "|>"
+		} else {
+			tok = new(token.ILLEGAL, l.ch)
+		}
 	case ':':
 		tok = new(token.COLON, l.ch)
 	case ';':
 		tok = new(token.SEMICOLON, l.ch)
 	case ',':
 		tok = new(token.COMMA, l.ch)
+	case '.':
+		if l.peekChar() == '.' && l.readPosition+1 < len(l.input) &&
+			l.input[l.readPosition+1] == '.' {
+			l.readChar()
+			l.readChar()
+			tok.Type = token.ELLIPSIS
+			tok.Literal = "..."
+		} else {
+			tok = new(token.DOT, l.ch)
+		}
 	case '(':
 		tok = new(token.LPAREN, l.ch)
 	case ')':
@@ -146,8 +368,23 @@ func (l *Lexer) NextToken() token.Token {
 	case ']':
 		tok = new(token.RBRACKET, l.ch)
 	case '"':
+		lit, template, ok := l.string()
+		switch {
+		case !ok:
+			tok.Type = token.ILLEGAL
+		case template:
+			tok.Type = token.TEMPLATE
+		default:
+			tok.Type = token.STRING
+		}
+		tok.Literal = lit
+	case '`':
+		lit, ok := l.raw()
 		tok.Type = token.STRING
-		tok.Literal = l.string()
+		if !ok {
+			tok.Type = token.ILLEGAL
+		}
+		tok.Literal = lit
 	case 0:
 		tok.Type = token.EOF
 	default:
@@ -1,6 +1,10 @@
 package lexer
 
 import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/emb/play/monkey/token"
@@ -15,7 +19,7 @@ let add = fn(x, y) {
 };
 
 let result = add(some_x, some_y);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -30,6 +34,8 @@ if (5 < 10) {
 "foo bar";
 [1, 2];
 {"foo": "bar"};
+f(...xs).y;
+a |> f;
 `
 	tests := []struct {
 		wantType    token.Type
@@ -124,6 +130,18 @@ if (5 < 10) {
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "xs"},
+		{token.RPAREN, ")"},
+		{token.DOT, "."},
+		{token.IDENT, "y"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.PIPE, "|>"},
+		{token.IDENT, "f"},
+		{token.SEMICOLON, ";"},
 		{token.EOF, ""},
 	}
 
@@ -141,3 +159,144 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantType    token.Type
+		wantLiteral string
+	}{
+		{`"plain"`, token.STRING, "plain"},
+		{`"tab\tnew\nline \"quoted\" \\ \$"`, token.STRING, "tab\tnew\nline \"quoted\" \\ $"},
+		{`"unknown \q"`, token.STRING, `unknown \q`},
+		{`"trailing \`, token.ILLEGAL, `"trailing \`},
+		{`"${`, token.ILLEGAL, `"${`},
+		{`"a${b`, token.ILLEGAL, `"a${b`},
+		{`"abc ${"x"`, token.ILLEGAL, `"abc ${"x"`},
+		{`"${"x}`, token.ILLEGAL, `"${"x}`},
+		{"\"${`x}", token.ILLEGAL, "\"${`x}"},
+		{"`raw", token.ILLEGAL, "`raw"},
+		{"`raw \\n ${x}\nlines`", token.STRING, "raw \\n ${x}\nlines"},
+		{`"hi ${name}!"`, token.TEMPLATE, `hi ${name}!`},
+		{`"${f("}")} \"${x}\""`, token.TEMPLATE, `${f("}")} \"${x}\"`},
+		{`"$ {x} $x"`, token.STRING, "$ {x} $x"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			tok := New(tc.input).NextToken()
+			if tok.Type != tc.wantType {
+				t.Errorf("bad token type want=%q, got=%q", tc.wantType, tok.Type)
+			}
+			if tok.Literal != tc.wantLiteral {
+				t.Errorf("bad literal want=%q, got=%q", tc.wantLiteral, tok.Literal)
+			}
+		})
+	}
+}
+
+func TestSegments(t *testing.T) {
+	tests := []struct {
+		template string
+		want     []Segment
+	}{
+		{`hi ${name}!`, []Segment{{"hi ", false}, {"name", true}, {"!", false}}},
+		{`${a}${b + 1}`, []Segment{{"a", true}, {"b + 1", true}}},
+		{`${ {"k": "}"}["k"] }`, []Segment{{` {"k": "}"}["k"] `, true}}},
+		{`\${x} \n${y}`, []Segment{{"${x} \n", false}, {"y", true}}},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			got := Segments(tc.template)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Segments(%q) is %+v, want %+v", tc.template, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := "let x = 1;\n  match x {\n\t_ => \"a\" }"
+	want := []token.Position{
+		{Line: 1, Column: 1}, {Line: 1, Column: 5}, {Line: 1, Column: 7},
+		{Line: 1, Column: 9}, {Line: 1, Column: 10},
+		{Line: 2, Column: 3}, {Line: 2, Column: 9}, {Line: 2, Column: 11},
+		{Line: 3, Column: 2}, {Line: 3, Column: 4}, {Line: 3, Column: 7},
+		{Line: 3, Column: 11}, {Line: 3, Column: 12},
+	}
+	l := New(input)
+	for i, pos := range want {
+		tok := l.NextToken()
+		if tok.Pos != pos {
+			t.Errorf("token[%d] %q is at %s, want %s", i, tok.Literal, tok.Pos, pos)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `#! /usr/bin/env monkey
+// add two numbers
+let add = fn(x, y) { x + y }; // trailing
+/* block /* nested */ still comment */ add(1, 2 / 1)
+/* unterminated`
+	tests := []struct {
+		comments bool
+		want     []token.Token
+	}{
+		{
+			comments: false,
+			want: []token.Token{
+				{Type: token.LET, Literal: "let"},
+				{Type: token.IDENT, Literal: "add"},
+				{Type: token.ASSIGN, Literal: "="},
+				{Type: token.FUNCTION, Literal: "fn"},
+			},
+		},
+		{
+			comments: true,
+			want: []token.Token{
+				{Type: token.COMMENT, Literal: "// add two numbers"},
+				{Type: token.LET, Literal: "let"},
+				{Type: token.IDENT, Literal: "add"},
+				{Type: token.ASSIGN, Literal: "="},
+				{Type: token.FUNCTION, Literal: "fn"},
+			},
+		},
+	}
+	for _, tc := range tests {
+		l := New(input)
+		if tc.comments {
+			l = NewWithComments(input)
+		}
+		for i, want := range tc.want {
+			tok := l.NextToken()
+			if tok.Type != want.Type || tok.Literal != want.Literal {
+				t.Fatalf("comments %t token[%d] is %s %q, want %s %q",
+					tc.comments, i, tok.Type, tok.Literal, want.Type, want.Literal)
+			}
+		}
+		var got []token.Token
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			got = append(got, tok)
+		}
+		var lits []string
+		for _, tok := range got {
+			if tok.Type == token.COMMENT || tok.Type == token.ILLEGAL {
+				lits = append(lits, fmt.Sprintf("%s %s %q", tok.Pos, tok.Type, tok.Literal))
+			}
+		}
+		want := []string{`5:1 ILLEGAL "/* unterminated"`}
+		if tc.comments {
+			want = []string{
+				`3:31 COMMENT "// trailing"`,
+				`4:1 COMMENT "/* block /* nested */ still comment */"`,
+				`5:1 ILLEGAL "/* unterminated"`,
+			}
+		}
+		if strings.Join(lits, "\n") != strings.Join(want, "\n") {
+			t.Errorf("comments %t got %q, want %q", tc.comments, lits, want)
+		}
+		if n := len(got) - len(lits); n != 19 {
+			t.Errorf("comments %t got %d other tokens, want 19", tc.comments, n)
+		}
+	}
+}
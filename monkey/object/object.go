@@ -8,15 +8,25 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
 	"hash/fnv"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/emb/play/monkey/ast"
 )
 
 // Type describes the type of object being manipulated.
+//
 //go:generate stringer -type=Type
 type Type int
 
@@ -31,6 +41,14 @@ const (
 	Return
 	Function
 	Builtin
+	Channel
+	Task
+	Module
+	Float
+	Iterator
+	Regexp
+	Time
+	Duration
 )
 
 // Object is an internal representation of values in the monkey
@@ -46,12 +64,153 @@ type Hashable interface {
 	HashKey() HashKey
 }
 
-// HashKey describes a key for the Hash type
+// HashKey describes a key for the Hash type. Different keys may share
+// the same HashKey, a hash resolves such collisions by comparing the
+// keys with Equal.
 type HashKey struct {
 	Type  Type
 	Value uint64
 }
 
+// Unhashable is an error describing an object that can not be used
+// as a key to a hash.
+type Unhashable struct {
+	Type Type
+}
+
+// Error returns a string describing the error
+func (e Unhashable) Error() string {
+	return fmt.Sprintf("unhashable type %s can not be used as a hash key", e.Type)
+}
+
+// Caller applies a function to arguments for the program using a
+// value, so that hashes dispatch to their protocols with the state of
+// that program. The evaluator provides it, a nil Caller dispatches to
+// no protocol.
+type Caller func(fn Object, args []Object) (Object, error)
+
+// KeyOf returns the HashKey of an object. Arrays and hashes are hashed
+// structurally from their contents, a hash is hashed regardless of the
+// order of its pairs unless it has a __hash__ protocol, then it is
+// hashed by the value __hash__ returns when applied with call. An
+// Unhashable error is returned for objects, or objects containing
+// values, that can not be hashed such as functions.
+func KeyOf(call Caller, o Object) (HashKey, error) {
+	return keyOf(call, o, true)
+}
+
+// errHashProtocol is returned for a value returned by __hash__ holding
+// a hash with a __hash__ protocol, such as the hash itself, whose
+// hashing may never end.
+var errHashProtocol = errors.New("__hash__ returned a value holding a hash with a __hash__ protocol")
+
+// keyOf returns the HashKey of o, hashes with a __hash__ protocol are
+// an error unless protocols is set.
+func keyOf(call Caller, o Object, protocols bool) (HashKey, error) {
+	switch o := o.(type) {
+	case Hashable:
+		return o.HashKey(), nil
+	case Arr:
+		h := fnv.New64a()
+		for _, e := range o {
+			k, err := keyOf(call, e, protocols)
+			if err != nil {
+				return HashKey{}, err
+			}
+			writeKey(h, k)
+		}
+		return HashKey{Type: Array, Value: h.Sum64()}, nil
+	case *HashMap:
+		if fn := o.Protocol("__hash__"); fn != nil && call != nil {
+			if !protocols {
+				return HashKey{}, errHashProtocol
+			}
+			v, err := call(fn, []Object{o})
+			if err != nil {
+				return HashKey{}, err
+			}
+			k, err := keyOf(call, v, false)
+			if err != nil {
+				return HashKey{}, err
+			}
+			return HashKey{Type: Hash, Value: k.Value}, nil
+		}
+		var sum uint64
+		for _, p := range o.pairs {
+			k, err := keyOf(call, p.Key, protocols)
+			if err != nil {
+				return HashKey{}, err
+			}
+			v, err := keyOf(call, p.Value, protocols)
+			if err != nil {
+				return HashKey{}, err
+			}
+			h := fnv.New64a()
+			writeKey(h, k)
+			writeKey(h, v)
+			// Summing the pairs makes the result independent
+			// of insertion order.
+			sum += h.Sum64()
+		}
+		return HashKey{Type: Hash, Value: sum}, nil
+	default:
+		return HashKey{}, Unhashable{Type: o.Type()}
+	}
+}
+
+func writeKey(h hash.Hash64, k HashKey) {
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[:8], uint64(k.Type))
+	binary.LittleEndian.PutUint64(buf[8:], k.Value)
+	h.Write(buf[:])
+}
+
+// Shared instances of values that carry no identity. Evaluating
+// monkey code should use these rather than allocating new values.
+var (
+	Nil   = &Nul{}
+	True  = newBool(true)
+	False = newBool(false)
+)
+
+func newBool(b bool) *Bool {
+	r := Bool(b)
+	return &r
+}
+
+// NewBool returns the shared instance of a boolean value.
+func NewBool(b bool) *Bool {
+	if b {
+		return True
+	}
+	return False
+}
+
+// Integers in the range [minCachedInt, maxCachedInt) are preallocated
+// since they are commonly produced by loops, counters and indexes.
+const (
+	minCachedInt = -128
+	maxCachedInt = 1024
+)
+
+var cachedInts = func() []Int {
+	ints := make([]Int, maxCachedInt-minCachedInt)
+	for i := range ints {
+		ints[i] = Int(i + minCachedInt)
+	}
+	return ints
+}()
+
+// NewInt returns an Int holding i. Small integers are shared and must
+// not be modified.
+func NewInt(i int64) *Int {
+	if minCachedInt <= i && i < maxCachedInt {
+		return &cachedInts[i-minCachedInt]
+	}
+	r := Int(i)
+	return &r
+}
+
 // Int represents an integer value within monkey
 type Int int64
 
@@ -66,6 +225,62 @@ func (i Int) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i)}
 }
 
+// BigInt represents an integer that does not fit in an Int. Integer
+// arithmetic promotes its results to BigInts when they overflow and
+// demotes them back to Ints when they fit, so each integer has a
+// single representation. The value must not be modified.
+type BigInt struct {
+	Value *big.Int
+}
+
+// NewBigInt returns an integer holding i, an Int when it fits.
+func NewBigInt(i *big.Int) Object {
+	if i.IsInt64() {
+		return NewInt(i.Int64())
+	}
+	return &BigInt{Value: i}
+}
+
+// Type returns the object type
+func (b *BigInt) Type() Type { return Integer }
+
+// Inspect provides a string representation of a BigInt value.
+func (b *BigInt) Inspect() string { return b.Value.String() }
+
+// HashKey returns a HashKey useful when constructing Hashes
+func (b *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte{byte(b.Value.Sign() + 1)})
+	h.Write(b.Value.Bytes())
+	return HashKey{Type: Integer, Value: h.Sum64()}
+}
+
+// Flt represents a floating point value within monkey
+type Flt float64
+
+// Type returns the object type
+func (f *Flt) Type() Type { return Float }
+
+// Inspect provides a string representation of a Flt value.
+func (f *Flt) Inspect() string {
+	return strconv.FormatFloat(float64(*f), 'g', -1, 64)
+}
+
+// HashKey returns a HashKey useful when constructing Hashes. Floats
+// are equal to the integers of the same value, so an integral float
+// hashes like its integer and -0 like 0.
+func (f Flt) HashKey() HashKey {
+	v := float64(f)
+	if v != math.Trunc(v) || math.IsInf(v, 0) {
+		return HashKey{Type: f.Type(), Value: math.Float64bits(v)}
+	}
+	if -(1<<63) <= v && v < 1<<63 {
+		return Int(v).HashKey()
+	}
+	i, _ := big.NewFloat(v).Int(nil)
+	return (&BigInt{Value: i}).HashKey()
+}
+
 // Str represents a string value within monkey
 type Str string
 
@@ -126,25 +341,107 @@ type HashPair struct {
 	Value Object
 }
 
-// HashMap describes a map within monkey language
+// HashMap describes a map within monkey language. Pairs are kept in
+// the order their keys were first inserted, so iterating a hash or
+// inspecting it is deterministic.
 type HashMap struct {
-	Pairs map[HashKey]HashPair
+	buckets map[HashKey][]int // indexes into pairs
+	pairs   []HashPair        // insertion order
+}
+
+// NewHashMap creates an empty hash.
+func NewHashMap() *HashMap {
+	return &HashMap{buckets: make(map[HashKey][]int)}
 }
 
 // Type returns the object type
 func (h *HashMap) Type() Type { return Hash }
 
-// Inspect returns a string representation of a hash
+// Len returns the number of pairs in the hash.
+func (h *HashMap) Len() int { return len(h.pairs) }
+
+// Get returns the pair stored under key k, which is hashed and
+// compared with call. An error is returned if k is not hashable.
+func (h *HashMap) Get(call Caller, k Object) (HashPair, bool, error) {
+	hk, err := KeyOf(call, k)
+	if err != nil {
+		return HashPair{}, false, err
+	}
+	i, ok := h.find(call, hk, k)
+	if !ok {
+		return HashPair{}, false, nil
+	}
+	return h.pairs[i], true, nil
+}
+
+// Set binds value v to key k, which is hashed and compared with call.
+// Rebinding an existing key keeps its original position. An error is
+// returned if k is not hashable.
+func (h *HashMap) Set(call Caller, k, v Object) error {
+	hk, err := KeyOf(call, k)
+	if err != nil {
+		return err
+	}
+	h.set(call, hk, k, v)
+	return nil
+}
+
+func (h *HashMap) set(call Caller, hk HashKey, k, v Object) {
+	if i, ok := h.find(call, hk, k); ok {
+		h.pairs[i].Value = v
+		return
+	}
+	h.buckets[hk] = append(h.buckets[hk], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: k, Value: v})
+}
+
+// find returns the index of the pair with key k in the bucket hk.
+func (h *HashMap) find(call Caller, hk HashKey, k Object) (int, bool) {
+	for _, i := range h.buckets[hk] {
+		if Equal(call, h.pairs[i].Key, k) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Pairs returns the pairs of the hash in insertion order.
+func (h *HashMap) Pairs() []HashPair {
+	pairs := make([]HashPair, len(h.pairs))
+	copy(pairs, h.pairs)
+	return pairs
+}
+
+// Protocol returns the function a hash binds to the name of a protocol
+// such as "__add__", or nil if it binds none. Protocols let hashes
+// define how operators, builtins and inspecting apply to them.
+func (h *HashMap) Protocol(name string) Object {
+	if h == nil {
+		return nil
+	}
+	k := Str(name)
+	p, ok, _ := h.Get(nil, &k)
+	if !ok {
+		return nil
+	}
+	switch p.Value.(type) {
+	case *Funct, *BuiltinFunct:
+		return p.Value
+	}
+	return nil
+}
+
+// Inspect returns a string representation of the pairs of a hash. The
+// evaluator inspects hashes with a __str__ protocol by applying it.
 func (h *HashMap) Inspect() string {
 	if h == nil {
 		return ""
 	}
 	var buf bytes.Buffer
-	pairs := []string{}
-	for _, p := range h.Pairs {
-		pstr := fmt.Sprintf("%s: %s",
+	pairs := make([]string, len(h.pairs))
+	for i, p := range h.pairs {
+		pairs[i] = fmt.Sprintf("%s: %s",
 			p.Key.Inspect(), p.Value.Inspect())
-		pairs = append(pairs, pstr)
 	}
 	buf.WriteByte('{')
 	buf.WriteString(strings.Join(pairs, ", "))
@@ -152,6 +449,96 @@ func (h *HashMap) Inspect() string {
 	return buf.String()
 }
 
+// Equal reports whether a and b hold the same value. An integer and a
+// float are compared exactly, as their hash keys are. Arrays are equal when their elements
+// are equal in order, hashes when they hold the same keys bound to
+// equal values regardless of insertion order, or when the __eq__
+// protocol of a, else of b, returns true when applied with call.
+// Functions are only equal to themselves.
+func Equal(call Caller, a, b Object) bool {
+	if a.Type() == Float || b.Type() == Float {
+		x, xok := toBigFloat(a)
+		y, yok := toBigFloat(b)
+		return xok && yok && x.Cmp(y) == 0
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a := a.(type) {
+	case *Int:
+		// Integers that fit in an Int are never BigInts.
+		b, ok := b.(*Int)
+		return ok && *a == *b
+	case *BigInt:
+		b, ok := b.(*BigInt)
+		return ok && a.Value.Cmp(b.Value) == 0
+	case *Str:
+		return *a == *b.(*Str)
+	case *Bool:
+		return *a == *b.(*Bool)
+	case *Nul:
+		return true
+	case *Tm:
+		// Times are equal when they are the same instant, even in
+		// different locations.
+		return a.Value.Equal(b.(*Tm).Value)
+	case *Dur:
+		return *a == *b.(*Dur)
+	case Arr:
+		b := b.(Arr)
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !Equal(call, a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case *HashMap:
+		b := b.(*HashMap)
+		fn := a.Protocol("__eq__")
+		if fn == nil {
+			fn = b.Protocol("__eq__")
+		}
+		if fn != nil && call != nil {
+			v, err := call(fn, []Object{a, b})
+			return err == nil && v == True
+		}
+		if a.Len() != b.Len() {
+			return false
+		}
+		for _, p := range a.pairs {
+			// Keys stored in a hash are always hashable.
+			hk, _ := KeyOf(call, p.Key)
+			i, ok := b.find(call, hk, p.Key)
+			if !ok || !Equal(call, p.Value, b.pairs[i].Value) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// toBigFloat converts a number to a big.Float without rounding,
+// reporting false for other objects and NaN.
+func toBigFloat(o Object) (*big.Float, bool) {
+	switch o := o.(type) {
+	case *Int:
+		return new(big.Float).SetInt64(int64(*o)), true
+	case *BigInt:
+		return new(big.Float).SetInt(o.Value), true
+	case *Flt:
+		if math.IsNaN(float64(*o)) {
+			return nil, false
+		}
+		return big.NewFloat(float64(*o)), true
+	}
+	return nil, false
+}
+
 // Nul represents an absence of a value
 type Nul struct{}
 
@@ -174,22 +561,35 @@ func (r *Ret) Inspect() string {
 	return fmt.Sprintf("return(%s)", r.Value)
 }
 
-// NewEnvironment creates an environment used while evaluating Monkey
-// program.
+// NewEnvironment creates the global environment used while evaluating
+// a Monkey program. Globals are bound by name.
 func NewEnvironment() *Environment {
 	return &Environment{store: make(map[string]Object)}
 }
 
-// Environment is where let statement binds values to identifiers
-type Environment struct {
-	store map[string]Object
-	outer *Environment
+// NewEnclosedEnvironment creates an environment for a function call
+// with size slots, enclosed by the environment outer. Locals are
+// bound by the slot the parser resolved for them. The environment
+// inherits the call state of outer.
+func NewEnclosedEnvironment(outer *Environment, size int) *Environment {
+	return &Environment{slots: make([]Object, size), outer: outer, call: outer.CallState()}
 }
 
-// Extend extends the current environment with an outer scoped environment.
-func (e *Environment) Extend(o *Environment) *Environment {
-	e.outer = o
-	return e
+// Environment is where let statement binds values to identifiers. An
+// environment is safe for concurrent use by spawned tasks.
+type Environment struct {
+	mu    sync.RWMutex
+	store map[string]Object
+	// consts holds the globals bound by const statements.
+	consts map[string]bool
+	slots  []Object
+	outer  *Environment
+	// runtime is the state of the evaluator, kept by the global
+	// environment.
+	runtime interface{}
+	// call is the state of the evaluator for the call running in
+	// the environment.
+	call interface{}
 }
 
 // Get returns an object bound to an identifier i in an environment
@@ -197,7 +597,9 @@ func (e *Environment) Get(i string) (Object, bool) {
 	if e == nil {
 		return nil, false
 	}
+	e.mu.RLock()
 	v, ok := e.store[i]
+	e.mu.RUnlock()
 	if !ok {
 		return e.outer.Get(i)
 	}
@@ -206,14 +608,129 @@ func (e *Environment) Get(i string) (Object, bool) {
 
 // Set stores a value v bound to identifier i in an environment
 func (e *Environment) Set(i string, v Object) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
 	e.store[i] = v
 }
 
+// Names returns the sorted identifiers bound by name in e.
+func (e *Environment) Names() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Outer returns the environment enclosing e, it is nil for a global
+// environment.
+func (e *Environment) Outer() *Environment { return e.outer }
+
+// Runtime returns the runtime stored by SetRuntime in the global
+// environment enclosing e, nil if none was.
+func (e *Environment) Runtime() interface{} {
+	if e == nil {
+		return nil
+	}
+	for e.outer != nil {
+		e = e.outer
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.runtime
+}
+
+// SetRuntime stores the state of the evaluator rt in e, for the
+// programs evaluated in e and the functions they define. The
+// evaluator documents what rt holds.
+func (e *Environment) SetRuntime(rt interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.runtime = rt
+}
+
+// CallState returns the state stored by SetCallState in e, or
+// inherited by e from the environment enclosing it.
+func (e *Environment) CallState() interface{} {
+	if e == nil {
+		return nil
+	}
+	return e.call
+}
+
+// SetCallState stores the state of the evaluator for the call running
+// in e, such as state passed along from the caller, before e is used.
+// The evaluator documents what s holds.
+func (e *Environment) SetCallState(s interface{}) { e.call = s }
+
+// Slots returns a copy of the slots of e.
+func (e *Environment) Slots() []Object {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	slots := make([]Object, len(e.slots))
+	copy(slots, e.slots)
+	return slots
+}
+
+// Constant reports whether the identifier i is bound in e by a const
+// statement.
+func (e *Environment) Constant(i string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.consts[i]
+}
+
+// SetConstant marks the identifier i bound in e as a constant.
+func (e *Environment) SetConstant(i string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.consts == nil {
+		e.consts = make(map[string]bool)
+	}
+	e.consts[i] = true
+}
+
+// Slot returns the object stored in slot i of the environment depth
+// levels out from e. Nil is returned for a slot that is not bound
+// yet.
+func (e *Environment) Slot(depth, i int) Object {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.slots[i]
+}
+
+// SetSlot stores a value v in slot i of the environment depth levels
+// out from e.
+func (e *Environment) SetSlot(depth, i int, v Object) {
+	for ; depth > 0; depth-- {
+		e = e.outer
+	}
+	e.mu.Lock()
+	e.slots[i] = v
+	e.mu.Unlock()
+}
+
 // Funct is an object that describes a function that can be evaluated.
 type Funct struct {
 	Env        *Environment
 	Parameters []*ast.Identifier
+	Defaults   []ast.Expression
+	Rest       *ast.Identifier
 	Body       *ast.BlockStmt
+	// Locals is the number of slots needed by a call environment.
+	Locals int
+	// Generator is set for functions containing a yield, calling
+	// them returns an iterator over the values they yield.
+	Generator bool
 }
 
 // Type returns the object type
@@ -222,10 +739,7 @@ func (*Funct) Type() Type { return Function }
 // Inspect provides a string representation of a function
 func (f *Funct) Inspect() string {
 	var buf bytes.Buffer
-	params := make([]string, len(f.Parameters))
-	for i, p := range f.Parameters {
-		params[i] = p.String()
-	}
+	params := ast.ParamStrings(f.Parameters, f.Defaults, f.Rest)
 	buf.WriteString("fn (")
 	buf.WriteString(strings.Join(params, ", "))
 	buf.WriteByte(')')
@@ -233,9 +747,16 @@ func (f *Funct) Inspect() string {
 	return buf.String()
 }
 
-// BuiltinFunct describes a builtin function within Monkey
+// BuiltinFunct describes a builtin function within Monkey. Fn is
+// called with the environment of the call, builtins find the runtime
+// of the program there.
 type BuiltinFunct struct {
-	Fn func(args ...Object) (Object, error)
+	Fn func(env *Environment, args ...Object) (Object, error)
+	// Params and Doc document the function, Params is written
+	// like the parameters of a function literal such as
+	// "arr, value".
+	Params string
+	Doc    string
 }
 
 // Type return object type
@@ -243,3 +764,103 @@ func (*BuiltinFunct) Type() Type { return Builtin }
 
 // Inspect provides a string representation of the builtin
 func (*BuiltinFunct) Inspect() string { return "builtin function" }
+
+// Chan is a channel used to communicate between tasks.
+type Chan struct {
+	C chan Object
+}
+
+// Type returns the object type
+func (*Chan) Type() Type { return Channel }
+
+// Inspect provides a string representation of a channel
+func (c *Chan) Inspect() string { return fmt.Sprintf("chan(%d)", cap(c.C)) }
+
+// TaskHandle describes a function running concurrently. Done is closed
+// once the function returns, after which Result or Err hold its
+// outcome.
+type TaskHandle struct {
+	Done   chan struct{}
+	Result Object
+	Err    error
+}
+
+// Type returns the object type
+func (*TaskHandle) Type() Type { return Task }
+
+// Inspect provides a string representation of a task
+func (*TaskHandle) Inspect() string { return "task" }
+
+// Mod is a named collection of builtin values such as the os module.
+type Mod struct {
+	Name    string
+	Members map[string]Object
+	// Docs documents the members that are not functions, such as
+	// the constants of the time module, by name.
+	Docs map[string]string
+}
+
+// Type returns the object type
+func (*Mod) Type() Type { return Module }
+
+// Inspect provides a string representation of a module
+func (m *Mod) Inspect() string { return fmt.Sprintf("module %s", m.Name) }
+
+// Iter is a lazy sequence of values such as a range or the values
+// yielded by a generator. Next returns the next value, or false once
+// the sequence is exhausted.
+type Iter struct {
+	Next func() (Object, bool, error)
+}
+
+// Type returns the object type
+func (*Iter) Type() Type { return Iterator }
+
+// Inspect provides a string representation of an iterator
+func (*Iter) Inspect() string { return "iterator" }
+
+// Re is a compiled regular expression.
+type Re struct {
+	Value *regexp.Regexp
+}
+
+// Type returns the object type
+func (*Re) Type() Type { return Regexp }
+
+// Inspect provides a string representation of a regular expression
+func (r *Re) Inspect() string { return fmt.Sprintf("re.compile(%q)", r.Value.String()) }
+
+// Tm represents an instant in time along with the location it is
+// presented in.
+type Tm struct {
+	Value time.Time
+}
+
+// Type returns the object type
+func (*Tm) Type() Type { return Time }
+
+// Inspect provides a string representation of a time in RFC 3339
+// format.
+func (t *Tm) Inspect() string { return t.Value.Format(time.RFC3339Nano) }
+
+// HashKey returns a HashKey useful when constructing Hashes, the same
+// instant in different locations has the same key.
+func (t *Tm) HashKey() HashKey {
+	return HashKey{Type: Time, Value: uint64(t.Value.Unix())*1e9 + uint64(t.Value.Nanosecond())}
+}
+
+// Dur represents the time elapsed between two instants, in
+// nanoseconds.
+type Dur time.Duration
+
+// Type returns the object type
+func (*Dur) Type() Type { return Duration }
+
+// Inspect provides a string representation of a duration such as
+// 1h30m0s.
+func (d *Dur) Inspect() string { return time.Duration(*d).String() }
+
+// HashKey returns a HashKey useful when constructing Hashes
+func (d Dur) HashKey() HashKey {
+	return HashKey{Type: Duration, Value: uint64(d)}
+}
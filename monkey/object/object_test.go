@@ -1,6 +1,8 @@
 package object
 
 import (
+	"math"
+	"math/big"
 	"strconv"
 	"testing"
 )
@@ -17,10 +19,189 @@ func TestStringHashKey(t *testing.T) {
 	for i, tc := range tests {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
 			if (tc.first.HashKey() == tc.second.HashKey()) != tc.want {
-				t.Errorf("first.HashKey() %s, second.HashKey() %s. Want equality to be %t",
+				t.Errorf("first.HashKey() %v, second.HashKey() %v. Want equality to be %t",
 					tc.first.HashKey(), tc.second.HashKey(),
 					tc.want)
 			}
 		})
 	}
 }
+
+func TestHashMapInspect(t *testing.T) {
+	h := NewHashMap()
+	for i, k := range []string{"b", "c", "a"} {
+		key, value := Str(k), Int(i)
+		h.Set(nil, &key, &value)
+	}
+	want := `{"b": 0, "c": 1, "a": 2}`
+	for i := 0; i < 10; i++ {
+		if got := h.Inspect(); got != want {
+			t.Fatalf("h.Inspect() is %s, want %s", got, want)
+		}
+	}
+}
+
+func TestHashMapCollisions(t *testing.T) {
+	// Force distinct keys into the same bucket.
+	collide := HashKey{Type: String, Value: 42}
+	h := NewHashMap()
+	a, b := Str("a"), Str("b")
+	one, two := Int(1), Int(2)
+	h.set(nil, collide, &a, &one)
+	h.set(nil, collide, &b, &two)
+	if h.Len() != 2 {
+		t.Fatalf("h.Len() is %d, want 2", h.Len())
+	}
+	for _, tc := range []struct {
+		key  Object
+		want Object
+	}{{&a, &one}, {&b, &two}} {
+		i, ok := h.find(nil, collide, tc.key)
+		if !ok {
+			t.Fatalf("key %s not found", tc.key.Inspect())
+		}
+		if got := h.pairs[i].Value; got != tc.want {
+			t.Errorf("value of %s is %s, want %s",
+				tc.key.Inspect(), got.Inspect(), tc.want.Inspect())
+		}
+	}
+	three := Int(3)
+	h.set(nil, collide, &a, &three)
+	if h.Len() != 2 {
+		t.Errorf("h.Len() after rebinding is %d, want 2", h.Len())
+	}
+	if want := `{"a": 3, "b": 2}`; h.Inspect() != want {
+		t.Errorf("h.Inspect() is %s, want %s", h.Inspect(), want)
+	}
+}
+
+func TestKeyOf(t *testing.T) {
+	str := func(s string) Object {
+		r := Str(s)
+		return &r
+	}
+	num := func(i int64) Object {
+		r := Int(i)
+		return &r
+	}
+	flt := func(f float64) Object {
+		r := Flt(f)
+		return &r
+	}
+	hash := func(kvs ...Object) Object {
+		h := NewHashMap()
+		for i := 0; i < len(kvs); i += 2 {
+			h.Set(nil, kvs[i], kvs[i+1])
+		}
+		return h
+	}
+	tests := []struct {
+		first  Object
+		second Object
+		want   bool
+	}{
+		{Arr{num(1), str("a")}, Arr{num(1), str("a")}, true},
+		{Arr{num(1), str("a")}, Arr{str("a"), num(1)}, false},
+		{Arr{}, Arr{Arr{}}, false},
+		{
+			hash(str("a"), num(1), str("b"), num(2)),
+			hash(str("b"), num(2), str("a"), num(1)),
+			true,
+		},
+		{hash(str("a"), num(1)), hash(str("a"), num(2)), false},
+		{Arr{}, NewHashMap(), false},
+		{flt(1), num(1), true},
+		{flt(math.Copysign(0, -1)), flt(0), true},
+		{flt(1.5), num(1), false},
+		{Arr{flt(2)}, Arr{num(2)}, true},
+		{flt(1 << 70), NewBigInt(new(big.Int).Lsh(big.NewInt(1), 70)), true},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			first, err := KeyOf(nil, tc.first)
+			if err != nil {
+				t.Fatalf("KeyOf(%s) failed: %s", tc.first.Inspect(), err)
+			}
+			second, err := KeyOf(nil, tc.second)
+			if err != nil {
+				t.Fatalf("KeyOf(%s) failed: %s", tc.second.Inspect(), err)
+			}
+			if (first == second) != tc.want {
+				t.Errorf("KeyOf(%s) %v, KeyOf(%s) %v. Want equality to be %t",
+					tc.first.Inspect(), first,
+					tc.second.Inspect(), second, tc.want)
+			}
+		})
+	}
+	if _, err := KeyOf(nil, Arr{&Funct{}}); err != (Unhashable{Type: Function}) {
+		t.Errorf("KeyOf([fn]) error is %v, want %v", err, Unhashable{Type: Function})
+	}
+}
+
+func TestEqualNumbers(t *testing.T) {
+	flt := func(f float64) Object {
+		r := Flt(f)
+		return &r
+	}
+	pow := new(big.Int).Lsh(big.NewInt(1), 63)
+	tests := []struct {
+		a, b Object
+		want bool
+	}{
+		{flt(1), NewInt(1), true},
+		{flt(1.5), NewInt(1), false},
+		{flt(math.Copysign(0, -1)), NewInt(0), true},
+		{flt(1 << 63), NewBigInt(pow), true},
+		// 2^63+1 rounds to 2^63 as a float.
+		{flt(1 << 63), NewBigInt(new(big.Int).Add(pow, big.NewInt(1))), false},
+		{flt(math.Inf(1)), NewBigInt(pow), false},
+		{flt(math.NaN()), flt(math.NaN()), false},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if Equal(nil, tc.a, tc.b) != tc.want || Equal(nil, tc.b, tc.a) != tc.want {
+				t.Errorf("Equal(%s, %s) is %t, want %t", tc.a.Inspect(), tc.b.Inspect(), !tc.want, tc.want)
+			}
+			a, _ := KeyOf(nil, tc.a)
+			b, _ := KeyOf(nil, tc.b)
+			if tc.want && a != b {
+				t.Errorf("KeyOf(%s) is %v and KeyOf(%s) %v, want equal keys", tc.a.Inspect(), a, tc.b.Inspect(), b)
+			}
+		})
+	}
+}
+
+func TestNewInt(t *testing.T) {
+	for _, i := range []int64{minCachedInt - 1, minCachedInt, -1, 0, 1, maxCachedInt - 1, maxCachedInt, 1 << 40} {
+		if got := NewInt(i); int64(*got) != i {
+			t.Errorf("NewInt(%d) is %d", i, *got)
+		}
+	}
+	if NewInt(7) != NewInt(7) {
+		t.Errorf("NewInt(7) is not shared")
+	}
+	if NewInt(maxCachedInt) == NewInt(maxCachedInt) {
+		t.Errorf("NewInt(%d) is shared, want a new value", maxCachedInt)
+	}
+}
+
+func TestNewBigInt(t *testing.T) {
+	huge, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	if got, ok := NewBigInt(big.NewInt(-5)).(*Int); !ok || *got != -5 {
+		t.Errorf("NewBigInt(-5) is %#v, want an Int", NewBigInt(big.NewInt(-5)))
+	}
+	a, ok := NewBigInt(huge).(*BigInt)
+	if !ok || a.Inspect() != "123456789012345678901234567890" {
+		t.Fatalf("NewBigInt(%s) is %#v, want a BigInt", huge, NewBigInt(huge))
+	}
+	b := &BigInt{Value: new(big.Int).Set(huge)}
+	neg := &BigInt{Value: new(big.Int).Neg(huge)}
+	if !Equal(nil, a, b) || Equal(nil, a, neg) || Equal(nil, a, NewInt(5)) || Equal(nil, NewInt(5), a) {
+		t.Errorf("Equal(%s, %s) is %t and Equal(%s, %s) %t",
+			a.Inspect(), b.Inspect(), Equal(nil, a, b), a.Inspect(), neg.Inspect(), Equal(nil, a, neg))
+	}
+	if a.HashKey() != b.HashKey() || a.HashKey() == neg.HashKey() {
+		t.Errorf("HashKey() of %s is %v, %v for an equal value and %v for its negation",
+			a.Inspect(), a.HashKey(), b.HashKey(), neg.HashKey())
+	}
+}
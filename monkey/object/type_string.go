@@ -4,9 +4,9 @@ package object
 
 import "fmt"
 
-const _Type_name = "IntegerStringBooleanArrayHashNullReturnFunctionBuiltin"
+const _Type_name = "IntegerStringBooleanArrayHashNullReturnFunctionBuiltinChannelTaskModuleFloatIteratorRegexpTimeDuration"
 
-var _Type_index = [...]uint8{0, 7, 13, 20, 25, 29, 33, 39, 47, 54}
+var _Type_index = [...]uint8{0, 7, 13, 20, 25, 29, 33, 39, 47, 54, 61, 65, 71, 76, 84, 90, 94, 102}
 
 func (i Type) String() string {
 	if i < 0 || i >= Type(len(_Type_index)-1) {
@@ -0,0 +1,438 @@
+// Package optimize rewrites Monkey programs into equivalent programs
+// doing less work when evaluated.
+//
+// Operators applied to literals are folded into their result, if
+// expressions whose condition is a literal are replaced by the branch
+// taken and calls to small functions bound by top level let
+// statements are replaced by the body of the function. Folding is
+// done by the evaluator, so a folded program gives the same values and
+// errors: an operation failing such as adding a string to an integer
+// is left in place to fail when the program runs.
+package optimize
+
+import (
+	"math/big"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/evaluator"
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/parser"
+	"github.com/emb/play/monkey/token"
+)
+
+// maxInline is the number of nodes of the largest function body that
+// is inlined.
+const maxInline = 24
+
+// Program optimizes a resolved program in place. The rewritten tree
+// is resolved again, the errors returned are those of resolving it.
+func Program(program *ast.Program) []error {
+	o := &optimizer{
+		funcs:    map[string]*ast.FunctionLiteral{},
+		declared: map[string]bool{},
+		params:   map[*ast.Identifier]bool{},
+	}
+	ast.Walk(scanner{o: o}, program)
+	for i, s := range program.Statements {
+		if m, ok := ast.Modify(s, o.rewrite).(ast.Statement); ok {
+			program.Statements[i] = m
+		}
+		// Statements following the let run once the function is
+		// bound, calls within them are safe to inline.
+		let, ok := program.Statements[i].(*ast.LetStmt)
+		if !ok || let.Name == nil {
+			continue
+		}
+		if fn, ok := let.Value.(*ast.FunctionLiteral); ok && o.inlinable(let.Name.Value, fn) {
+			o.funcs[let.Name.Value] = fn
+		}
+	}
+	return parser.Resolve(program)
+}
+
+type optimizer struct {
+	// funcs holds the inlinable functions bound by the top level let
+	// statements rewritten so far.
+	funcs map[string]*ast.FunctionLiteral
+	// declared holds the names bound within functions and blocks,
+	// which would capture a global of the same name used by an
+	// inlined function.
+	declared map[string]bool
+	// params holds the identifiers referring to a parameter of the
+	// function they are used in, which are bound whenever they are
+	// evaluated.
+	params map[*ast.Identifier]bool
+}
+
+// scanner collects the names bound within functions and blocks and
+// the uses of parameters within fn.
+type scanner struct {
+	o  *optimizer
+	fn *ast.FunctionLiteral
+}
+
+func (s scanner) Visit(node ast.Node) ast.Visitor {
+	switch n := node.(type) {
+	case *ast.Identifier:
+		if !n.Local {
+			break
+		}
+		s.o.declared[n.Value] = true
+		if s.fn != nil && n.Depth == 0 && n.Slot < nparams(s.fn) {
+			s.o.params[n] = true
+		}
+	case *ast.FunctionLiteral:
+		// Defaults are evaluated before the parameters following
+		// them are bound.
+		outer := scanner{o: s.o}
+		for _, p := range n.Parameters {
+			ast.Walk(outer, p)
+		}
+		for _, d := range n.Defaults {
+			if d != nil {
+				ast.Walk(outer, d)
+			}
+		}
+		if n.Rest != nil {
+			ast.Walk(outer, n.Rest)
+		}
+		if n.Body != nil {
+			ast.Walk(scanner{o: s.o, fn: n}, n.Body)
+		}
+		return nil
+	}
+	return s
+}
+
+func nparams(fn *ast.FunctionLiteral) int {
+	if fn.Rest != nil {
+		return len(fn.Parameters) + 1
+	}
+	return len(fn.Parameters)
+}
+
+// rewrite is the ast.ModifierFunc applied to the program, the children
+// of a node are rewritten before it.
+func (o *optimizer) rewrite(node ast.Node) ast.Node {
+	switch n := node.(type) {
+	case *ast.PrefixExpr:
+		// Negative integers are already as small as they get.
+		if _, ok := n.Right.(*ast.IntegerLiteral); ok && n.Operator == token.MINUS {
+			return n
+		}
+		if constant(n.Right) {
+			return fold(n, n.Token.Pos)
+		}
+	case *ast.InfixExpr:
+		if constant(n.Left) && constant(n.Right) {
+			return fold(n, n.Token.Pos)
+		}
+	case *ast.IfExpr:
+		return prune(n)
+	case *ast.CallExpr:
+		if e := o.inline(n); e != nil {
+			return ast.Modify(e, o.rewrite)
+		}
+	}
+	return node
+}
+
+// constant reports whether e is a literal integer, string or boolean.
+func constant(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return true
+	case *ast.PrefixExpr:
+		_, ok := e.Right.(*ast.IntegerLiteral)
+		return ok && e.Operator == token.MINUS
+	}
+	return false
+}
+
+// fold evaluates an operator applied to constants, returning the
+// literal of its value at pos. The expression is returned unchanged
+// when it fails or its value has no literal.
+func fold(e ast.Expression, pos token.Position) ast.Expression {
+	v, err := evaluator.Eval(e, object.NewEnvironment())
+	if err != nil {
+		return e
+	}
+	switch v := v.(type) {
+	case *object.Int:
+		return integer(big.NewInt(int64(*v)), pos)
+	case *object.BigInt:
+		return integer(new(big.Int).Set(v.Value), pos)
+	case *object.Str:
+		s := string(*v)
+		return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: s, Pos: pos}, Value: s}
+	case *object.Bool:
+		if *v {
+			return &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true", Pos: pos}, Value: true}
+		}
+		return &ast.Boolean{Token: token.Token{Type: token.FALSE, Literal: "false", Pos: pos}, Value: false}
+	}
+	return e
+}
+
+// integer returns the literal of i, negative integers are negated
+// literals as the parser makes them.
+func integer(i *big.Int, pos token.Position) ast.Expression {
+	if i.Sign() < 0 {
+		return &ast.PrefixExpr{
+			Token:    token.Token{Type: token.MINUS, Literal: "-", Pos: pos},
+			Operator: token.MINUS,
+			Right:    integer(i.Neg(i), pos),
+		}
+	}
+	lit := &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: i.String(), Pos: pos}}
+	if i.IsInt64() {
+		lit.Value = i.Int64()
+	} else {
+		lit.Big = i
+	}
+	return lit
+}
+
+// prune replaces an if expression whose condition is constant by the
+// branch taken. A branch is only lifted out of its block when it is a
+// single expression, otherwise the block is kept so its bindings stay
+// within it.
+func prune(n *ast.IfExpr) ast.Expression {
+	if !constant(n.Condition) {
+		return n
+	}
+	if b, ok := n.Condition.(*ast.Boolean); !ok || b.Value {
+		if e := single(n.Consequence); e != nil {
+			return e
+		}
+		n.Alternative = nil
+		return n
+	}
+	if n.Alternative == nil {
+		// The value of the expression is null.
+		n.Consequence = &ast.BlockStmt{Token: n.Consequence.Token}
+		return n
+	}
+	if e := single(n.Alternative); e != nil {
+		return e
+	}
+	n.Condition = &ast.Boolean{Token: token.Token{Type: token.TRUE, Literal: "true", Pos: n.Condition.(*ast.Boolean).Token.Pos}, Value: true}
+	n.Consequence, n.Alternative = n.Alternative, nil
+	return n
+}
+
+// single returns the expression of a block made of a single
+// expression statement, or nil.
+func single(b *ast.BlockStmt) ast.Expression {
+	if b == nil || len(b.Statements) != 1 {
+		return nil
+	}
+	s, ok := b.Statements[0].(*ast.ExpressionStmt)
+	if !ok {
+		return nil
+	}
+	return s.Expression
+}
+
+// inlinable reports whether the function bound to name can replace
+// the calls to it: its body is a small expression using its required
+// parameters and globals no function or block binds, and it does not
+// call itself.
+func (o *optimizer) inlinable(name string, fn *ast.FunctionLiteral) bool {
+	if fn.Generator || fn.Rest != nil || fn.Body == nil {
+		return false
+	}
+	for _, d := range fn.Defaults {
+		if d != nil {
+			return false
+		}
+	}
+	body := single(fn.Body)
+	if body == nil {
+		return false
+	}
+	size, recursive := 0, false
+	ast.Inspect(body, func(n ast.Node) bool {
+		if i, ok := n.(*ast.Identifier); ok && !i.Local && i.Value == name {
+			recursive = true
+		}
+		size++
+		return true
+	})
+	if recursive || size > maxInline {
+		return false
+	}
+	params := make([]ast.Expression, len(fn.Parameters))
+	for i, p := range fn.Parameters {
+		params[i] = p
+	}
+	_, ok := o.clone(body, params)
+	return ok
+}
+
+// inline returns the body of the function called by c with its
+// parameters replaced by the arguments, or nil if the call can not be
+// inlined. Only constants and parameters of the calling function are
+// passed, their evaluation can not fail nor have effects, so the
+// arguments may be used any number of times by the body.
+func (o *optimizer) inline(c *ast.CallExpr) ast.Expression {
+	name, ok := c.Function.(*ast.Identifier)
+	if !ok || name.Local {
+		return nil
+	}
+	fn, ok := o.funcs[name.Value]
+	if !ok || len(c.Arguments) != len(fn.Parameters) {
+		return nil
+	}
+	for _, arg := range c.Arguments {
+		if i, ok := arg.(*ast.Identifier); !constant(arg) && !(ok && o.params[i]) {
+			return nil
+		}
+	}
+	e, ok := o.clone(single(fn.Body), c.Arguments)
+	if !ok {
+		return nil
+	}
+	return e
+}
+
+// clone copies the body of a function replacing its parameters by
+// args. It reports false for expressions that are not inlined, those
+// binding names or referring to other local bindings, and for globals
+// a binding could capture where the body is copied to.
+func (o *optimizer) clone(e ast.Expression, args []ast.Expression) (ast.Expression, bool) {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		if !e.Local {
+			if o.declared[e.Value] {
+				return nil, false
+			}
+			c := *e
+			return &c, true
+		}
+		if e.Depth != 0 || e.Slot >= len(args) {
+			return nil, false
+		}
+		arg, ok := args[e.Slot].(*ast.Identifier)
+		if !ok {
+			return args[e.Slot], true
+		}
+		c := *arg
+		if o.params[arg] {
+			o.params[&c] = true
+		}
+		return &c, true
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return e, true
+	case *ast.TemplateLiteral:
+		c := *e
+		parts, ok := o.clones(e.Parts, args)
+		c.Parts = parts
+		return &c, ok
+	case *ast.ArrayLiteral:
+		c := *e
+		elems, ok := o.clones(e.Elements, args)
+		c.Elements = elems
+		return &c, ok
+	case *ast.HashLiteral:
+		c := *e
+		c.Pairs = make([]ast.HashPair, len(e.Pairs))
+		for i, p := range e.Pairs {
+			k, ok := o.clone(p.Key, args)
+			if !ok {
+				return nil, false
+			}
+			v, ok := o.clone(p.Value, args)
+			if !ok {
+				return nil, false
+			}
+			c.Pairs[i] = ast.HashPair{Key: k, Value: v}
+		}
+		return &c, true
+	case *ast.IndexExpr:
+		c := *e
+		var lok, iok bool
+		c.Left, lok = o.clone(e.Left, args)
+		c.Index, iok = o.clone(e.Index, args)
+		return &c, lok && iok
+	case *ast.MemberExpr:
+		// A parameter can not be replaced by an argument as the
+		// member, which must remain a name.
+		if e.Member.Local {
+			return nil, false
+		}
+		c := *e
+		left, lok := o.clone(e.Left, args)
+		member, mok := o.clone(e.Member, args)
+		c.Left = left
+		if mok {
+			c.Member = member.(*ast.Identifier)
+		}
+		return &c, lok && mok
+	case *ast.PrefixExpr:
+		c := *e
+		var ok bool
+		c.Right, ok = o.clone(e.Right, args)
+		return &c, ok
+	case *ast.InfixExpr:
+		c := *e
+		var lok, rok bool
+		c.Left, lok = o.clone(e.Left, args)
+		c.Right, rok = o.clone(e.Right, args)
+		return &c, lok && rok
+	case *ast.SpreadExpr:
+		c := *e
+		var ok bool
+		c.Value, ok = o.clone(e.Value, args)
+		return &c, ok
+	case *ast.CallExpr:
+		c := *e
+		var fok, aok bool
+		c.Function, fok = o.clone(e.Function, args)
+		c.Arguments, aok = o.clones(e.Arguments, args)
+		return &c, fok && aok
+	case *ast.IfExpr:
+		c := *e
+		var ok bool
+		if c.Condition, ok = o.clone(e.Condition, args); !ok {
+			return nil, false
+		}
+		if c.Consequence, ok = o.cloneBlock(e.Consequence, args); !ok {
+			return nil, false
+		}
+		if e.Alternative != nil {
+			c.Alternative, ok = o.cloneBlock(e.Alternative, args)
+		}
+		return &c, ok
+	}
+	return nil, false
+}
+
+func (o *optimizer) clones(list []ast.Expression, args []ast.Expression) ([]ast.Expression, bool) {
+	c := make([]ast.Expression, len(list))
+	for i, e := range list {
+		var ok bool
+		if c[i], ok = o.clone(e, args); !ok {
+			return nil, false
+		}
+	}
+	return c, true
+}
+
+// cloneBlock copies a block made of expression statements.
+func (o *optimizer) cloneBlock(b *ast.BlockStmt, args []ast.Expression) (*ast.BlockStmt, bool) {
+	c := *b
+	c.Statements = make([]ast.Statement, len(b.Statements))
+	for i, s := range b.Statements {
+		es, ok := s.(*ast.ExpressionStmt)
+		if !ok {
+			return nil, false
+		}
+		ce := *es
+		if ce.Expression, ok = o.clone(es.Expression, args); !ok {
+			return nil, false
+		}
+		c.Statements[i] = &ce
+	}
+	return &c, true
+}
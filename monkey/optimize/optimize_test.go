@@ -0,0 +1,108 @@
+package optimize
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/evaluator"
+	"github.com/emb/play/monkey/evaluator/evaltest"
+	"github.com/emb/play/monkey/lexer"
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/parser"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", "50"},
+		{"-(2 - 5)", "3"},
+		{"2 - 5", "(-3)"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 1", "(-9223372036854775808)"},
+		{`"Hello" + " " + "World!"`, "Hello World!"},
+		{"!(1 < 2) == false", "true"},
+		{"x + 1 * 2", "(x + 2)"},
+		{"5 + true", "(5 + true)"},
+		{"1 / (1 - 1)", "(1 / 0)"},
+		{"if (1 < 2) { 10 } else { 20 }", "10"},
+		{"if (1 > 2) { 10 } else { 20 }", "20"},
+		{"if (1 > 2) { 10 }", "if false {}"},
+		{"if (1) { let a = 1; a } else { 2 }", "if 1 {let a = 1;a}"},
+		{"if (false) { 1 } else { let a = 2; a }", "if true {let a = 2;a}"},
+		{"let sq = fn(x) { x * x }; sq(3)", "let sq = fn(x){(x * x)};9"},
+		{
+			"let sq = fn(x) { x * x }; let f = fn(y) { sq(y) + 1 }; [f(2), f(x)]",
+			"let sq = fn(x){(x * x)};let f = fn(y){((y * y) + 1)};[5, f(x)]",
+		},
+		{
+			"let f = fn(n) { if (n < 2) { n } else { f(n - 1) } }; f(3)",
+			"let f = fn(n){if (n < 2) {n} else {f((n - 1))}};f(3)",
+		},
+		{"f(1); let f = fn(x) { x };", "f(1)let f = fn(x){x};"},
+		{
+			"let f = fn(x) { x + y }; let g = fn(y) { f(1) };",
+			"let f = fn(x){(x + y)};let g = fn(y){f(1)};",
+		},
+		{
+			"let f = fn(x) { x }; let g = fn() { let a = 1; f(a) };",
+			"let f = fn(x){x};let g = fn(){let a = 1;f(a)};",
+		},
+	}
+	for _, tc := range tests {
+		program := parse(t, tc.input)
+		if errs := Program(program); len(errs) != 0 {
+			t.Errorf("Program(%q) failed: %v", tc.input, errs)
+			continue
+		}
+		if got := program.String(); got != tc.want {
+			t.Errorf("%q is optimized to %q, want %q", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	run := func(program *ast.Program) string {
+		var out bytes.Buffer
+		env := object.NewEnvironment()
+		env.SetRuntime(&evaluator.Runtime{Stdout: &out})
+		v, err := evaluator.Eval(program, env)
+		if err != nil {
+			return out.String() + "error: " + err.Error()
+		}
+		if v == nil {
+			return out.String()
+		}
+		return out.String() + v.Inspect()
+	}
+	// The programs of the evaluator tests are each evaluated
+	// before and after optimizing them.
+	tests, err := evaltest.LoadAll(evaltest.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range tests {
+		want := run(parse(t, tc.Input))
+		program := parse(t, tc.Input)
+		if errs := Program(program); len(errs) != 0 {
+			t.Errorf("%s: Program(%q) failed: %v", tc.Pos, tc.Input, errs)
+			continue
+		}
+		if got := run(program); got != want {
+			t.Errorf("%s: %q optimized to %q gives %q, want %q", tc.Pos, tc.Input, program, got, want)
+		}
+	}
+}
+
+func parse(t *testing.T, src string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(src))
+	program := p.Program()
+	if errs := p.Errors(); len(errs) != 0 {
+		t.Fatalf("parsing %q: %v", src, errs)
+	}
+	return program
+}
@@ -3,7 +3,9 @@ package parser
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 
 	"github.com/emb/play/monkey/ast"
 	"github.com/emb/play/monkey/lexer"
@@ -27,6 +29,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.IDENT, p.ident)
 	p.registerPrefix(token.INT, p.int)
 	p.registerPrefix(token.STRING, p.str)
+	p.registerPrefix(token.TEMPLATE, p.template)
 	p.registerPrefix(token.BANG, p.prefix)
 	p.registerPrefix(token.MINUS, p.prefix)
 	p.registerPrefix(token.TRUE, p.bool)
@@ -36,6 +39,9 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FUNCTION, p.fn)
 	p.registerPrefix(token.LBRACKET, p.array)
 	p.registerPrefix(token.LBRACE, p.hash)
+	p.registerPrefix(token.SELECT, p.selectExpr)
+	p.registerPrefix(token.MATCH, p.matchExpr)
+	p.registerPrefix(token.YIELD, p.yield)
 
 	p.registerInfix(token.PLUS, p.infix)
 	p.registerInfix(token.MINUS, p.infix)
@@ -47,6 +53,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.LT, p.infix)
 	p.registerInfix(token.LPAREN, p.call)
 	p.registerInfix(token.LBRACKET, p.index)
+	p.registerInfix(token.DOT, p.member)
+	p.registerInfix(token.PIPE, p.pipe)
 
 	return p
 }
@@ -64,10 +72,33 @@ type Parser struct {
 	c token.Token // Current
 	p token.Token // Next/Peek token
 
+	// comments precede the current token and peekComments the
+	// peek token, they are only read from lexers returning them.
+	comments     []token.Token
+	peekComments []token.Token
+
 	prefixParseFns map[token.Type]prefixParseFn
 	infixParseFns  map[token.Type]infixParseFn
 
+	// depth counts the braces opened up to the current token.
+	depth int
+
 	errors []error
+	// recovering is set by the first error of a statement, further
+	// errors are dropped until the parser synchronizes on the end
+	// of the statement.
+	recovering bool
+}
+
+// Error describes a syntax error at a position in the source.
+type Error struct {
+	Pos token.Position
+	Msg string
+}
+
+// Error returns a string describing the error
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
 }
 
 // registerPrefix registers a prefix parsing function
@@ -82,33 +113,69 @@ func (p *Parser) registerInfix(t token.Type, fn infixParseFn) {
 
 // next advances the parser by a token.
 func (p *Parser) next() {
-	p.c = p.p
-	p.p = p.l.NextToken()
+	p.c, p.comments = p.p, p.peekComments
+	p.p, p.peekComments = p.l.NextToken(), nil
+	for p.p.Type == token.COMMENT {
+		p.peekComments = append(p.peekComments, p.p)
+		p.p = p.l.NextToken()
+	}
+	switch p.c.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		p.depth--
+	}
 }
 
 // Program parses and returns an ast.Program which is the root of
-// Monkey's AST.
+// Monkey's AST. On syntax errors the program holds the statements
+// that could be parsed.
 func (p *Parser) Program() *ast.Program {
 	program := &ast.Program{
 		Statements: []ast.Statement{},
 	}
 	for p.c.Type != token.EOF {
-		stmt := p.statement()
-		if stmt != nil {
+		if stmt := p.statement(); stmt != nil && !p.recovering {
 			program.Statements = append(program.Statements,
 				stmt)
 		}
+		p.synchronize(0)
+		if p.depth < 0 {
+			// A stray closing brace.
+			p.depth = 0
+		}
 		p.next()
 	}
+	p.errors = append(p.errors, Resolve(program)...)
 	return program
 }
 
+// synchronize skips the remaining tokens of a statement that failed
+// to parse within a block at brace depth level. It stops on the
+// semicolon ending the statement, before a statement keyword or at the
+// brace closing the block, so parsing resumes with the next statement.
+func (p *Parser) synchronize(level int) {
+	if !p.recovering {
+		return
+	}
+	p.recovering = false
+	for !p.currentIs(token.EOF) && p.depth >= level {
+		if p.depth == level && (p.currentIs(token.SEMICOLON) ||
+			p.peekIs(token.RBRACE) || p.peekIs(token.LET) ||
+			p.peekIs(token.CONST) || p.peekIs(token.RETURN) ||
+			p.peekIs(token.EOF)) {
+			return
+		}
+		p.next()
+	}
+}
+
 // Errors returns a list of parser errors
 func (p *Parser) Errors() []error { return p.errors }
 
 func (p *Parser) statement() ast.Statement {
 	switch p.c.Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		return p.letStmt()
 	case token.RETURN:
 		return p.retStmt()
@@ -118,11 +185,22 @@ func (p *Parser) statement() ast.Statement {
 }
 
 func (p *Parser) letStmt() *ast.LetStmt {
-	stmt := &ast.LetStmt{Token: p.c}
-	if !p.nextIfPeek(token.IDENT) {
+	stmt := &ast.LetStmt{Token: p.c, Comments: p.comments}
+	switch {
+	case p.peekIs(token.LBRACKET):
+		p.next()
+		stmt.Pattern = p.arrayPattern()
+	case p.peekIs(token.LBRACE):
+		p.next()
+		stmt.Pattern = p.hashPattern()
+	case p.nextIfPeek(token.IDENT):
+		stmt.Name = &ast.Identifier{Token: p.c, Value: p.c.Literal}
+	default:
+		return nil
+	}
+	if stmt.Name == nil && stmt.Pattern == nil {
 		return nil
 	}
-	stmt.Name = &ast.Identifier{Token: p.c, Value: p.c.Literal}
 	if !p.nextIfPeek(token.ASSIGN) {
 		return nil
 	}
@@ -134,8 +212,53 @@ func (p *Parser) letStmt() *ast.LetStmt {
 	return stmt
 }
 
+// arrayPattern parses the identifiers of [a, b, ...rest].
+func (p *Parser) arrayPattern() ast.Pattern {
+	pat := &ast.ArrayPattern{Token: p.c, Elements: []*ast.Identifier{}}
+	for !p.peekIs(token.RBRACKET) {
+		if p.peekIs(token.ELLIPSIS) {
+			p.next()
+			if !p.nextIfPeek(token.IDENT) {
+				return nil
+			}
+			pat.Rest = &ast.Identifier{Token: p.c, Value: p.c.Literal}
+			if !p.peekIs(token.RBRACKET) {
+				p.err("rest element ...%s must be last", pat.Rest)
+				return nil
+			}
+			break
+		}
+		if !p.nextIfPeek(token.IDENT) {
+			return nil
+		}
+		pat.Elements = append(pat.Elements, &ast.Identifier{Token: p.c, Value: p.c.Literal})
+		if !p.peekIs(token.RBRACKET) && !p.nextIfPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.next()
+	return pat
+}
+
+// hashPattern parses the identifiers of {a, b}, each bound to the
+// value of the string key of the same name.
+func (p *Parser) hashPattern() ast.Pattern {
+	pat := &ast.HashPattern{Token: p.c, Keys: []*ast.Identifier{}}
+	for !p.peekIs(token.RBRACE) {
+		if !p.nextIfPeek(token.IDENT) {
+			return nil
+		}
+		pat.Keys = append(pat.Keys, &ast.Identifier{Token: p.c, Value: p.c.Literal})
+		if !p.peekIs(token.RBRACE) && !p.nextIfPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.next()
+	return pat
+}
+
 func (p *Parser) retStmt() *ast.ReturnStmt {
-	stmt := &ast.ReturnStmt{Token: p.c}
+	stmt := &ast.ReturnStmt{Token: p.c, Comments: p.comments}
 	p.next()
 	stmt.Value = p.expr(Lowest)
 	if p.peekIs(token.SEMICOLON) {
@@ -145,7 +268,7 @@ func (p *Parser) retStmt() *ast.ReturnStmt {
 }
 
 func (p *Parser) exprStmt() *ast.ExpressionStmt {
-	stmt := &ast.ExpressionStmt{Token: p.c}
+	stmt := &ast.ExpressionStmt{Token: p.c, Comments: p.comments}
 	stmt.Expression = p.expr(Lowest)
 	if p.peekIs(token.SEMICOLON) {
 		p.next()
@@ -155,8 +278,12 @@ func (p *Parser) exprStmt() *ast.ExpressionStmt {
 
 func (p *Parser) expr(prec precedence) ast.Expression {
 	prefix := p.prefixParseFns[p.c.Type]
+	if what := p.unterminated(p.c); prefix == nil && what != "" {
+		p.err("unterminated %s", what)
+		return nil
+	}
 	if prefix == nil {
-		p.err("missing parse function for token %s", p.c.Type)
+		p.err("expected an expression, got %s instead", describe(p.c))
 		return nil
 	}
 	left := prefix()
@@ -177,17 +304,53 @@ func (p *Parser) ident() ast.Expression {
 
 func (p *Parser) int() ast.Expression {
 	i, err := strconv.ParseInt(p.c.Literal, 10, 64)
-	if err != nil {
+	if err == nil {
+		return &ast.IntegerLiteral{Token: p.c, Value: i}
+	}
+	b, ok := new(big.Int).SetString(p.c.Literal, 10)
+	if !ok {
 		p.err("error parsing an integer %q: %s", p.c.Literal, err)
 		return nil
 	}
-	return &ast.IntegerLiteral{Token: p.c, Value: i}
+	return &ast.IntegerLiteral{Token: p.c, Big: b}
 }
 
 func (p *Parser) str() ast.Expression {
 	return &ast.StringLiteral{Token: p.c, Value: p.c.Literal}
 }
 
+func (p *Parser) template() ast.Expression {
+	expr := &ast.TemplateLiteral{Token: p.c}
+	for _, seg := range lexer.Segments(p.c.Literal) {
+		if !seg.Expr {
+			expr.Parts = append(expr.Parts, &ast.StringLiteral{
+				Token: token.Token{Type: token.STRING, Literal: seg.Text},
+				Value: seg.Text,
+			})
+			continue
+		}
+		sub := New(lexer.New(seg.Text))
+		if sub.currentIs(token.EOF) {
+			p.err("empty interpolation in %q", p.c.Literal)
+			return nil
+		}
+		part := sub.expr(Lowest)
+		if !sub.peekIs(token.EOF) {
+			sub.err("unexpected %s in interpolation ${%s}", describe(sub.p), seg.Text)
+		}
+		if len(sub.errors) != 0 {
+			// Positions within the interpolation are
+			// relative to it, report the string instead.
+			for _, err := range sub.errors {
+				p.errAt(p.c.Pos, "%s", err.(*Error).Msg)
+			}
+			return nil
+		}
+		expr.Parts = append(expr.Parts, part)
+	}
+	return expr
+}
+
 func (p *Parser) prefix() ast.Expression {
 	expr := &ast.PrefixExpr{
 		Token:    p.c,
@@ -198,6 +361,13 @@ func (p *Parser) prefix() ast.Expression {
 	return expr
 }
 
+func (p *Parser) yield() ast.Expression {
+	expr := &ast.YieldExpr{Token: p.c}
+	p.next()
+	expr.Value = p.listExpr()
+	return expr
+}
+
 func (p *Parser) infix(left ast.Expression) ast.Expression {
 	expr := &ast.InfixExpr{
 		Token:    p.c,
@@ -255,7 +425,9 @@ func (p *Parser) fn() ast.Expression {
 	if !p.nextIfPeek(token.LPAREN) {
 		return nil
 	}
-	expr.Parameters = p.params()
+	if !p.params(expr) {
+		return nil
+	}
 	if !p.nextIfPeek(token.LBRACE) {
 		return nil
 	}
@@ -265,37 +437,68 @@ func (p *Parser) fn() ast.Expression {
 
 func (p *Parser) block() *ast.BlockStmt {
 	block := &ast.BlockStmt{Token: p.c, Statements: []ast.Statement{}}
+	level := p.depth
 	p.next()
 	for !p.currentIs(token.RBRACE) && !p.currentIs(token.EOF) {
-		stmt := p.statement()
-		if stmt != nil {
+		if stmt := p.statement(); stmt != nil && !p.recovering {
 			block.Statements = append(block.Statements, stmt)
 		}
+		p.synchronize(level)
+		if p.depth < level {
+			// The statement ran into the closing brace.
+			break
+		}
 		p.next()
 	}
+	if p.currentIs(token.EOF) {
+		p.err("expected %s, got %s instead", token.RBRACE, describe(p.c))
+	}
 	return block
 }
 
-func (p *Parser) params() []*ast.Identifier {
-	idents := []*ast.Identifier{}
-	if p.peekIs(token.RPAREN) {
-		p.next()
-		return idents
+// params parses the parameters of fn. Parameters may have a default
+// value, parameters following one with a default must have one too. A
+// final ...rest parameter collects any extra arguments.
+func (p *Parser) params(fn *ast.FunctionLiteral) bool {
+	defaults := false
+	for !p.peekIs(token.RPAREN) {
+		if p.peekIs(token.ELLIPSIS) {
+			p.next()
+			if !p.nextIfPeek(token.IDENT) {
+				return false
+			}
+			fn.Rest = &ast.Identifier{Token: p.c, Value: p.c.Literal}
+			if !p.peekIs(token.RPAREN) {
+				p.err("rest parameter ...%s must be last", fn.Rest)
+				return false
+			}
+			break
+		}
+		if !p.nextIfPeek(token.IDENT) {
+			return false
+		}
+		ident := &ast.Identifier{Token: p.c, Value: p.c.Literal}
+		var def ast.Expression
+		if p.peekIs(token.ASSIGN) {
+			p.next()
+			p.next()
+			def = p.expr(Lowest)
+			defaults = true
+		} else if defaults {
+			p.err("parameter %s without a default follows parameters with defaults", ident)
+			return false
+		}
+		fn.Parameters = append(fn.Parameters, ident)
+		fn.Defaults = append(fn.Defaults, def)
+		if !p.peekIs(token.RPAREN) && !p.nextIfPeek(token.COMMA) {
+			return false
+		}
 	}
 	p.next()
-	idents = append(idents, &ast.Identifier{Token: p.c, Value: p.c.Literal})
-	for p.peekIs(token.COMMA) {
-		p.next() // move to comma
-		p.next() // move to next identifier
-		idents = append(idents, &ast.Identifier{
-			Token: p.c,
-			Value: p.c.Literal,
-		})
-	}
-	if !p.nextIfPeek(token.RPAREN) {
-		return nil
+	if !defaults {
+		fn.Defaults = nil
 	}
-	return idents
+	return true
 }
 
 func (p *Parser) call(callable ast.Expression) ast.Expression {
@@ -313,11 +516,11 @@ func (p *Parser) listExprs(end token.Type) []ast.Expression {
 		return args
 	}
 	p.next()
-	args = append(args, p.expr(Lowest))
+	args = append(args, p.listExpr())
 	for p.peekIs(token.COMMA) {
 		p.next() // advance to comma
 		p.next() // prepare to parse next expression
-		args = append(args, p.expr(Lowest))
+		args = append(args, p.listExpr())
 	}
 	if !p.nextIfPeek(end) {
 		return nil
@@ -325,6 +528,17 @@ func (p *Parser) listExprs(end token.Type) []ast.Expression {
 	return args
 }
 
+// listExpr parses an element of a list which may be spread.
+func (p *Parser) listExpr() ast.Expression {
+	if !p.currentIs(token.ELLIPSIS) {
+		return p.expr(Lowest)
+	}
+	expr := &ast.SpreadExpr{Token: p.c}
+	p.next()
+	expr.Value = p.expr(Lowest)
+	return expr
+}
+
 func (p *Parser) array() ast.Expression {
 	return &ast.ArrayLiteral{
 		Token:    p.c,
@@ -332,20 +546,64 @@ func (p *Parser) array() ast.Expression {
 	}
 }
 
+// pipe parses x |> f(a) as the call f(x, a) and x |> f as f(x).
+func (p *Parser) pipe(left ast.Expression) ast.Expression {
+	tok := p.c
+	p.next()
+	right := p.expr(Pipe)
+	if right == nil {
+		return nil
+	}
+	if call, ok := right.(*ast.CallExpr); ok {
+		call.Arguments = append([]ast.Expression{left}, call.Arguments...)
+		return call
+	}
+	return &ast.CallExpr{Token: tok, Function: right, Arguments: []ast.Expression{left}}
+}
+
+// index parses an index expression, or a slice expression when the
+// brackets hold a colon.
 func (p *Parser) index(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpr{Token: p.c, Left: left}
+	tok := p.c
 	p.next()
-	exp.Index = p.expr(Lowest)
+	var start ast.Expression
+	if !p.currentIs(token.COLON) {
+		start = p.expr(Lowest)
+		if !p.peekIs(token.COLON) {
+			if !p.nextIfPeek(token.RBRACKET) {
+				return nil
+			}
+			return &ast.IndexExpr{Token: tok, Left: left, Index: start}
+		}
+		p.next()
+	}
+	exp := &ast.SliceExpr{Token: tok, Left: left, Start: start}
+	if !p.peekIs(token.RBRACKET) {
+		p.next()
+		exp.End = p.expr(Lowest)
+	}
 	if !p.nextIfPeek(token.RBRACKET) {
 		return nil
 	}
 	return exp
 }
 
+func (p *Parser) member(left ast.Expression) ast.Expression {
+	exp := &ast.MemberExpr{Token: p.c, Left: left}
+	// Keywords name members too, such as re.match.
+	if token.LookupIdent(p.p.Literal) != p.p.Type {
+		p.nextIfPeek(token.IDENT)
+		return nil
+	}
+	p.next()
+	exp.Member = &ast.Identifier{Token: p.c, Value: p.c.Literal}
+	return exp
+}
+
 func (p *Parser) hash() ast.Expression {
 	hash := &ast.HashLiteral{
 		Token: p.c,
-		Pairs: make(map[ast.Expression]ast.Expression),
+		Pairs: []ast.HashPair{},
 	}
 	for !p.peekIs(token.RBRACE) {
 		p.next()
@@ -355,7 +613,7 @@ func (p *Parser) hash() ast.Expression {
 		}
 		p.next()
 		value := p.expr(Lowest)
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 		if !p.peekIs(token.RBRACE) && !p.nextIfPeek(token.COMMA) {
 			return nil
 		}
@@ -366,6 +624,200 @@ func (p *Parser) hash() ast.Expression {
 	return hash
 }
 
+func (p *Parser) selectExpr() ast.Expression {
+	expr := &ast.SelectExpr{Token: p.c}
+	if !p.nextIfPeek(token.LBRACE) {
+		return nil
+	}
+	for !p.peekIs(token.RBRACE) {
+		switch {
+		case p.peekIs(token.CASE):
+			p.next()
+			c := p.selectCase()
+			if c == nil {
+				return nil
+			}
+			expr.Cases = append(expr.Cases, c)
+		case p.peekIs(token.DEFAULT):
+			p.next()
+			if expr.Default != nil {
+				p.err("multiple defaults in select")
+				return nil
+			}
+			if !p.nextIfPeek(token.LBRACE) {
+				return nil
+			}
+			expr.Default = p.block()
+		default:
+			p.errAt(p.p.Pos, "expected next token to be %s, got %s instead",
+				token.CASE, describe(p.p))
+			return nil
+		}
+	}
+	p.next()
+	return expr
+}
+
+func (p *Parser) selectCase() *ast.SelectCase {
+	c := &ast.SelectCase{Token: p.c}
+	if p.peekIs(token.LET) {
+		p.next()
+		if !p.nextIfPeek(token.IDENT) {
+			return nil
+		}
+		c.Name = &ast.Identifier{Token: p.c, Value: p.c.Literal}
+		if !p.nextIfPeek(token.ASSIGN) {
+			return nil
+		}
+	}
+	p.next()
+	comm, ok := p.expr(Lowest).(*ast.CallExpr)
+	if !ok {
+		p.err("select case must be a send or recv call")
+		return nil
+	}
+	c.Comm = comm
+	switch fn := comm.Function.String(); {
+	case fn == "recv" && len(comm.Arguments) == 1:
+	case fn == "send" && len(comm.Arguments) == 2:
+		if c.Name != nil {
+			p.err("can not bind the result of send in a select case")
+			return nil
+		}
+	default:
+		p.err("select case must be a send or recv call, got %s", comm)
+		return nil
+	}
+	if !p.nextIfPeek(token.LBRACE) {
+		return nil
+	}
+	c.Body = p.block()
+	return c
+}
+
+func (p *Parser) matchExpr() ast.Expression {
+	expr := &ast.MatchExpr{Token: p.c}
+	p.next()
+	expr.Value = p.expr(Lowest)
+	if !p.nextIfPeek(token.LBRACE) {
+		return nil
+	}
+	for !p.peekIs(token.RBRACE) {
+		p.next()
+		arm := &ast.MatchArm{Token: p.c, Pattern: p.pattern()}
+		if arm.Pattern == nil {
+			return nil
+		}
+		if p.peekIs(token.IF) {
+			p.next()
+			p.next()
+			arm.Guard = p.expr(Lowest)
+		}
+		if !p.nextIfPeek(token.ARROW) {
+			return nil
+		}
+		p.next()
+		// A brace starts a block, hashes must be wrapped in
+		// parenthesis.
+		if p.currentIs(token.LBRACE) {
+			arm.Body = p.block()
+		} else {
+			arm.Body = p.expr(Lowest)
+		}
+		expr.Arms = append(expr.Arms, arm)
+		if !p.peekIs(token.RBRACE) && !p.nextIfPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.next()
+	return expr
+}
+
+// pattern parses the pattern of a match arm starting at the current
+// token.
+func (p *Parser) pattern() ast.Expression {
+	switch p.c.Type {
+	case token.IDENT, token.INT, token.STRING, token.TRUE, token.FALSE:
+		return p.prefixParseFns[p.c.Type]()
+	case token.MINUS:
+		if !p.peekIs(token.INT) {
+			break
+		}
+		return p.prefix()
+	case token.LBRACKET:
+		return p.arrayMatch()
+	case token.LBRACE:
+		return p.hashMatch()
+	}
+	p.err("unexpected %s in pattern", describe(p.c))
+	return nil
+}
+
+func (p *Parser) arrayMatch() ast.Expression {
+	pat := &ast.ArrayMatch{Token: p.c, Elements: []ast.Expression{}}
+	for !p.peekIs(token.RBRACKET) {
+		p.next()
+		if p.currentIs(token.ELLIPSIS) {
+			if !p.nextIfPeek(token.IDENT) {
+				return nil
+			}
+			pat.Rest = &ast.Identifier{Token: p.c, Value: p.c.Literal}
+			if !p.peekIs(token.RBRACKET) {
+				p.err("rest element ...%s must be last", pat.Rest)
+				return nil
+			}
+			break
+		}
+		e := p.pattern()
+		if e == nil {
+			return nil
+		}
+		pat.Elements = append(pat.Elements, e)
+		if !p.peekIs(token.RBRACKET) && !p.nextIfPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.next()
+	return pat
+}
+
+func (p *Parser) hashMatch() ast.Expression {
+	pat := &ast.HashMatch{Token: p.c, Pairs: []ast.HashPair{}}
+	for !p.peekIs(token.RBRACE) {
+		p.next()
+		if p.currentIs(token.ELLIPSIS) {
+			pat.Open = true
+			if !p.peekIs(token.RBRACE) {
+				p.err("... must be last in a hash pattern")
+				return nil
+			}
+			break
+		}
+		var key ast.Expression
+		switch p.c.Type {
+		case token.INT, token.STRING, token.TRUE, token.FALSE:
+			key = p.prefixParseFns[p.c.Type]()
+		default:
+			p.err("hash pattern keys must be literals, got %s", describe(p.c))
+			return nil
+		}
+		if !p.nextIfPeek(token.COLON) {
+			return nil
+		}
+		p.next()
+		value := p.pattern()
+		if value == nil {
+			return nil
+		}
+		pat.Pairs = append(pat.Pairs, ast.HashPair{Key: key, Value: value})
+		if !p.peekIs(token.RBRACE) && !p.nextIfPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.next()
+	return pat
+}
+
 // nextIfPeek checks if the next/peek token type matches t then call
 // next.
 func (p *Parser) nextIfPeek(t token.Type) bool {
@@ -373,7 +825,7 @@ func (p *Parser) nextIfPeek(t token.Type) bool {
 		p.next()
 		return true
 	}
-	p.err("expected next token to be %s, got %s instead", t, p.p.Type)
+	p.errAt(p.p.Pos, "expected next token to be %s, got %s instead", t, describe(p.p))
 	return false
 }
 
@@ -387,9 +839,46 @@ func (p *Parser) peekIs(t token.Type) bool {
 	return p.p.Type == t
 }
 
-// error append an error to the list of errors in the parser.
+// err appends an error at the current token to the list of errors in
+// the parser.
 func (p *Parser) err(msg string, a ...interface{}) {
-	p.errors = append(p.errors, fmt.Errorf(msg, a))
+	p.errAt(p.c.Pos, msg, a...)
+}
+
+// errAt appends an error at pos to the list of errors in the parser,
+// unless the parser is recovering from an earlier error.
+func (p *Parser) errAt(pos token.Position, msg string, a ...interface{}) {
+	if p.recovering {
+		return
+	}
+	p.recovering = true
+	p.errors = append(p.errors, &Error{Pos: pos, Msg: fmt.Sprintf(msg, a...)})
+}
+
+// unterminated returns what tok is missing the end of, a string or a
+// block comment, which the lexer returns as an illegal token. It is
+// empty for other tokens.
+func (p *Parser) unterminated(tok token.Token) string {
+	switch {
+	case tok.Type != token.ILLEGAL || tok.Literal == "":
+		return ""
+	case tok.Literal[0] == '"' || tok.Literal[0] == '`':
+		return "string"
+	case strings.HasPrefix(tok.Literal, "/*"):
+		return "comment"
+	}
+	return ""
+}
+
+// describe returns a description of tok for error messages.
+func describe(tok token.Token) string {
+	switch tok.Type {
+	case token.IDENT, token.INT:
+		return fmt.Sprintf("%s %s", tok.Type, tok.Literal)
+	case token.STRING, token.TEMPLATE:
+		return fmt.Sprintf("%s %q", tok.Type, tok.Literal)
+	}
+	return string(tok.Type)
 }
 
 // cp returns the current token precedence
@@ -417,6 +906,7 @@ const (
 	Lowest      precedence = iota
 	Equals                 // ==
 	LessGreater            // > or <
+	Pipe                   // x |> f
 	Sum                    // +
 	Product                // *
 	Prefix                 // -X or !X
@@ -425,6 +915,7 @@ const (
 )
 
 var precedences = map[token.Type]precedence{
+	token.PIPE:     Pipe,
 	token.EQ:       Equals,
 	token.NEQ:      Equals,
 	token.LT:       LessGreater,
@@ -435,4 +926,5 @@ var precedences = map[token.Type]precedence{
 	token.ASTERISK: Product,
 	token.LPAREN:   Call,
 	token.LBRACKET: Index,
+	token.DOT:      Index,
 }
@@ -3,10 +3,12 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/emb/play/monkey/ast"
 	"github.com/emb/play/monkey/lexer"
+	"github.com/emb/play/monkey/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -18,6 +20,7 @@ func TestLetStatements(t *testing.T) {
 		{"let x = 5;", "x", 5},
 		{"let y = true;", "y", true},
 		{"let foo = bar", "foo", "bar"},
+		{"const z = 1;", "z", 1},
 	}
 	for i, tc := range tests {
 		t.Logf("test[%d] input %q", i, tc.input)
@@ -95,6 +98,15 @@ func TestIntegerLiteralExpression(t *testing.T) {
 
 	stmt := firstExpression(t, program)
 	testIntegerLiteral(t, stmt.Expression, 7)
+
+	input = "99999999999999999999"
+	parser = New(lexer.New(input))
+	program = parser.Program()
+	checkErrors(t, parser)
+	lit, ok := firstExpression(t, program).Expression.(*ast.IntegerLiteral)
+	if !ok || lit.Big == nil || lit.Big.String() != input {
+		t.Errorf("%s is parsed as %#v, want a big literal", input, firstExpression(t, program).Expression)
+	}
 }
 
 func TestStringLiteralExpression(t *testing.T) {
@@ -114,6 +126,37 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestTemplateLiteralExpression(t *testing.T) {
+	input := `"hello ${name}, you are ${age + 1}"`
+	parse := New(lexer.New(input))
+	program := parse.Program()
+	checkErrors(t, parse)
+
+	stmt := firstExpression(t, program)
+	tmpl, ok := stmt.Expression.(*ast.TemplateLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is of type %T, want *ast.TemplateLiteral",
+			stmt.Expression)
+	}
+	if len(tmpl.Parts) != 4 {
+		t.Fatalf("template has %d parts, want 4", len(tmpl.Parts))
+	}
+	testLiteralExpr(t, tmpl.Parts[1], "name")
+	testInfix(t, tmpl.Parts[3], "age", "+", 1)
+	want := "hello ${name}, you are ${(age + 1)}"
+	if tmpl.String() != want {
+		t.Errorf("template is %q, want %q", tmpl, want)
+	}
+
+	for _, input := range []string{`"${}"`, `"${1 +}"`, `"${a b}"`} {
+		parse := New(lexer.New(input))
+		parse.Program()
+		if len(parse.Errors()) == 0 {
+			t.Errorf("parsing %s succeeded, want an error", input)
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	tests := []struct {
 		input  string
@@ -227,6 +270,11 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{"a |> push(1) |> rest |> len", "len(rest(push(a, 1)))"},
+		{"a + b |> f(c * d)", "f((a + b), (c * d))"},
+		{"a |> f == b", "(f(a) == b)"},
+		{"a |> m.f(b)[0]", "(m.f(b)[0])(a)"},
+		{"a |> fn(x) { x }", "fn(x){x}(a)"},
 	}
 
 	for i, tc := range tests {
@@ -373,6 +421,53 @@ func TestFunctionParametersParsing(t *testing.T) {
 	}
 }
 
+func TestParameterDefaultsAndRest(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"fn(x, y = 2) {}", "fn(x, y = 2){}"},
+		{"fn(x = 1, y = x + 1) {}", "fn(x = 1, y = (x + 1)){}"},
+		{"fn(x, ...rest) {}", "fn(x, ...rest){}"},
+		{"fn(...rest) {}", "fn(...rest){}"},
+		{"f(...xs, 1)", "f(...xs, 1)"},
+		{"[0, ...xs]", "[0, ...xs]"},
+		{"let [a, b, ...c] = xs;", "let [a, b, ...c] = xs;"},
+		{"let [] = xs;", "let [] = xs;"},
+		{"let {x, y} = point;", "let {x, y} = point;"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(lexer.New(tc.input))
+			program := parse.Program()
+			checkErrors(t, parse)
+			if got := program.String(); got != tc.want {
+				t.Errorf("program is %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParameterErrors(t *testing.T) {
+	tests := []string{
+		"fn(x = 1, y) {}",
+		"fn(...rest, x) {}",
+		"fn(...) {}",
+		"let [a, ...b, c] = xs;",
+		"let [1] = xs;",
+		"let {a: b} = h;",
+	}
+	for i, input := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(lexer.New(input))
+			parse.Program()
+			if len(parse.Errors()) == 0 {
+				t.Errorf("parsing %q succeeded, want an error", input)
+			}
+		})
+	}
+}
+
 func TestCallExpression(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 	parse := New(lexer.New(input))
@@ -466,19 +561,47 @@ func TestHashLiteralExpression(t *testing.T) {
 				t.Errorf("len(hash) has %d elements, want %d",
 					len(hash.Pairs), len(tc.want))
 			}
-			for key, value := range hash.Pairs {
-				literal, ok := key.(*ast.StringLiteral)
+			for _, pair := range hash.Pairs {
+				literal, ok := pair.Key.(*ast.StringLiteral)
 				if !ok {
 					t.Errorf("key is of type %T, want *ast.StringLiteral",
-						key)
+						pair.Key)
 				}
 				testf := tc.want[literal.String()]
-				testf(value)
+				testf(pair.Value)
 			}
 		})
 	}
 }
 
+func TestHashLiteralOrder(t *testing.T) {
+	input := `{"c": 1, "a": 2, "b": 3, 1: 4}`
+	parse := New(lexer.New(input))
+	program := parse.Program()
+	checkErrors(t, parse)
+
+	stmt := firstExpression(t, program)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is of type %T, want *ast.HashLiteral",
+			stmt.Expression)
+	}
+	want := []string{"c", "a", "b", "1"}
+	if len(hash.Pairs) != len(want) {
+		t.Fatalf("len(hash) has %d elements, want %d",
+			len(hash.Pairs), len(want))
+	}
+	for i, w := range want {
+		if hash.Pairs[i].Key.String() != w {
+			t.Errorf("key[%d] is %s, want %s", i, hash.Pairs[i].Key, w)
+		}
+	}
+	wantStr := `{c:1, a:2, b:3, 1:4}`
+	if hash.String() != wantStr {
+		t.Errorf("hash.String() is %q, want %q", hash, wantStr)
+	}
+}
+
 func TestIndexEpression(t *testing.T) {
 	input := "mylist[3+3]"
 	parse := New(lexer.New(input))
@@ -495,6 +618,493 @@ func TestIndexEpression(t *testing.T) {
 	testInfix(t, iexp.Index, 3, "+", 3)
 }
 
+func TestSliceExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"a[1:3]", "(a[1:3])"},
+		{"a[:n - 1]", "(a[:(n - 1)])"},
+		{"a[1 + 1:]", "(a[(1 + 1):])"},
+		{"a[:]", "(a[:])"},
+		{`"abc"[1:][0]`, "((abc[1:])[0])"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(lexer.New(tc.input))
+			program := parse.Program()
+			checkErrors(t, parse)
+			if program.String() != tc.want {
+				t.Errorf("program is %q, want %q", program, tc.want)
+			}
+		})
+	}
+	for _, input := range []string{"a[1:2:3]", "a[1:", "a[:2"} {
+		parse := New(lexer.New(input))
+		parse.Program()
+		if len(parse.Errors()) == 0 {
+			t.Errorf("parsing %q succeeded, want an error", input)
+		}
+	}
+}
+
+func TestMemberExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"os.getenv", "os.getenv"},
+		{`os.getenv("HOME")`, `os.getenv(HOME)`},
+		{`a.b.c + 1`, `(a.b.c + 1)`},
+		{`-m.x`, `(-m.x)`},
+		{`a.push(1).len()`, `a.push(1).len()`},
+		{`re.match(p, s)`, `re.match(p, s)`},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(lexer.New(tc.input))
+			program := parse.Program()
+			checkErrors(t, parse)
+			if program.String() != tc.want {
+				t.Errorf("program is %q, want %q", program, tc.want)
+			}
+		})
+	}
+	parse := New(lexer.New("os.1"))
+	parse.Program()
+	if len(parse.Errors()) == 0 {
+		t.Errorf("parsing os.1 succeeded, want an error")
+	}
+}
+
+func TestSelectExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"select {}", "select {}"},
+		{
+			"select { case let v = recv(ch) { v } case send(out, 1) { 2 } default { 3 } }",
+			"select {case let v = recv(ch) {v}case send(out, 1) {2}default {3}}",
+		},
+		{"select { default { 1 } }", "select {default {1}}"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(lexer.New(tc.input))
+			program := parse.Program()
+			checkErrors(t, parse)
+
+			stmt := firstExpression(t, program)
+			sel, ok := stmt.Expression.(*ast.SelectExpr)
+			if !ok {
+				t.Fatalf("stmt.Expression is of type %T, want *ast.SelectExpr",
+					stmt.Expression)
+			}
+			if sel.String() != tc.want {
+				t.Errorf("select is %q, want %q", sel, tc.want)
+			}
+		})
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	tests := []string{
+		"select { case x { 1 } }",
+		"select { case len(x) { 1 } }",
+		"select { case recv(a, b) { 1 } }",
+		"select { case let v = send(ch, 1) { 1 } }",
+		"select { default { 1 } default { 2 } }",
+		"select { 1 }",
+		"select { case recv(ch) }",
+	}
+	for i, input := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(lexer.New(input))
+			parse.Program()
+			if len(parse.Errors()) == 0 {
+				t.Errorf("parsing %q succeeded, want an error", input)
+			}
+		})
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`match x { 1 => "one", _ => "other" }`, `match x {1 => one, _ => other}`},
+		{`match x { -1 => a }`, `match x {(-1) => a}`},
+		{`match x { [a, ...b] if a > 1 => b }`, `match x {[a, ...b] if (a > 1) => b}`},
+		{`match x { {"t": [a], ...} => { a } }`, `match x {{t: [a], ...} => {a}}`},
+		{`match f(x) { {} => 0, }`, `match f(x) {{} => 0}`},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(lexer.New(tc.input))
+			program := parse.Program()
+			checkErrors(t, parse)
+			if got := program.String(); got != tc.want {
+				t.Errorf("program is %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMatchErrors(t *testing.T) {
+	tests := []string{
+		"match x { 1 }",
+		"match x { x + 1 => 1 }",
+		"match x { [...a, b] => 1 }",
+		"match x { {a: 1} => 1 }",
+		"match x { {..., \"a\": 1} => 1 }",
+		"match x { 1 => 1 2 => 2 }",
+	}
+	for i, input := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(lexer.New(input))
+			parse.Program()
+			if len(parse.Errors()) == 0 {
+				t.Errorf("parsing %q succeeded, want an error", input)
+			}
+		})
+	}
+}
+
+func TestYield(t *testing.T) {
+	input := `
+let count = fn(n) { if (n > 0) { yield n; yield ...count(n - 1) } };
+let pairs = fn(xs) { let i = 0; fn() { yield [i, xs] } };`
+	parse := New(lexer.New(input))
+	program := parse.Program()
+	checkErrors(t, parse)
+	want := "let count = fn(n){if (n > 0) {yield nyield ...count((n - 1))}};" +
+		"let pairs = fn(xs){let i = 0;fn(){yield [i, xs]}};"
+	if got := program.String(); got != want {
+		t.Errorf("program is %q, want %q", got, want)
+	}
+
+	var fns []*ast.FunctionLiteral
+	var yields []*ast.YieldExpr
+	ast.Inspect(program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			fns = append(fns, n)
+		case *ast.YieldExpr:
+			yields = append(yields, n)
+		}
+		return true
+	})
+	// The state of a generator takes the slot after its bindings.
+	for i, w := range []struct {
+		generator bool
+		locals    int
+	}{{true, 2}, {false, 2}, {true, 1}} {
+		if fns[i].Generator != w.generator || fns[i].Locals != w.locals {
+			t.Errorf("fn[%d] is a generator %t with %d locals, want %+v",
+				i, fns[i].Generator, fns[i].Locals, w)
+		}
+	}
+	for i, slot := range []int{1, 1, 0} {
+		if yields[i].Slot != slot {
+			t.Errorf("yield[%d] has slot %d, want %d", i, yields[i].Slot, slot)
+		}
+	}
+
+	for _, input := range []string{"yield 1", "if (true) { yield 1 }", "let x = yield;"} {
+		parse := New(lexer.New(input))
+		parse.Program()
+		if len(parse.Errors()) == 0 {
+			t.Errorf("parsing %q succeeded, want an error", input)
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input   string
+		program string
+		errors  []string
+	}{
+		{
+			"let x = f(1, 2;\nlet y = 3;",
+			"let y = 3;",
+			[]string{"1:15: expected next token to be ), got ; instead"},
+		},
+		{
+			"let a = 1\nlet = 2;\nlet b = 3;",
+			"let a = 1;let b = 3;",
+			[]string{"2:5: expected next token to be IDENT, got = instead"},
+		},
+		{
+			"let f = fn(x) {\n  let y = ;\n  y\n};\nf(1)",
+			"let f = fn(x){y};f(1)",
+			[]string{"2:11: expected an expression, got ; instead"},
+		},
+		{
+			"let f = fn(x) { x + }; let z = 1;",
+			"let f = fn(x){};let z = 1;",
+			[]string{"1:21: expected an expression, got } instead"},
+		},
+		{
+			"if (x { 1 } let q = 2;",
+			"let q = 2;",
+			[]string{"1:7: expected next token to be ), got { instead"},
+		},
+		{
+			"} let a = 1;",
+			"let a = 1;",
+			[]string{"1:1: expected an expression, got } instead"},
+		},
+		{
+			"let f = fn() { 1",
+			"",
+			[]string{"1:17: expected }, got EOF instead"},
+		},
+		{
+			"let x = 1 + + 2; 3; let y = [1, 2",
+			"3",
+			[]string{
+				"1:13: expected an expression, got + instead",
+				"1:34: expected next token to be ], got EOF instead",
+			},
+		},
+		{
+			"let s = \"${}\"; let t = 1;",
+			"let t = 1;",
+			[]string{`1:9: empty interpolation in "${}"`},
+		},
+		{
+			"let m = match x { 1 => 1 2 => 2 };\nlet n = x;",
+			"let n = x;",
+			[]string{"1:26: expected next token to be ,, got INT 2 instead"},
+		},
+		{"let a = 1; \"${", "let a = 1;", []string{"1:12: unterminated string"}},
+		{"\"a${b", "", []string{"1:1: unterminated string"}},
+		{"puts(\"abc ${\"x\")", "", []string{"1:6: unterminated string"}},
+		{"let a = 1;\n/* open /* nested */", "let a = 1;", []string{"2:1: unterminated comment"}},
+		{"puts(1, /* open", "", []string{"1:9: unterminated comment"}},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(lexer.New(tc.input))
+			program := parse.Program()
+			if program.String() != tc.program {
+				t.Errorf("program is %q, want %q", program, tc.program)
+			}
+			var errs []string
+			for _, err := range parse.Errors() {
+				errs = append(errs, err.Error())
+			}
+			if strings.Join(errs, "\n") != strings.Join(tc.errors, "\n") {
+				t.Errorf("errors are %q, want %q", errs, tc.errors)
+			}
+		})
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `#!/usr/bin/env monkey
+// Package greeting.
+
+// greet says hello.
+/* Politely. */
+let greet = fn(name) {
+  // the greeting
+  return "hello " + name;
+};
+greet("you") // trailing
+// dangling
+`
+	tests := []struct {
+		lexer *lexer.Lexer
+		want  [][]string
+	}{
+		{lexer.New(input), [][]string{nil, nil}},
+		{lexer.NewWithComments(input), [][]string{
+			{"// Package greeting.", "// greet says hello.", "/* Politely. */"},
+			nil,
+		}},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(tc.lexer)
+			program := parse.Program()
+			checkErrors(t, parse)
+			if len(program.Statements) != 2 {
+				t.Fatalf("program has %d statements, want 2", len(program.Statements))
+			}
+			let := program.Statements[0].(*ast.LetStmt)
+			call := program.Statements[1].(*ast.ExpressionStmt)
+			for j, got := range [][]token.Token{let.Comments, call.Comments} {
+				var lits []string
+				for _, c := range got {
+					lits = append(lits, c.Literal)
+				}
+				if strings.Join(lits, "|") != strings.Join(tc.want[j], "|") {
+					t.Errorf("statement %d comments are %q, want %q", j, lits, tc.want[j])
+				}
+			}
+			fn := let.Value.(*ast.FunctionLiteral)
+			ret := fn.Body.Statements[0].(*ast.ReturnStmt)
+			if tc.want[0] != nil && (len(ret.Comments) != 1 || ret.Comments[0].Literal != "// the greeting") {
+				t.Errorf("return comments are %v, want the greeting", ret.Comments)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	input := `
+let g = 1;
+fn(a, b) {
+  let c = a + g;
+  fn(d) { let e = d; b + c + e + h; };
+};`
+	parse := New(lexer.New(input))
+	program := parse.Program()
+	checkErrors(t, parse)
+
+	idents := []*ast.Identifier{}
+	var collect func(n ast.Node)
+	collect = func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.LetStmt:
+			idents = append(idents, n.Name)
+			collect(n.Value)
+		case *ast.ExpressionStmt:
+			collect(n.Expression)
+		case *ast.InfixExpr:
+			collect(n.Left)
+			collect(n.Right)
+		case *ast.Identifier:
+			idents = append(idents, n)
+		case *ast.FunctionLiteral:
+			idents = append(idents, n.Parameters...)
+			for _, s := range n.Body.Statements {
+				collect(s)
+			}
+		}
+	}
+	for _, s := range program.Statements {
+		collect(s)
+	}
+	want := []struct {
+		name        string
+		local       bool
+		depth, slot int
+	}{
+		{"g", false, 0, 0},
+		{"a", true, 0, 0}, {"b", true, 0, 1},
+		{"c", true, 0, 2}, {"a", true, 0, 0}, {"g", false, 0, 0},
+		{"d", true, 0, 0},
+		{"e", true, 0, 1}, {"d", true, 0, 0},
+		{"b", true, 1, 1}, {"c", true, 1, 2}, {"e", true, 0, 1},
+		{"h", false, 0, 0},
+	}
+	if len(idents) != len(want) {
+		t.Fatalf("found %d identifiers, want %d", len(idents), len(want))
+	}
+	for i, w := range want {
+		got := idents[i]
+		if got.Value != w.name || got.Local != w.local ||
+			got.Depth != w.depth || got.Slot != w.slot {
+			t.Errorf("ident[%d] is %s local %t depth %d slot %d, want %+v",
+				i, got.Value, got.Local, got.Depth, got.Slot, w)
+		}
+	}
+	outer := program.Statements[1].(*ast.ExpressionStmt).Expression.(*ast.FunctionLiteral)
+	if outer.Locals != 3 {
+		t.Errorf("outer.Locals is %d, want 3", outer.Locals)
+	}
+}
+
+func TestResolveBlocks(t *testing.T) {
+	input := `
+fn(a) {
+  if (a) { let b = a; b } else { let b = 1; let a = b; a };
+  let c = fn() { a + c };
+};
+if (true) { let d = 1; fn() { d } };`
+	parse := New(lexer.New(input))
+	program := parse.Program()
+	checkErrors(t, parse)
+
+	var idents []*ast.Identifier
+	ast.Inspect(program, func(n ast.Node) bool {
+		if i, ok := n.(*ast.Identifier); ok {
+			idents = append(idents, i)
+		}
+		return true
+	})
+	want := []struct {
+		name        string
+		local       bool
+		depth, slot int
+	}{
+		{"a", true, 0, 0},
+		{"a", true, 0, 0},
+		{"b", true, 0, 1}, {"a", true, 0, 0}, {"b", true, 0, 1},
+		{"b", true, 0, 2}, {"a", true, 0, 3}, {"b", true, 0, 2}, {"a", true, 0, 3},
+		{"c", true, 0, 4}, {"a", true, 1, 0}, {"c", true, 1, 4},
+		{"d", true, 0, 0}, {"d", true, 1, 0},
+	}
+	if len(idents) != len(want) {
+		t.Fatalf("found %d identifiers, want %d", len(idents), len(want))
+	}
+	for i, w := range want {
+		got := idents[i]
+		if got.Value != w.name || got.Local != w.local ||
+			got.Depth != w.depth || got.Slot != w.slot {
+			t.Errorf("ident[%d] is %s local %t depth %d slot %d, want %+v",
+				i, got.Value, got.Local, got.Depth, got.Slot, w)
+		}
+	}
+	fn := program.Statements[0].(*ast.ExpressionStmt).Expression.(*ast.FunctionLiteral)
+	if fn.Locals != 5 || fn.Body.Locals != 0 {
+		t.Errorf("fn.Locals is %d and its body %d, want 5 and 0", fn.Locals, fn.Body.Locals)
+	}
+	block := program.Statements[1].(*ast.ExpressionStmt).Expression.(*ast.IfExpr).Consequence
+	if block.Locals != 1 {
+		t.Errorf("block.Locals is %d, want 1", block.Locals)
+	}
+}
+
+func TestScopeErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let x = 1; let x = 2;", "1:16: x is already declared in this scope"},
+		{"const x = 1; let x = 2;", "1:18: cannot rebind constant x"},
+		{"let x = 1; const x = 2;", "1:18: x is already declared in this scope"},
+		{"fn() { const [a, b] = [1, 2]; let a = 3; }", "1:35: cannot rebind constant a"},
+		{"fn(a, a) { a }", "1:7: a is already declared in this scope"},
+		{"if (true) { let y = 1; let y = 2; }", "1:28: y is already declared in this scope"},
+		{"match [1, 1] { [a, a] => a }", "1:20: a is already declared in this scope"},
+	}
+	for i, tc := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			parse := New(lexer.New(tc.input))
+			parse.Program()
+			errs := parse.Errors()
+			if len(errs) != 1 || errs[0].Error() != tc.want {
+				t.Errorf("parsing %q returned errors %v, want %q", tc.input, errs, tc.want)
+			}
+		})
+	}
+	for _, input := range []string{
+		"let x = 1; if (true) { let x = 2; }",
+		"const x = 1; fn(x) { let x = x; x }",
+		"match 1 { a => a, a => a }",
+	} {
+		parse := New(lexer.New(input))
+		parse.Program()
+		checkErrors(t, parse)
+	}
+}
+
 func ensureStatements(t *testing.T, program *ast.Program, n int) {
 	if len(program.Statements) != n {
 		t.Fatalf("program.Statements has %d, want %d",
@@ -543,8 +1153,8 @@ func testIntegerLiteral(t *testing.T, expr ast.Expression, value int64) {
 }
 
 func testLet(t *testing.T, s ast.Statement, name string) {
-	if s.TokenLiteral() != "let" {
-		t.Errorf(`s.TokenLiteral has %q, want "let"`, s.TokenLiteral())
+	if s.TokenLiteral() != "let" && s.TokenLiteral() != "const" {
+		t.Errorf(`s.TokenLiteral has %q, want "let" or "const"`, s.TokenLiteral())
 	}
 
 	let, ok := s.(*ast.LetStmt)
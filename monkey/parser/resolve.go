@@ -0,0 +1,318 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/token"
+)
+
+// Resolve binds identifiers within functions and blocks to environment
+// slots, so the evaluator can find parameters and let bindings by
+// index instead of by name. Identifiers that are not bound by an
+// enclosing scope are left to be looked up by name as globals or
+// builtins.
+//
+// Functions, blocks, select cases and match arms are scopes, a binding
+// is only visible within the scope declaring it. Binding a name twice
+// in the same scope is an error, it is reported as such or as the
+// rebinding of a constant.
+//
+// Program calls Resolve on the trees it returns. Code that constructs
+// or rewrites an AST should call it again before evaluation.
+func Resolve(node ast.Node) []error {
+	var r resolver
+	r.node(node)
+	return r.errors
+}
+
+// scope describes the bindings of a function, a block, a select case
+// or a match arm.
+type scope struct {
+	names  map[string]int
+	consts map[string]bool
+	outer  *scope
+	// global is set for the scope of the program, its bindings are
+	// made by name.
+	global bool
+	// frame is the scope whose environment holds the slots of the
+	// bindings, that of the enclosing function or of the outermost
+	// scope outside of functions. slots counts them.
+	frame *scope
+	slots int
+	// fn is the function of a frame, yields collects the yield
+	// expressions making it a generator.
+	fn     *ast.FunctionLiteral
+	yields []*ast.YieldExpr
+	// pending holds identifiers used within the scope before a
+	// binding of their name is visible. They are resolved when the
+	// scope is closed so that bindings declared later, such as
+	// mutually recursive functions, are visible.
+	pending []ref
+}
+
+// ref is an identifier waiting to be resolved, depth counts the
+// frames it was pushed out of.
+type ref struct {
+	ident *ast.Identifier
+	depth int
+}
+
+type resolver struct {
+	scope  *scope
+	errors []error
+}
+
+// open starts a scope, frame is set for functions which are called
+// with an environment of their own.
+func (r *resolver) open(frame bool) {
+	s := &scope{names: map[string]int{}, consts: map[string]bool{}, outer: r.scope}
+	if frame || r.scope == nil || r.scope.global {
+		s.frame = s
+	} else {
+		s.frame = r.scope.frame
+	}
+	r.scope = s
+}
+
+// close resolves pending identifiers of the current scope, passing
+// the unresolved ones to the enclosing scope, and returns the number
+// of slots used by the scope if it is a frame.
+func (r *resolver) close() int {
+	s := r.scope
+	for _, ref := range s.pending {
+		if slot, ok := s.names[ref.ident.Value]; ok {
+			ref.ident.Local = true
+			ref.ident.Depth = ref.depth
+			ref.ident.Slot = slot
+			continue
+		}
+		if s.frame == s {
+			ref.depth++
+		}
+		if s.outer != nil && !s.outer.global {
+			s.outer.pending = append(s.outer.pending, ref)
+		}
+	}
+	r.scope = s.outer
+	if s.frame != s {
+		return 0
+	}
+	return s.slots
+}
+
+// ident resolves i to the visible binding of its name, or leaves it
+// pending until the current scope is closed.
+func (r *resolver) ident(i *ast.Identifier) {
+	i.Local, i.Depth, i.Slot = false, 0, 0
+	depth := 0
+	for s := r.scope; s != nil; s = s.outer {
+		slot, ok := s.names[i.Value]
+		switch {
+		case ok && s.global:
+			return
+		case ok:
+			i.Local, i.Depth, i.Slot = true, depth, slot
+			return
+		}
+		if s.frame == s {
+			depth++
+		}
+	}
+	if r.scope != nil && !r.scope.global {
+		r.scope.pending = append(r.scope.pending, ref{ident: i})
+	}
+}
+
+// declare binds i in the current scope, constant bindings can not be
+// rebound.
+func (r *resolver) declare(i *ast.Identifier, constant bool) {
+	i.Local, i.Depth, i.Slot = false, 0, 0
+	s := r.scope
+	if s == nil {
+		return
+	}
+	if slot, ok := s.names[i.Value]; ok {
+		if s.consts[i.Value] {
+			r.errorf(i.Token, "cannot rebind constant %s", i.Value)
+		} else {
+			r.errorf(i.Token, "%s is already declared in this scope", i.Value)
+		}
+		i.Local, i.Slot = !s.global, slot
+		return
+	}
+	if constant {
+		s.consts[i.Value] = true
+	}
+	if s.global {
+		s.names[i.Value] = 0
+		return
+	}
+	i.Local, i.Slot = true, s.frame.slots
+	s.names[i.Value] = s.frame.slots
+	s.frame.slots++
+}
+
+func (r *resolver) errorf(t token.Token, format string, args ...interface{}) {
+	r.errors = append(r.errors, &Error{Pos: t.Pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (r *resolver) nodes(ns ...ast.Node) {
+	for _, n := range ns {
+		r.node(n)
+	}
+}
+
+func (r *resolver) node(node ast.Node) {
+	switch n := node.(type) {
+	case *ast.Program:
+		r.scope = &scope{names: map[string]int{}, consts: map[string]bool{}, global: true}
+		for _, s := range n.Statements {
+			r.node(s)
+		}
+		r.scope = nil
+	case *ast.BlockStmt:
+		if n == nil {
+			return
+		}
+		r.open(false)
+		for _, s := range n.Statements {
+			r.node(s)
+		}
+		n.Locals = r.close()
+	case *ast.LetStmt:
+		if n == nil {
+			return
+		}
+		if n.Pattern != nil {
+			r.node(n.Value)
+			for _, i := range n.Pattern.Names() {
+				r.declare(i, n.Const())
+			}
+			return
+		}
+		// A function may call itself, the value of other
+		// bindings uses the bindings visible before.
+		if _, ok := n.Value.(*ast.FunctionLiteral); ok {
+			r.declare(n.Name, n.Const())
+			r.node(n.Value)
+			return
+		}
+		r.node(n.Value)
+		r.declare(n.Name, n.Const())
+	case *ast.ReturnStmt:
+		r.node(n.Value)
+	case *ast.ExpressionStmt:
+		r.node(n.Expression)
+	case *ast.Identifier:
+		r.ident(n)
+	case *ast.TemplateLiteral:
+		for _, e := range n.Parts {
+			r.node(e)
+		}
+	case *ast.ArrayLiteral:
+		for _, e := range n.Elements {
+			r.node(e)
+		}
+	case *ast.HashLiteral:
+		for _, p := range n.Pairs {
+			r.nodes(p.Key, p.Value)
+		}
+	case *ast.SpreadExpr:
+		r.node(n.Value)
+	case *ast.IndexExpr:
+		r.nodes(n.Left, n.Index)
+	case *ast.SliceExpr:
+		r.nodes(n.Left, n.Start, n.End)
+	case *ast.MemberExpr:
+		// Members of modules are looked up in the module, the
+		// member of any other value names the function a method
+		// call such as a.push(1) applies.
+		r.nodes(n.Left, n.Member)
+	case *ast.PrefixExpr:
+		r.node(n.Right)
+	case *ast.InfixExpr:
+		r.nodes(n.Left, n.Right)
+	case *ast.IfExpr:
+		r.node(n.Condition)
+		r.node(n.Consequence)
+		r.node(n.Alternative)
+	case *ast.FunctionLiteral:
+		r.open(true)
+		frame := r.scope
+		frame.fn = n
+		for _, p := range n.Parameters {
+			r.declare(p, false)
+		}
+		for _, d := range n.Defaults {
+			r.node(d)
+		}
+		if n.Rest != nil {
+			r.declare(n.Rest, false)
+		}
+		r.node(n.Body)
+		n.Locals = r.close()
+		// The state of a generator is kept past its bindings.
+		n.Generator = len(frame.yields) > 0
+		if n.Generator {
+			for _, y := range frame.yields {
+				y.Slot = n.Locals
+			}
+			n.Locals++
+		}
+	case *ast.YieldExpr:
+		r.node(n.Value)
+		if r.scope == nil || r.scope.frame == nil || r.scope.frame.fn == nil {
+			r.errorf(n.Token, "yield outside of a function")
+			return
+		}
+		r.scope.frame.yields = append(r.scope.frame.yields, n)
+	case *ast.SelectExpr:
+		for _, c := range n.Cases {
+			r.node(c.Comm)
+			r.open(false)
+			if c.Name != nil {
+				r.declare(c.Name, false)
+			}
+			r.node(c.Body)
+			c.Locals = r.close()
+		}
+		r.node(n.Default)
+	case *ast.MatchExpr:
+		r.node(n.Value)
+		for _, a := range n.Arms {
+			r.open(false)
+			r.pattern(a.Pattern)
+			r.node(a.Guard)
+			r.node(a.Body)
+			a.Locals = r.close()
+		}
+	case *ast.CallExpr:
+		r.node(n.Function)
+		for _, a := range n.Arguments {
+			r.node(a)
+		}
+	}
+}
+
+// pattern declares the identifiers bound by a match pattern, `_`
+// matches anything without binding it.
+func (r *resolver) pattern(node ast.Node) {
+	switch n := node.(type) {
+	case *ast.Identifier:
+		if n.Value != "_" {
+			r.declare(n, false)
+		}
+	case *ast.ArrayMatch:
+		for _, e := range n.Elements {
+			r.pattern(e)
+		}
+		if n.Rest != nil {
+			r.pattern(n.Rest)
+		}
+	case *ast.HashMatch:
+		for _, p := range n.Pairs {
+			r.pattern(p.Value)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package playground
+
+// page is the editor, it posts the program to /eval and shows the
+// reply. Ctrl+Enter runs the program.
+const page = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Monkey Playground</title>
+<style>
+body { margin: 0; font-family: sans-serif; display: flex; flex-direction: column; height: 100vh; }
+header { padding: 8px 12px; background: #333; color: #eee; display: flex; gap: 12px; align-items: center; }
+header h1 { font-size: 1.1em; margin: 0; flex: 1; }
+button { font-size: 1em; padding: 4px 16px; }
+textarea, pre { font-family: monospace; font-size: 14px; margin: 0; padding: 12px; box-sizing: border-box; }
+textarea { flex: 3; border: none; border-bottom: 1px solid #ccc; resize: none; tab-size: 4; }
+pre { flex: 2; overflow: auto; white-space: pre-wrap; background: #fafafa; }
+.error { color: #b00; }
+.result { color: #060; }
+.timing { color: #888; }
+</style>
+</head>
+<body>
+<header>
+<h1>Monkey Playground</h1>
+<span class="timing" id="timing"></span>
+<button id="run" title="Ctrl+Enter">Run</button>
+</header>
+<textarea id="source" spellcheck="false" autofocus>let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+puts("fib(20) is ${fib(20)}");
+[1, 2, 3]
+</textarea>
+<pre id="output"></pre>
+<script>
+const source = document.getElementById("source");
+const output = document.getElementById("output");
+const timing = document.getElementById("timing");
+const button = document.getElementById("run");
+
+function show(text, cls) {
+	const span = document.createElement("span");
+	span.textContent = text;
+	if (cls) {
+		span.className = cls;
+	}
+	output.appendChild(span);
+}
+
+async function run() {
+	button.disabled = true;
+	output.textContent = "";
+	timing.textContent = "running...";
+	try {
+		const resp = await fetch("/eval", {
+			method: "POST",
+			headers: {"Content-Type": "application/json"},
+			body: JSON.stringify({source: source.value}),
+		});
+		if (!resp.ok) {
+			throw new Error(await resp.text());
+		}
+		const r = await resp.json();
+		show(r.output);
+		if (r.truncated) {
+			show("[output truncated]\n", "error");
+		}
+		for (const err of r.parse_errors || []) {
+			show(err + "\n", "error");
+		}
+		if (r.error) {
+			show(r.error + "\n", "error");
+		} else if (r.result) {
+			show(r.result + "\n", "result");
+		}
+		timing.textContent = "parsed in " + r.parse_ms.toFixed(2) + "ms, evaluated in " + r.eval_ms.toFixed(2) + "ms";
+	} catch (err) {
+		show(String(err), "error");
+		timing.textContent = "";
+	} finally {
+		button.disabled = false;
+	}
+}
+
+button.addEventListener("click", run);
+source.addEventListener("keydown", (e) => {
+	if (e.key === "Enter" && (e.ctrlKey || e.metaKey)) {
+		e.preventDefault();
+		run();
+	} else if (e.key === "Tab") {
+		e.preventDefault();
+		source.setRangeText("\t", source.selectionStart, source.selectionEnd, "end");
+	}
+});
+</script>
+</body>
+</html>
+`
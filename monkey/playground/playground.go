@@ -0,0 +1,332 @@
+// Package playground serves a web page to edit and run Monkey programs
+// along with the JSON endpoint the page runs them with:
+//
+//	POST /eval {"source": "puts(1 + 2)"}
+//
+// replies with the value of the program, what it printed, its parser
+// or evaluation error and how long parsing and evaluating took.
+//
+// Each program is evaluated in an environment and a runtime of its
+// own, without access to the host and within the time and memory
+// limits of the handler. Programs are evaluated concurrently.
+package playground
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/evaluator"
+	"github.com/emb/play/monkey/lexer"
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/parser"
+)
+
+// Errors returned for programs exceeding their limits.
+var (
+	ErrTimeLimit   = errors.New("time limit exceeded")
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+)
+
+// errFinished interrupts the tasks left running by a program once it
+// returned.
+var errFinished = errors.New("program finished")
+
+// Limits bound the resources of a program, a zero limit is not
+// enforced.
+type Limits struct {
+	// Time is how long a program may run.
+	Time time.Duration
+	// Memory is the number of bytes of heap and stacks a program
+	// may use on top of those used when it started. It is checked
+	// every millisecond, so a program may exceed it for that
+	// long, and the garbage collector runs as the program nears
+	// it so garbage is not counted against it. The memory is that
+	// of the process, so programs running at the same time count
+	// against the limits of each other.
+	Memory uint64
+	// Output is the number of bytes printed by a program that are
+	// kept, the rest is dropped.
+	Output int
+}
+
+// DefaultLimits are the limits of the serve command.
+var DefaultLimits = Limits{
+	Time:   5 * time.Second,
+	Memory: 64 << 20,
+	Output: 64 << 10,
+}
+
+// maxSource is the size limit of a request body.
+const maxSource = 1 << 20
+
+// Request is the body of a POST /eval request.
+type Request struct {
+	Source string `json:"source"`
+}
+
+// Response is the reply to a POST /eval request. Result is the value
+// of the program as printed by the REPL, Output what the program
+// printed, Truncated is set when the output exceeded its limit.
+// ParseErrors are set when the source does not parse, the program is
+// not evaluated then, and Error when the evaluation failed or the
+// parser or the evaluator panicked. The times are in milliseconds.
+type Response struct {
+	Result      string   `json:"result,omitempty"`
+	Output      string   `json:"output"`
+	Truncated   bool     `json:"truncated,omitempty"`
+	ParseErrors []string `json:"parse_errors,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	ParseTime   float64  `json:"parse_ms"`
+	EvalTime    float64  `json:"eval_ms"`
+}
+
+// Handler returns a handler serving the editor on / and evaluating
+// programs on /eval within limits.
+func Handler(limits Limits) http.Handler {
+	s := &server{limits: limits}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.page)
+	mux.HandleFunc("/eval", s.eval)
+	return mux
+}
+
+type server struct {
+	limits Limits
+}
+
+func (s *server) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(page))
+}
+
+func (s *server) eval(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSource)).Decode(&req); err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resp Response
+	start := time.Now()
+	program, errs, err := parse(req.Source)
+	resp.ParseTime = milliseconds(time.Since(start))
+	resp.ParseErrors = errs
+	if err != nil {
+		resp.Error = err.Error()
+	} else if len(resp.ParseErrors) == 0 {
+		s.run(program, &resp)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parse parses source, a panic of the parser is returned as err.
+func parse(source string) (program *ast.Program, errs []string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			program, errs, err = nil, nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	p := parser.New(lexer.New(source))
+	program = p.Program()
+	for _, err := range p.Errors() {
+		errs = append(errs, err.Error())
+	}
+	return program, errs, nil
+}
+
+// evaluate evaluates programs, tests replace it.
+var evaluate = evaluator.Eval
+
+// run evaluates program filling the evaluation fields of resp.
+func (s *server) run(program *ast.Program, resp *Response) {
+	out := &output{max: s.limits.Output}
+	rt := &evaluator.Runtime{Stdout: out, Stdin: strings.NewReader("")}
+	env := object.NewEnvironment()
+	env.SetRuntime(rt)
+	defer func() {
+		// The tasks left running are stopped along with the
+		// program.
+		rt.Interrupt(errFinished)
+		rt.Wait()
+	}()
+
+	done := make(chan struct{})
+	exceeded := make(chan error, 1)
+	go s.watch(rt, done, exceeded)
+	start := time.Now()
+	result, err := func() (result string, err error) {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		v, err := evaluate(program, env)
+		if err != nil || v == nil {
+			return "", err
+		}
+		// Inspecting the result applies __str__ protocols,
+		// which run within the limits of the program.
+		return evaluator.Inspect(env, v), nil
+	}()
+	resp.EvalTime = milliseconds(time.Since(start))
+	if limit := <-exceeded; limit != nil {
+		// The program may have failed otherwise once
+		// interrupted, such as by a deadlock of its tasks.
+		err = limit
+	}
+
+	resp.Output, resp.Truncated = out.kept()
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Result = result
+	}
+}
+
+// watch interrupts the program running with rt once it exceeds its
+// limits, sending the limit exceeded, or nil once done is closed.
+func (s *server) watch(rt *evaluator.Runtime, done <-chan struct{}, exceeded chan<- error) {
+	var timeout <-chan time.Time
+	if s.limits.Time > 0 {
+		timer := time.NewTimer(s.limits.Time)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var tick <-chan time.Time
+	base := memory()
+	if s.limits.Memory > 0 {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		tick = ticker.C
+		raiseMemoryLimit(base + s.limits.Memory)
+		defer restoreMemoryLimit()
+	}
+	for {
+		var err error
+		select {
+		case <-done:
+			exceeded <- nil
+			return
+		case <-timeout:
+			err = ErrTimeLimit
+		case <-tick:
+			if memory() < base+s.limits.Memory {
+				continue
+			}
+			err = ErrMemoryLimit
+		}
+		rt.Interrupt(err)
+		<-done
+		exceeded <- err
+		return
+	}
+}
+
+// gc tracks the soft memory limit of the process, set so the garbage
+// collector runs as the programs near their memory limit. The limit
+// of the process is restored once no program with a memory limit
+// runs.
+var gc struct {
+	mu      sync.Mutex
+	running int
+	saved   int64
+}
+
+// raiseMemoryLimit sets the soft memory limit of the process to limit
+// unless it is higher already.
+func raiseMemoryLimit(limit uint64) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	current := debug.SetMemoryLimit(-1)
+	if gc.running == 0 {
+		gc.saved = current
+	}
+	gc.running++
+	if gc.running == 1 || int64(limit) > current {
+		debug.SetMemoryLimit(int64(limit))
+	}
+}
+
+func restoreMemoryLimit() {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.running--
+	if gc.running == 0 {
+		debug.SetMemoryLimit(gc.saved)
+	}
+}
+
+// memoryMetrics are the memory in use by the heap and the stacks of
+// the process.
+var memoryMetrics = []string{
+	"/memory/classes/heap/objects:bytes",
+	"/memory/classes/heap/stacks:bytes",
+}
+
+func memory() uint64 {
+	samples := make([]metrics.Sample, len(memoryMetrics))
+	for i, name := range memoryMetrics {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	var total uint64
+	for _, s := range samples {
+		if s.Value.Kind() == metrics.KindUint64 {
+			total += s.Value.Uint64()
+		}
+	}
+	return total
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// output keeps the first max bytes printed by a program and its
+// tasks, everything is kept if max is zero.
+type output struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := len(p)
+	if room := o.max - o.buf.Len(); o.max > 0 && len(p) > room {
+		p = p[:room]
+		o.truncated = true
+	}
+	o.buf.Write(p)
+	return n, nil
+}
+
+// kept returns the output kept and whether some was dropped.
+func (o *output) kept() (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String(), o.truncated
+}
@@ -0,0 +1,189 @@
+package playground
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/object"
+)
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source string
+		limits Limits
+		want   Response
+	}{
+		{
+			source: `puts("hello"); [1, 2][1] * 3`,
+			limits: DefaultLimits,
+			want:   Response{Result: "6", Output: "\"hello\"\n"},
+		},
+		{
+			source: "let x = ;",
+			limits: DefaultLimits,
+			want:   Response{ParseErrors: []string{"1:9: expected an expression, got ; instead"}},
+		},
+		{
+			source: `puts(1); 1 + "a"`,
+			limits: DefaultLimits,
+			want:   Response{Output: "1\n", Error: "type mismatch: Integer + String"},
+		},
+		{
+			source: `os.getenv("HOME")`,
+			limits: DefaultLimits,
+			want:   Response{Error: "permission denied: os.getenv requires --allow-read"},
+		},
+		{
+			source: "let f = fn(n) { f(n + 1) }; f(0)",
+			limits: Limits{Time: 50 * time.Millisecond},
+			want:   Response{Error: ErrTimeLimit.Error()},
+		},
+		{
+			source: "len(collect(range(0, 1000000000)))",
+			limits: Limits{Time: 50 * time.Millisecond},
+			want:   Response{Error: ErrTimeLimit.Error()},
+		},
+		{
+			source: `let f = fn(s) { f(s + s) }; f("monkey")`,
+			limits: Limits{Time: 10 * time.Second, Memory: 16 << 20},
+			want:   Response{Error: ErrMemoryLimit.Error()},
+		},
+		{
+			source: "puts(1); 1 / 0",
+			limits: DefaultLimits,
+			want:   Response{Output: "1\n", Error: "division by zero"},
+		},
+		{
+			source: `puts(1234567890); puts(1); 1`,
+			limits: Limits{Output: 8},
+			want:   Response{Result: "1", Output: "12345678", Truncated: true},
+		},
+	}
+	for _, tc := range tests {
+		srv := httptest.NewServer(Handler(tc.limits))
+		body, _ := json.Marshal(Request{Source: tc.source})
+		resp, err := http.Post(srv.URL+"/eval", "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Response
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		srv.Close()
+		if err != nil {
+			t.Fatalf("decoding the reply to %q: %v", tc.source, err)
+		}
+		if got.ParseTime < 0 || got.EvalTime < 0 {
+			t.Errorf("%q took %vms to parse and %vms to evaluate", tc.source, got.ParseTime, got.EvalTime)
+		}
+		got.ParseTime, got.EvalTime = 0, 0
+		if !equal(got, tc.want) {
+			t.Errorf("%q replied %+v, want %+v", tc.source, got, tc.want)
+		}
+	}
+}
+
+func TestPanic(t *testing.T) {
+	defer func(eval func(ast.Node, *object.Environment) (object.Object, error)) {
+		evaluate = eval
+	}(evaluate)
+	evaluate = func(ast.Node, *object.Environment) (object.Object, error) {
+		panic("boom")
+	}
+	srv := httptest.NewServer(Handler(Limits{Time: 10 * time.Second}))
+	defer srv.Close()
+	// The second request is served once the first one panicked.
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(srv.URL+"/eval", "application/json", strings.NewReader(`{"source": "1"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Response
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Error != "panic: boom" {
+			t.Errorf("request %d replied %+v, want a panic", i, got)
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	slow := httptest.NewServer(Handler(Limits{Time: 10 * time.Second}))
+	defer slow.Close()
+	fast := httptest.NewServer(Handler(DefaultLimits))
+	defer fast.Close()
+
+	// The fast programs finishing must not interrupt the slow one,
+	// nor print in its output.
+	errs := make(chan error, 1)
+	var slowResp Response
+	go func() {
+		var err error
+		slowResp, err = post(slow.URL, `let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; puts("slow"); len(collect(map(range(2000), fn(i) { f(100) })))`)
+		errs <- err
+	}()
+	for i := 0; i < 20; i++ {
+		got, err := post(fast.URL, `puts("fast"); 1`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Result != "1" || got.Output != "\"fast\"\n" || got.Error != "" {
+			t.Errorf("fast request %d replied %+v", i, got)
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if slowResp.Result != "2000" || slowResp.Output != "\"slow\"\n" || slowResp.Error != "" {
+		t.Errorf("slow request replied %+v", slowResp)
+	}
+}
+
+func post(url, source string) (Response, error) {
+	body, _ := json.Marshal(Request{Source: source})
+	resp, err := http.Post(url+"/eval", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+	var got Response
+	err = json.NewDecoder(resp.Body).Decode(&got)
+	return got, err
+}
+
+func TestRequests(t *testing.T) {
+	tests := []struct {
+		method, path, body string
+		code               int
+		want               string
+	}{
+		{"GET", "/", "", http.StatusOK, "<title>Monkey Playground</title>"},
+		{"GET", "/other", "", http.StatusNotFound, "not found"},
+		{"GET", "/eval", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"POST", "/eval", `{"source": `, http.StatusBadRequest, "bad request"},
+	}
+	h := Handler(DefaultLimits)
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if w.Code != tc.code || !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s %s replied %d %q, want %d containing %q", tc.method, tc.path, w.Code, w.Body, tc.code, tc.want)
+		}
+	}
+}
+
+func equal(a, b Response) bool {
+	if strings.Join(a.ParseErrors, "\n") != strings.Join(b.ParseErrors, "\n") {
+		return false
+	}
+	a.ParseErrors, b.ParseErrors = nil, nil
+	return a.Result == b.Result && a.Output == b.Output && a.Truncated == b.Truncated && a.Error == b.Error
+}
@@ -5,6 +5,9 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"strings"
 
 	"github.com/emb/play/monkey/evaluator"
 	"github.com/emb/play/monkey/lexer"
@@ -16,12 +19,24 @@ func prompt(w io.Writer) {
 	fmt.Fprint(w, ">> ")
 }
 
-// Start starts the Read, Eval, Print, Loop.
-func Start(in io.Reader, out io.Writer) error {
+// Start starts the Read, Eval, Print, Loop evaluating the inputs in
+// env. Inputs starting with a colon are commands:
+//
+//	:save FILE	write the inputs evaluated successfully to FILE
+//	:load FILE	evaluate the inputs saved in FILE one at a time
+//	:snapshot FILE	write the bindings of env to FILE, see Snapshot
+func Start(in io.Reader, out io.Writer, env *object.Environment) error {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	var history []string
 	for prompt(out); scanner.Scan(); prompt(out) {
 		line := scanner.Text()
+		if strings.HasPrefix(line, ":") {
+			var err error
+			if history, err = command(line, history, env); err != nil {
+				fmt.Fprintf(out, "%s\n", err)
+			}
+			continue
+		}
 		parse := parser.New(lexer.New(line))
 		program := parse.Program()
 		if errs := parse.Errors(); len(errs) != 0 {
@@ -32,13 +47,69 @@ func Start(in io.Reader, out io.Writer) error {
 		result, err := evaluator.Eval(program, env)
 		if err != nil {
 			evalError(out, err)
-		} else if result != nil {
-			fmt.Fprintf(out, "%s\n", result.Inspect())
+			continue
+		}
+		history = append(history, line)
+		if result != nil {
+			fmt.Fprintf(out, "%s\n", evaluator.Inspect(env, result))
 		}
 	}
 	return scanner.Err()
 }
 
+// command runs a REPL command given the inputs evaluated so far, it
+// returns them along with the inputs the command evaluated.
+func command(line string, history []string, env *object.Environment) ([]string, error) {
+	args := strings.Fields(line)
+	if len(args) != 2 || (args[0] != ":save" && args[0] != ":load" && args[0] != ":snapshot") {
+		return history, fmt.Errorf("usage: :save FILE, :load FILE or :snapshot FILE")
+	}
+	if args[0] == ":load" {
+		inputs, err := load(args[1], env)
+		return append(history, inputs...), err
+	}
+	f, err := os.Create(args[1])
+	if err != nil {
+		return history, err
+	}
+	if args[0] == ":save" {
+		for _, input := range history {
+			fmt.Fprintln(f, input)
+		}
+	} else if err := Snapshot(f, env); err != nil {
+		f.Close()
+		return history, err
+	}
+	return history, f.Close()
+}
+
+// load evaluates in env the inputs written to file by :save. Each
+// input is a program of its own as it was in the REPL, so an input
+// may bind a global bound by an earlier one. The inputs evaluated
+// before an error are returned.
+func load(file string, env *object.Environment) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var inputs []string
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parse := parser.New(lexer.New(line))
+		program := parse.Program()
+		if errs := parse.Errors(); len(errs) != 0 {
+			return inputs, fmt.Errorf("%s:%d: %s", file, i+1, errs[0])
+		}
+		if _, err := evaluator.Eval(program, env); err != nil {
+			return inputs, fmt.Errorf("%s:%d: %s", file, i+1, err)
+		}
+		inputs = append(inputs, line)
+	}
+	return inputs, nil
+}
+
 const monkey = `            __,__
    .--.  .-"     "-.  .--.
   / .. \/  .-. .-.  \/ .. \
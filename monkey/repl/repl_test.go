@@ -0,0 +1,211 @@
+package repl
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emb/play/monkey/evaluator"
+	"github.com/emb/play/monkey/lexer"
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/parser"
+)
+
+func TestSaveAndSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "session.mk")
+	snap := filepath.Join(dir, "session.json")
+	inputs := []string{
+		"let counter = fn(n) { fn() { n + 1 } }; let c = counter(41);",
+		"let g = if (true) { let k = 2; fn(x) { x * k } };",
+		"let x +",
+		"nope",
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };",
+		"let mk = fn() { let down = fn(n) { if (n == 0) { 0 } else { down(n - 1) } }; down }; let d = mk();",
+		`const h = {"a": [1, true, "s", first([])], 2: -7, [3]: len, 4: 2 * 9223372036854775807};`,
+		"let m = os; let r = io.read_file;",
+		`let when = [time.unix(86400), time.parse("2024-03-01T10:00:00+02:00"), 90 * time.minute];`,
+		":save " + script,
+		":snapshot " + snap,
+	}
+	var out bytes.Buffer
+	in := strings.NewReader(strings.Join(inputs, "\n"))
+	if err := Start(in, &out, object.NewEnvironment()); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := ioutil.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join(append(inputs[:2:2], inputs[4:9]...), "\n") + "\n"
+	if string(saved) != want {
+		t.Errorf(":save wrote\n%s\nwant\n%s", saved, want)
+	}
+
+	f, err := os.Open(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	env, err := Restore(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"c()", "42"},
+		{"g(3)", "6"},
+		{"fib(10)", "55"},
+		{"d(5)", "0"},
+		{"h", `{"a": [1, true, "s", null], 2: -7, [3]: builtin function, 4: 18446744073709551614}`},
+		{"h[[3]] == len", "true"},
+		{"[m == os, r == io.read_file]", "[true, true]"},
+		{"when", "[1970-01-02T00:00:00Z, 2024-03-01T10:00:00+02:00, 1h30m0s]"},
+		{"when[1] - when[0] > when[2]", "true"},
+		{"let h = 1;", "cannot rebind constant h"},
+	}
+	for _, tc := range tests {
+		program := parser.New(lexer.New(tc.input)).Program()
+		result, err := evaluator.Eval(program, env)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else if result != nil {
+			got = result.Inspect()
+		}
+		if got != tc.want {
+			t.Errorf("%s is %s after restoring, want %s", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestSaveRebindings(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "session.mk")
+	inputs := []string{
+		"let x = 1;",
+		`let y = 5; let x = y + x; "x is ${x}"`,
+		"let inc = fn(n) { n + x };",
+		"let [a, b] = [1, 2]; let z = 7;",
+		"let b = b + 3; let x = inc(b) * 2;",
+		`let {k, w} = {"k": 1}; let x_a = os.getenv;`,
+		"let k = k + 1; let f = fn() { f };",
+		"let f = 1;",
+		"const c = x; let c = 1;",
+		"let g = fn() { x }; let x = 2; let seen = g();",
+		":save " + script,
+	}
+	var out bytes.Buffer
+	in := strings.NewReader(strings.Join(inputs, "\n"))
+	env := object.NewEnvironment()
+	if err := Start(in, &out, env); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The input failing to rebind a constant is left out.
+	want := strings.Join(append(inputs[:8:8], inputs[9]), "\n") + "\n"
+	if string(saved) != want {
+		t.Errorf(":save wrote\n%s\nwant\n%s", saved, want)
+	}
+
+	out.Reset()
+	replayed := object.NewEnvironment()
+	if err := Start(strings.NewReader(":load "+script), &out, replayed); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != ">> >> " {
+		t.Fatalf(":load printed %q", out.String())
+	}
+	for _, name := range []string{"x", "y", "a", "b", "z", "k", "w", "f", "seen"} {
+		v, _ := env.Get(name)
+		got, ok := replayed.Get(name)
+		if !ok || !object.Equal(nil, got, v) {
+			t.Errorf("%s is %v once replayed, want %s", name, got, v.Inspect())
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "session.mk")
+	if err := ioutil.WriteFile(script, []byte("let x = 1;\nx + y\nlet y = 2;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := object.NewEnvironment()
+	history, err := command(":load "+script, nil, env)
+	want := script + ":2: unbound identifier: y"
+	if err == nil || err.Error() != want {
+		t.Errorf(":load failed with %v, want %s", err, want)
+	}
+	if len(history) != 1 {
+		t.Errorf(":load evaluated %q, want the first input", history)
+	}
+	if _, ok := env.Get("y"); ok {
+		t.Errorf(":load went on after an error")
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	env := object.NewEnvironment()
+	program := parser.New(lexer.New("let ch = chan();")).Program()
+	if _, err := evaluator.Eval(program, env); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err := Snapshot(&buf, env)
+	if err == nil || err.Error() != "snapshot of ch: can not write a value of type Channel" {
+		t.Errorf("Snapshot returned %v, want an error for ch", err)
+	}
+
+	// A function reading slots beyond those of its environments, or
+	// binding a name twice.
+	tampered := func(refs [][2]int, old, new string) string {
+		env := object.NewEnvironment()
+		program := parser.New(lexer.New("let f = fn(x) { let a = x; let b = 2; a };")).Program()
+		if _, err := evaluator.Eval(program, env); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := Snapshot(&buf, env); err != nil {
+			t.Fatal(err)
+		}
+		var s snapshot
+		if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		f := s.Globals[0].Value
+		if refs != nil {
+			f.Refs = refs
+		}
+		f.Fn = bytes.Replace(f.Fn, []byte(old), []byte(new), -1)
+		b, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if _, err := Restore(strings.NewReader(tampered(nil, "", ""))); err != nil {
+		t.Fatalf("Restore failed before tampering: %v", err)
+	}
+	for _, input := range []string{
+		tampered([][2]int{{0, 0}, {0, 1}, {0, 0}, {0, 2}, {0, 9}}, "", ""),
+		tampered([][2]int{{0, 0}, {0, 1}, {0, 0}, {0, 2}, {1, 0}}, "", ""),
+		tampered([][2]int{{0, 0}, {0, 1}, {0, 0}, {0, 2}, {0, -1}}, "", ""),
+		tampered(nil, `"Value": "b"`, `"Value": "a"`),
+		`{"globals": [{"name": "x", "value": {"type": "Task"}}]}`,
+		`{"globals": [{"name": "f", "value": {"type": "Function", "fn": {"Node": "Identifier"}}}]}`,
+		`{"envs": [{"outer": 1, "slots": []}]}`,
+	} {
+		if _, err := Restore(strings.NewReader(input)); err == nil {
+			t.Errorf("Restore(%s) succeeded, want an error", input)
+		}
+	}
+}
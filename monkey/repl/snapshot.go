@@ -0,0 +1,489 @@
+package repl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/evaluator"
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/parser"
+)
+
+// snapshot is the JSON form of a global environment. Functions refer
+// to the environment they close over by its index in Envs plus one,
+// zero being the global environment.
+type snapshot struct {
+	Globals []global `json:"globals"`
+	Envs    []env    `json:"envs"`
+}
+
+type global struct {
+	Name  string `json:"name"`
+	Const bool   `json:"const,omitempty"`
+	Value *value `json:"value"`
+}
+
+// env is an environment enclosed by a function call or a block, its
+// unbound slots are null.
+type env struct {
+	Outer int      `json:"outer"`
+	Slots []*value `json:"slots"`
+}
+
+// value holds an object, Type is the name of its type.
+type value struct {
+	Type string `json:"type"`
+	Int  int64  `json:"int,omitempty"`
+	// Big holds the digits of an integer that does not fit in Int.
+	Big      string   `json:"big,omitempty"`
+	Float    float64  `json:"float,omitempty"`
+	Str      string   `json:"str,omitempty"`
+	Bool     bool     `json:"bool,omitempty"`
+	Elements []*value `json:"elements,omitempty"`
+	Pairs    []pair   `json:"pairs,omitempty"`
+	// Fn is the syntax tree of a function literal, as written by
+	// ast.ToJSON, Refs the depth and slot of each of its
+	// identifiers in the order ast.Inspect visits them.
+	Fn   json.RawMessage `json:"fn,omitempty"`
+	Refs [][2]int        `json:"refs,omitempty"`
+	Env  int             `json:"env,omitempty"`
+	// Name is the name of a builtin or a module, or the location of
+	// a time held in Str.
+	Name string `json:"name,omitempty"`
+}
+
+type pair struct {
+	Key   *value `json:"key"`
+	Value *value `json:"value"`
+}
+
+// Snapshot writes the bindings of the global environment env to w, so
+// that Restore can bring them back. Integers, floats, strings,
+// booleans, times, durations, arrays, hashes, builtins and modules are
+// written along with functions, their source and the environments they
+// close over. Channels and tasks can not be written.
+func Snapshot(w io.Writer, env *object.Environment) error {
+	e := &encoder{global: env, envs: map[*object.Environment]int{}}
+	var s snapshot
+	for _, name := range env.Names() {
+		o, _ := env.Get(name)
+		v, err := e.value(o)
+		if err != nil {
+			return fmt.Errorf("snapshot of %s: %s", name, err)
+		}
+		s.Globals = append(s.Globals, global{Name: name, Const: env.Constant(name), Value: v})
+	}
+	s.Envs = e.list
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+type encoder struct {
+	global *object.Environment
+	envs   map[*object.Environment]int
+	list   []env
+}
+
+// env returns the index of the environment e, adding it to the list
+// the first time it is found.
+func (e *encoder) env(o *object.Environment) (int, error) {
+	if o == e.global {
+		return 0, nil
+	}
+	if i, ok := e.envs[o]; ok {
+		return i, nil
+	}
+	i := len(e.list) + 1
+	e.envs[o] = i
+	e.list = append(e.list, env{})
+	outer, err := e.env(o.Outer())
+	if err != nil {
+		return 0, err
+	}
+	slots := o.Slots()
+	vals := make([]*value, len(slots))
+	for j, s := range slots {
+		if s == nil {
+			continue
+		}
+		if vals[j], err = e.value(s); err != nil {
+			return 0, err
+		}
+	}
+	e.list[i-1] = env{Outer: outer, Slots: vals}
+	return i, nil
+}
+
+func (e *encoder) value(o object.Object) (*value, error) {
+	v := &value{Type: o.Type().String()}
+	switch o := o.(type) {
+	case *object.Int:
+		v.Int = int64(*o)
+	case *object.BigInt:
+		v.Big = o.Value.String()
+	case *object.Flt:
+		v.Float = float64(*o)
+	case *object.Str:
+		v.Str = string(*o)
+	case *object.Bool:
+		v.Bool = bool(*o)
+	case *object.Tm:
+		v.Str = o.Value.Format(time.RFC3339Nano)
+		v.Name = o.Value.Location().String()
+	case *object.Dur:
+		v.Int = int64(*o)
+	case *object.Nul:
+	case object.Arr:
+		v.Elements = []*value{}
+		for _, elem := range o {
+			ev, err := e.value(elem)
+			if err != nil {
+				return nil, err
+			}
+			v.Elements = append(v.Elements, ev)
+		}
+	case *object.HashMap:
+		v.Pairs = []pair{}
+		for _, p := range o.Pairs() {
+			k, err := e.value(p.Key)
+			if err != nil {
+				return nil, err
+			}
+			pv, err := e.value(p.Value)
+			if err != nil {
+				return nil, err
+			}
+			v.Pairs = append(v.Pairs, pair{Key: k, Value: pv})
+		}
+	case *object.Funct:
+		fn := &ast.FunctionLiteral{
+			Parameters: o.Parameters,
+			Defaults:   o.Defaults,
+			Rest:       o.Rest,
+			Body:       o.Body,
+		}
+		var err error
+		if v.Fn, err = ast.ToJSON(fn); err != nil {
+			return nil, err
+		}
+		v.Refs = refs(fn)
+		if v.Env, err = e.env(o.Env); err != nil {
+			return nil, err
+		}
+	case *object.BuiltinFunct:
+		if v.Name = builtinName(o); v.Name == "" {
+			return nil, fmt.Errorf("unknown builtin")
+		}
+	case *object.Mod:
+		v.Name = o.Name
+	default:
+		return nil, fmt.Errorf("can not write a value of type %s", o.Type())
+	}
+	return v, nil
+}
+
+// refs returns the resolution of the identifiers of fn, globals are
+// marked by a depth of -1.
+func refs(fn *ast.FunctionLiteral) [][2]int {
+	var r [][2]int
+	ast.Inspect(fn, func(n ast.Node) bool {
+		if i, ok := n.(*ast.Identifier); ok {
+			if i.Local {
+				r = append(r, [2]int{i.Depth, i.Slot})
+			} else {
+				r = append(r, [2]int{-1, 0})
+			}
+		}
+		return true
+	})
+	return r
+}
+
+// builtinName returns the name of a builtin, members of modules are
+// named after their module.
+func builtinName(b *object.BuiltinFunct) string {
+	for name, fn := range evaluator.Builtins() {
+		if fn == b {
+			return name
+		}
+	}
+	for name, mod := range evaluator.Modules() {
+		for member, o := range mod.Members {
+			if o == b {
+				return name + "." + member
+			}
+		}
+	}
+	return ""
+}
+
+// Restore reads a snapshot written by Snapshot and returns a global
+// environment holding its bindings.
+func Restore(r io.Reader) (*object.Environment, error) {
+	var s snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	d := &decoder{snapshot: s, envs: make([]*object.Environment, len(s.Envs)+1)}
+	d.envs[0] = object.NewEnvironment()
+	for i := range s.Envs {
+		if _, err := d.env(i + 1); err != nil {
+			return nil, err
+		}
+	}
+	// Slots are filled once every environment exists, since
+	// functions may close over any of them.
+	for i, e := range s.Envs {
+		for j, v := range e.Slots {
+			if v == nil {
+				continue
+			}
+			o, err := d.value(v)
+			if err != nil {
+				return nil, err
+			}
+			d.envs[i+1].SetSlot(0, j, o)
+		}
+	}
+	for _, g := range s.Globals {
+		o, err := d.value(g.Value)
+		if err != nil {
+			return nil, fmt.Errorf("restoring %s: %s", g.Name, err)
+		}
+		d.envs[0].Set(g.Name, o)
+		if g.Const {
+			d.envs[0].SetConstant(g.Name)
+		}
+	}
+	return d.envs[0], nil
+}
+
+type decoder struct {
+	snapshot
+	envs []*object.Environment
+	// path holds the environments being created, to catch
+	// environments enclosing themselves.
+	path map[int]bool
+}
+
+func (d *decoder) env(i int) (*object.Environment, error) {
+	if i < 0 || i >= len(d.envs) {
+		return nil, fmt.Errorf("unknown environment %d", i)
+	}
+	if d.envs[i] != nil {
+		return d.envs[i], nil
+	}
+	if d.path == nil {
+		d.path = map[int]bool{}
+	}
+	if d.path[i] {
+		return nil, fmt.Errorf("environment %d encloses itself", i)
+	}
+	d.path[i] = true
+	defer delete(d.path, i)
+	e := d.Envs[i-1]
+	outer, err := d.env(e.Outer)
+	if err != nil {
+		return nil, err
+	}
+	d.envs[i] = object.NewEnclosedEnvironment(outer, len(e.Slots))
+	return d.envs[i], nil
+}
+
+func (d *decoder) value(v *value) (object.Object, error) {
+	if v == nil {
+		return nil, fmt.Errorf("missing value")
+	}
+	switch v.Type {
+	case object.Integer.String():
+		if v.Big == "" {
+			return object.NewInt(v.Int), nil
+		}
+		i, ok := new(big.Int).SetString(v.Big, 10)
+		if !ok {
+			return nil, fmt.Errorf("bad integer %q", v.Big)
+		}
+		return object.NewBigInt(i), nil
+	case object.Float.String():
+		f := object.Flt(v.Float)
+		return &f, nil
+	case object.String.String():
+		s := object.Str(v.Str)
+		return &s, nil
+	case object.Boolean.String():
+		return object.NewBool(v.Bool), nil
+	case object.Time.String():
+		t, err := time.Parse(time.RFC3339Nano, v.Str)
+		if err != nil {
+			return nil, err
+		}
+		// The offset is kept when the location is not known here.
+		if loc, err := time.LoadLocation(v.Name); v.Name != "" && err == nil {
+			t = t.In(loc)
+		}
+		return &object.Tm{Value: t}, nil
+	case object.Duration.String():
+		d := object.Dur(v.Int)
+		return &d, nil
+	case object.Null.String():
+		return object.Nil, nil
+	case object.Array.String():
+		arr := make(object.Arr, len(v.Elements))
+		for i, elem := range v.Elements {
+			var err error
+			if arr[i], err = d.value(elem); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case object.Hash.String():
+		h := object.NewHashMap()
+		for _, p := range v.Pairs {
+			k, err := d.value(p.Key)
+			if err != nil {
+				return nil, err
+			}
+			pv, err := d.value(p.Value)
+			if err != nil {
+				return nil, err
+			}
+			if err := h.Set(evaluator.Caller(d.envs[0]), k, pv); err != nil {
+				return nil, err
+			}
+		}
+		return h, nil
+	case object.Function.String():
+		return d.function(v)
+	case object.Builtin.String():
+		name := strings.SplitN(v.Name, ".", 2)
+		if len(name) == 1 {
+			if b, ok := evaluator.Builtins()[v.Name]; ok {
+				return b, nil
+			}
+		} else if mod, ok := evaluator.Modules()[name[0]]; ok {
+			if b, ok := mod.Members[name[1]].(*object.BuiltinFunct); ok {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown builtin %s", v.Name)
+	case object.Module.String():
+		if mod, ok := evaluator.Modules()[v.Name]; ok {
+			return mod, nil
+		}
+		return nil, fmt.Errorf("unknown module %s", v.Name)
+	}
+	return nil, fmt.Errorf("can not read a value of type %s", v.Type)
+}
+
+// function rebuilds a function from its literal. The literal is
+// resolved again, which numbers its own bindings, then the
+// identifiers are bound as they were when it was written since those
+// referring to the enclosing environments can not be resolved from
+// the literal alone.
+func (d *decoder) function(v *value) (object.Object, error) {
+	node, err := ast.FromJSON(v.Fn)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := node.(*ast.FunctionLiteral)
+	if !ok || fn.Body == nil {
+		return nil, fmt.Errorf("fn does not hold a function literal")
+	}
+	if errs := parser.Resolve(fn); len(errs) != 0 {
+		return nil, errs[0]
+	}
+	var idents []*ast.Identifier
+	ast.Inspect(fn, func(n ast.Node) bool {
+		if i, ok := n.(*ast.Identifier); ok {
+			idents = append(idents, i)
+		}
+		return true
+	})
+	if len(idents) != len(v.Refs) {
+		return nil, fmt.Errorf("function has %d identifiers, refs has %d",
+			len(idents), len(v.Refs))
+	}
+	for i, ident := range idents {
+		ref := v.Refs[i]
+		ident.Local = ref[0] >= 0
+		if ident.Local {
+			ident.Depth, ident.Slot = ref[0], ref[1]
+		} else {
+			ident.Depth, ident.Slot = 0, 0
+		}
+	}
+	env, err := d.env(v.Env)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRefs(fn, env); err != nil {
+		return nil, err
+	}
+	return &object.Funct{
+		Env:        env,
+		Parameters: fn.Parameters,
+		Defaults:   fn.Defaults,
+		Rest:       fn.Rest,
+		Body:       fn.Body,
+		Locals:     fn.Locals,
+		Generator:  fn.Generator,
+	}, nil
+}
+
+// checkRefs returns an error for an identifier of fn bound to a slot
+// missing from the environments it is evaluated in, fn being called
+// in an environment enclosed by env. Functions nested in fn add an
+// environment of their own.
+func checkRefs(fn *ast.FunctionLiteral, env *object.Environment) error {
+	var (
+		nodes  []ast.Node
+		frames []int // slots of the functions enclosing a node
+		err    error
+	)
+	ast.Inspect(fn, func(n ast.Node) bool {
+		if n == nil {
+			if _, ok := nodes[len(nodes)-1].(*ast.FunctionLiteral); ok {
+				frames = frames[:len(frames)-1]
+			}
+			nodes = nodes[:len(nodes)-1]
+			return false
+		}
+		nodes = append(nodes, n)
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			frames = append(frames, n.Locals)
+		case *ast.Identifier:
+			if n.Local && err == nil && !hasSlot(frames, env, n.Depth, n.Slot) {
+				err = fmt.Errorf("%s refers to a missing slot %d at depth %d",
+					n.Value, n.Slot, n.Depth)
+			}
+		}
+		return true
+	})
+	return err
+}
+
+// hasSlot reports whether the environment depth levels out has slot,
+// frames holding the slots of the innermost environments and env
+// enclosing the outermost of them.
+func hasSlot(frames []int, env *object.Environment, depth, slot int) bool {
+	if slot < 0 {
+		return false
+	}
+	if depth < len(frames) {
+		return slot < frames[len(frames)-1-depth]
+	}
+	for depth -= len(frames); depth > 0 && env != nil; depth-- {
+		env = env.Outer()
+	}
+	return env != nil && slot < len(env.Slots())
+}
@@ -1,6 +1,8 @@
 // Package token defines the language tokens.
 package token
 
+import "fmt"
+
 // Type abstracts the type of tokens. A string was chosen to
 // simplify printing despite the performance implication.
 type Type string
@@ -9,24 +11,46 @@ type Type string
 type Token struct {
 	Type    Type
 	Literal string
+	Pos     Position
+}
+
+// Position describes where a token starts in the source, lines and
+// columns are counted from 1. The zero Position is unknown.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// IsValid returns true if the position is known.
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String returns line:column or "-" for an unknown position.
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 // Unexpected tokens
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF" // End of file reached
+	COMMENT = "COMMENT"
 )
 
 // Identifiers and literals
 const (
-	IDENT  = "IDENT"
-	INT    = "INT"
-	STRING = "STRING"
+	IDENT    = "IDENT"
+	INT      = "INT"
+	STRING   = "STRING"
+	TEMPLATE = "TEMPLATE" // string holding ${} interpolations
 )
 
 // Operations
 const (
 	ASSIGN = "="
+	ARROW  = "=>"
 	PLUS   = "+"
 	MINUS  = "-"
 	SLASH  = "/"
@@ -38,6 +62,7 @@ const (
 
 	BANG     = "!"
 	ASTERISK = "*"
+	PIPE     = "|>"
 )
 
 // Delimiters
@@ -45,6 +70,8 @@ const (
 	COMMA     = ","
 	COLON     = ":"
 	SEMICOLON = ";"
+	DOT       = "."
+	ELLIPSIS  = "..."
 )
 
 // Parenthesis
@@ -61,21 +88,33 @@ const (
 const (
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
+	CONST    = "CONST"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	SELECT   = "SELECT"
+	CASE     = "CASE"
+	DEFAULT  = "DEFAULT"
+	MATCH    = "MATCH"
+	YIELD    = "YIELD"
 )
 
 var keywords = map[string]Type{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"const":   CONST,
+	"true":    TRUE,
+	"false":   FALSE,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"select":  SELECT,
+	"case":    CASE,
+	"default": DEFAULT,
+	"match":   MATCH,
+	"yield":   YIELD,
 }
 
 // LookupIdent returns the type of a given identifier whether it is a
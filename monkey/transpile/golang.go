@@ -0,0 +1,752 @@
+package transpile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func printGo(prog *program) []byte {
+	p := &goPrinter{}
+	p.buf.WriteString(goRuntime)
+	p.line("")
+	p.line("func program() Value {")
+	p.indent++
+	p.decl(prog.vars)
+	p.stmts(prog.body)
+	if !returns(prog.body) {
+		p.line("return %s", p.expr(prog.result))
+	}
+	p.indent--
+	p.line("}")
+	return p.buf.Bytes()
+}
+
+type goPrinter struct {
+	printer
+}
+
+// decl declares variables, they are marked as used since Go rejects
+// unused variables.
+func (p *goPrinter) decl(names []string) {
+	for _, name := range names {
+		p.line("var %s Value", name)
+		p.line("_ = %s", name)
+	}
+}
+
+func (p *goPrinter) stmts(stmts []stmt) {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case declStmt:
+			p.decl(s.names)
+		case defStmt:
+			p.line("%s := %s", s.name, p.expr(s.value))
+		case setStmt:
+			p.line("%s = %s", s.name, p.expr(s.value))
+		case ifStmt:
+			p.line("if truthy(%s) {", p.expr(s.test))
+			p.indent++
+			p.stmts(s.then)
+			p.indent--
+			p.line("} else {")
+			p.indent++
+			p.stmts(s.or)
+			p.indent--
+			p.line("}")
+		case retStmt:
+			p.line("return %s", p.expr(s.value))
+		case exprStmt:
+			p.line("_ = %s", p.expr(s.value))
+		}
+	}
+}
+
+func (p *goPrinter) expr(e expr) string {
+	switch e := e.(type) {
+	case varRef:
+		return string(e)
+	case intLit:
+		return "int64(" + strconv.FormatInt(int64(e), 10) + ")"
+	case bigLit:
+		return "bigLit(" + strconv.Quote(string(e)) + ")"
+	case count:
+		return strconv.Itoa(int(e))
+	case strLit:
+		return strconv.Quote(string(e))
+	case boolLit:
+		return strconv.FormatBool(bool(e))
+	case nullLit:
+		return "null"
+	case builtinRef:
+		return "builtins[" + strconv.Quote(string(e)) + "]"
+	case rtCall:
+		args := make([]string, len(e.args))
+		for i, a := range e.args {
+			args[i] = p.expr(a)
+		}
+		return e.fn + "(" + strings.Join(args, ", ") + ")"
+	case fnLit:
+		return p.function(e)
+	}
+	panic(fmt.Sprintf("transpile: unexpected expression %T", e))
+}
+
+func (p *goPrinter) function(fn fnLit) string {
+	f := &goPrinter{printer{indent: p.indent + 1}}
+	f.line("arity(args, %d, %d)", fn.min, fn.max)
+	f.decl(fn.vars)
+	for i, par := range fn.params {
+		if par.value == nil {
+			f.line("%s = args[%d]", par.name, i)
+			continue
+		}
+		f.line("if len(args) > %d {", i)
+		f.line("\t%s = args[%d]", par.name, i)
+		f.line("} else {")
+		f.indent++
+		f.stmts(par.def)
+		f.line("%s = %s", par.name, f.expr(par.value))
+		f.indent--
+		f.line("}")
+	}
+	if fn.rest != "" {
+		f.line("%s = restOf(args, %d)", fn.rest, len(fn.params))
+	}
+	f.stmts(fn.body)
+	if !returns(fn.body) {
+		f.line("return %s", f.expr(fn.result))
+	}
+	return fmt.Sprintf("&Fn{Src: %s, Call: func(args ...Value) Value {\n%s%s}}",
+		strconv.Quote(fn.src), f.buf.String(), strings.Repeat("\t", p.indent))
+}
+
+// goRuntime implements Monkey values for Go programs. Unset variables
+// are nil, errors are raised as panics of type Error recovered by
+// main.
+const goRuntime = `// Code generated by monkey build. DO NOT EDIT.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Value is a Monkey value: int64, string, bool, Arr, *Hash, Null, *Fn
+// or *Builtin. Integers not fitting in an int64 are a *big.Int.
+type Value interface{}
+
+type Null struct{}
+
+var null Value = Null{}
+
+type Arr []Value
+
+type Hash struct {
+	index  map[string]int
+	keys   []Value
+	values []Value
+}
+
+func (h *Hash) set(k, v Value) {
+	key := keyOf(k)
+	if i, ok := h.index[key]; ok {
+		h.values[i] = v
+		return
+	}
+	h.index[key] = len(h.keys)
+	h.keys = append(h.keys, k)
+	h.values = append(h.values, v)
+}
+
+func (h *Hash) get(k Value) (Value, bool) {
+	i, ok := h.index[keyOf(k)]
+	if !ok {
+		return nil, false
+	}
+	return h.values[i], true
+}
+
+type Fn struct {
+	Src  string
+	Call func(args ...Value) Value
+}
+
+type Builtin struct {
+	Name string
+	Call func(args ...Value) Value
+}
+
+// Error is a Monkey runtime error.
+type Error string
+
+func fail(format string, args ...interface{}) {
+	panic(Error(fmt.Sprintf(format, args...)))
+}
+
+func typeName(v Value) string {
+	switch v.(type) {
+	case int64, *big.Int:
+		return "Integer"
+	case string:
+		return "String"
+	case bool:
+		return "Boolean"
+	case Arr:
+		return "Array"
+	case *Hash:
+		return "Hash"
+	case Null:
+		return "Null"
+	case *Fn:
+		return "Function"
+	case *Builtin:
+		return "Builtin"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func inspect(v Value) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case *big.Int:
+		return v.String()
+	case string:
+		return strconv.Quote(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case Arr:
+		es := make([]string, len(v))
+		for i, e := range v {
+			es[i] = inspect(e)
+		}
+		return "[" + strings.Join(es, ", ") + "]"
+	case *Hash:
+		pairs := make([]string, len(v.keys))
+		for i, k := range v.keys {
+			pairs[i] = inspect(k) + ": " + inspect(v.values[i])
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case Null:
+		return "null"
+	case *Fn:
+		return v.Src
+	case *Builtin:
+		return "builtin function"
+	}
+	return fmt.Sprint(v)
+}
+
+func tostr(v Value) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return inspect(v)
+}
+
+// keyOf returns a string identifying hash keys, keys are equal when
+// they are structurally equal.
+func keyOf(v Value) string {
+	switch v := v.(type) {
+	case int64:
+		return "i" + strconv.FormatInt(v, 10)
+	case *big.Int:
+		return "i" + v.String()
+	case string:
+		return "s" + strconv.Quote(v)
+	case bool:
+		return "b" + strconv.FormatBool(v)
+	case Arr:
+		keys := make([]string, len(v))
+		for i, e := range v {
+			keys[i] = keyOf(e)
+		}
+		return "a[" + strings.Join(keys, ",") + "]"
+	case *Hash:
+		keys := make([]string, len(v.keys))
+		for i, k := range v.keys {
+			keys[i] = keyOf(k) + "=" + keyOf(v.values[i])
+		}
+		sort.Strings(keys)
+		return "h{" + strings.Join(keys, ",") + "}"
+	}
+	fail("unhashable type %s can not be used as a hash key", typeName(v))
+	return ""
+}
+
+func equal(a, b Value) bool {
+	if typeName(a) != typeName(b) {
+		return false
+	}
+	switch a := a.(type) {
+	case *big.Int:
+		b, ok := b.(*big.Int)
+		return ok && a.Cmp(b) == 0
+	case Arr:
+		b := b.(Arr)
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b := b.(*Hash)
+		if len(a.keys) != len(b.keys) {
+			return false
+		}
+		for i, k := range a.keys {
+			v, ok := b.get(k)
+			if !ok || !equal(a.values[i], v) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func truthy(v Value) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case Null:
+		return false
+	}
+	return true
+}
+
+func infix(op string, l, r Value) Value {
+	if typeName(l) != typeName(r) {
+		fail("type mismatch: %s %s %s", typeName(l), op, typeName(r))
+	}
+	switch lv := l.(type) {
+	case *big.Int:
+		return bigInfix(op, lv, bigOf(r))
+	case int64:
+		rv, ok := r.(int64)
+		if !ok {
+			return bigInfix(op, big.NewInt(lv), bigOf(r))
+		}
+		switch op {
+		case "+":
+			if s := lv + rv; (s > lv) == (rv > 0) {
+				return s
+			}
+			return bigInfix(op, big.NewInt(lv), big.NewInt(rv))
+		case "-":
+			if d := lv - rv; (d < lv) == (rv > 0) {
+				return d
+			}
+			return bigInfix(op, big.NewInt(lv), big.NewInt(rv))
+		case "*":
+			if p := lv * rv; lv == 0 || (p/lv == rv && !(lv == -1 && rv == math.MinInt64)) {
+				return p
+			}
+			return bigInfix(op, big.NewInt(lv), big.NewInt(rv))
+		case "/":
+			if rv == 0 {
+				fail("division by zero")
+			}
+			if lv == math.MinInt64 && rv == -1 {
+				return bigInfix(op, big.NewInt(lv), big.NewInt(rv))
+			}
+			return lv / rv
+		case "<":
+			return lv < rv
+		case ">":
+			return lv > rv
+		case "==":
+			return lv == rv
+		case "!=":
+			return lv != rv
+		}
+	case string:
+		if op == "+" {
+			return lv + r.(string)
+		}
+	default:
+		switch op {
+		case "==":
+			return equal(l, r)
+		case "!=":
+			return !equal(l, r)
+		}
+	}
+	fail("bad operation: %s %s %s", typeName(l), op, typeName(r))
+	return nil
+}
+
+// bigInfix applies op to integers when they do not fit in an int64.
+func bigInfix(op string, l, r *big.Int) Value {
+	switch op {
+	case "+":
+		return norm(new(big.Int).Add(l, r))
+	case "-":
+		return norm(new(big.Int).Sub(l, r))
+	case "*":
+		return norm(new(big.Int).Mul(l, r))
+	case "/":
+		if r.Sign() == 0 {
+			fail("division by zero")
+		}
+		return norm(new(big.Int).Quo(l, r))
+	case "<":
+		return l.Cmp(r) < 0
+	case ">":
+		return l.Cmp(r) > 0
+	case "==":
+		return l.Cmp(r) == 0
+	case "!=":
+		return l.Cmp(r) != 0
+	}
+	fail("bad operation: Integer %s Integer", op)
+	return nil
+}
+
+func bigOf(v Value) *big.Int {
+	if i, ok := v.(int64); ok {
+		return big.NewInt(i)
+	}
+	return v.(*big.Int)
+}
+
+// norm returns i as an int64 when it fits, integers have a single
+// representation.
+func norm(i *big.Int) Value {
+	if i.IsInt64() {
+		return i.Int64()
+	}
+	return i
+}
+
+func bigLit(digits string) Value {
+	i, _ := new(big.Int).SetString(digits, 10)
+	return i
+}
+
+func not(v Value) Value {
+	switch v := v.(type) {
+	case bool:
+		return !v
+	case Null:
+		return true
+	}
+	return false
+}
+
+func neg(v Value) Value {
+	switch i := v.(type) {
+	case int64:
+		if i == math.MinInt64 {
+			return new(big.Int).Neg(big.NewInt(i))
+		}
+		return -i
+	case *big.Int:
+		return norm(new(big.Int).Neg(i))
+	}
+	fail("bad operator: -%s", typeName(v))
+	return nil
+}
+
+func index(l, i Value) Value {
+	switch lv := l.(type) {
+	case Arr:
+		switch i := i.(type) {
+		case int64:
+			if i < 0 || i >= int64(len(lv)) {
+				return null
+			}
+			return lv[i]
+		case *big.Int:
+			return null
+		}
+	case string:
+		switch i := i.(type) {
+		case int64:
+			runes := []rune(lv)
+			if i < 0 || i >= int64(len(runes)) {
+				return null
+			}
+			return string(runes[i])
+		case *big.Int:
+			return null
+		}
+	case *Hash:
+		if v, ok := lv.get(i); ok {
+			return v
+		}
+		return null
+	}
+	fail("bad index operator on type %s", typeName(l))
+	return nil
+}
+
+func call(fn Value, args ...Value) Value {
+	switch fn := fn.(type) {
+	case *Fn:
+		return fn.Call(args...)
+	case *Builtin:
+		return fn.Call(args...)
+	}
+	fail("bad fn call, %s is not a function", typeName(fn))
+	return nil
+}
+
+func apply(fn, args Value) Value {
+	return call(fn, args.(Arr)...)
+}
+
+func arity(args []Value, min, max int) {
+	got := len(args)
+	switch {
+	case max < 0 && got < min:
+		fail("bad number of arguments %d to fn which expects at least %d", got, min)
+	case max >= 0 && min == max && got != min:
+		fail("bad number of arguments %d to fn which expects %d", got, min)
+	case max >= 0 && (got < min || got > max):
+		fail("bad number of arguments %d to fn which expects %d to %d", got, min, max)
+	}
+}
+
+func restOf(args []Value, n int) Value {
+	rest := Arr{}
+	if len(args) > n {
+		rest = append(rest, args[n:]...)
+	}
+	return rest
+}
+
+func array(elems ...Value) Value {
+	return Arr(elems)
+}
+
+func concat(parts ...Value) Value {
+	arr := Arr{}
+	for _, p := range parts {
+		arr = append(arr, p.(Arr)...)
+	}
+	return arr
+}
+
+func spread(v Value) Value {
+	if _, ok := v.(Arr); !ok {
+		fail("can not spread value of type %s", typeName(v))
+	}
+	return v
+}
+
+// protocols are the keys binding the protocols of hashes, which are
+// not implemented.
+var protocols = map[string]bool{
+	"__add__":   true,
+	"__eq__":    true,
+	"__lt__":    true,
+	"__str__":   true,
+	"__hash__":  true,
+	"__len__":   true,
+	"__index__": true,
+}
+
+func hash(pairs ...Value) Value {
+	h := &Hash{index: map[string]int{}}
+	for i := 0; i < len(pairs); i += 2 {
+		if k, ok := pairs[i].(string); ok && protocols[k] {
+			fail("protocol %s is not supported", k)
+		}
+		h.set(pairs[i], pairs[i+1])
+	}
+	return h
+}
+
+func template(parts ...Value) Value {
+	var buf strings.Builder
+	for _, p := range parts {
+		buf.WriteString(tostr(p))
+	}
+	return buf.String()
+}
+
+func get(v Value, name string) Value {
+	if v == nil {
+		unbound(name)
+	}
+	return v
+}
+
+func unbound(name string) Value {
+	fail("unbound identifier: %s", name)
+	return nil
+}
+
+func destructArray(v Value, n int, rest bool) Value {
+	arr, ok := v.(Arr)
+	if !ok {
+		fail("can not destructure %s as an array", typeName(v))
+	}
+	parts := Arr{}
+	for i := 0; i < n; i++ {
+		if i < len(arr) {
+			parts = append(parts, arr[i])
+		} else {
+			parts = append(parts, null)
+		}
+	}
+	if rest {
+		parts = append(parts, restOf(arr, n))
+	}
+	return parts
+}
+
+func destructHash(v Value, keys ...string) Value {
+	h, ok := v.(*Hash)
+	if !ok {
+		fail("can not destructure %s as a hash", typeName(v))
+	}
+	parts := Arr{}
+	for _, k := range keys {
+		if e, ok := h.get(k); ok {
+			parts = append(parts, e)
+		} else {
+			parts = append(parts, null)
+		}
+	}
+	return parts
+}
+
+func at(v Value, i int) Value {
+	return v.(Arr)[i]
+}
+
+func nargs(name string, want int, args []Value) {
+	if len(args) != want {
+		fail("bad number of arguments %d to builtin '%s' which expects %d", len(args), name, want)
+	}
+}
+
+func badArg(name string, v Value) {
+	fail("bad argument type %s for bultin in '%s'", typeName(v), name)
+}
+
+// arrayArg returns the single array argument of builtin name.
+func arrayArg(name string, args []Value) Arr {
+	nargs(name, 1, args)
+	arr, ok := args[0].(Arr)
+	if !ok {
+		badArg(name, args[0])
+	}
+	return arr
+}
+
+// hashArg returns the single hash argument of builtin name.
+func hashArg(name string, args []Value) *Hash {
+	nargs(name, 1, args)
+	h, ok := args[0].(*Hash)
+	if !ok {
+		badArg(name, args[0])
+	}
+	return h
+}
+
+var builtins = map[string]*Builtin{
+	"len": {Name: "len", Call: func(args ...Value) Value {
+		nargs("len", 1, args)
+		switch arg := args[0].(type) {
+		case string:
+			return int64(utf8.RuneCountInString(arg))
+		case Arr:
+			return int64(len(arg))
+		case *Hash:
+			return int64(len(arg.keys))
+		}
+		badArg("len", args[0])
+		return nil
+	}},
+	"first": {Name: "first", Call: func(args ...Value) Value {
+		if arr := arrayArg("first", args); len(arr) > 0 {
+			return arr[0]
+		}
+		return null
+	}},
+	"last": {Name: "last", Call: func(args ...Value) Value {
+		if arr := arrayArg("last", args); len(arr) > 0 {
+			return arr[len(arr)-1]
+		}
+		return null
+	}},
+	"rest": {Name: "rest", Call: func(args ...Value) Value {
+		if arr := arrayArg("rest", args); len(arr) > 0 {
+			return append(Arr{}, arr[1:]...)
+		}
+		return null
+	}},
+	"push": {Name: "push", Call: func(args ...Value) Value {
+		nargs("push", 2, args)
+		arr, ok := args[0].(Arr)
+		if !ok {
+			badArg("push", args[0])
+		}
+		return append(append(Arr{}, arr...), args[1])
+	}},
+	"keys": {Name: "keys", Call: func(args ...Value) Value {
+		return append(Arr{}, hashArg("keys", args).keys...)
+	}},
+	"values": {Name: "values", Call: func(args ...Value) Value {
+		return append(Arr{}, hashArg("values", args).values...)
+	}},
+	"str": {Name: "str", Call: func(args ...Value) Value {
+		nargs("str", 1, args)
+		return tostr(args[0])
+	}},
+	"format": {Name: "format", Call: func(args ...Value) Value {
+		if len(args) < 1 {
+			fail("bad number of arguments %d to builtin 'format' which expects 1", len(args))
+		}
+		format, ok := args[0].(string)
+		if !ok {
+			badArg("format", args[0])
+		}
+		values := make([]interface{}, len(args)-1)
+		for i, arg := range args[1:] {
+			switch arg.(type) {
+			case int64, *big.Int, string, bool:
+				values[i] = arg
+			default:
+				values[i] = inspect(arg)
+			}
+		}
+		return fmt.Sprintf(format, values...)
+	}},
+	"puts": {Name: "puts", Call: func(args ...Value) Value {
+		for _, arg := range args {
+			fmt.Println(inspect(arg))
+		}
+		return null
+	}},
+}
+
+func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(Error)
+			if !ok {
+				panic(r)
+			}
+			fmt.Fprintln(os.Stderr, string(err))
+			os.Exit(1)
+		}
+	}()
+	program()
+}
+`
@@ -0,0 +1,705 @@
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/token"
+)
+
+// Hack returns the Hack VM code, as read by nand2tetris/hackvmt, of a
+// program written in the subset of Monkey the Hack platform can run.
+//
+// Values are 16 bit words: integers wrap around, true is -1 and false
+// as well as null are 0, so the condition of an if is false for the
+// integer 0. Arrays are allocated on the heap from address 2048 and
+// never freed, a word holding their length is followed by their
+// elements. Functions must be bound by top level let statements, they
+// are not values and do not close over variables, but they may use
+// globals. The len, first, last, rest and push builtins are
+// supported. Dividing by zero yields 0.
+//
+// The code is meant to be saved as Main.vm. It defines Sys.init which
+// runs the program, stores its value in temp 0 (RAM[5]) and loops
+// forever.
+func Hack(program *ast.Program) ([]byte, error) {
+	c := &hackCompiler{
+		globals: map[string]int{},
+		funcs:   map[string]*ast.FunctionLiteral{},
+	}
+	if err := c.program(program); err != nil {
+		return nil, err
+	}
+	return c.buf.Bytes(), nil
+}
+
+// hackMain is the function running the statements of the program.
+const hackMain = "Sys.main"
+
+// hackBuiltins maps the supported builtins to their number of
+// arguments.
+var hackBuiltins = map[string]int{
+	"len":   1,
+	"first": 1,
+	"last":  1,
+	"rest":  1,
+	"push":  2,
+}
+
+type hackCompiler struct {
+	buf bytes.Buffer
+	// globals maps top level let bindings to static variables,
+	// static 0 holds the heap pointer.
+	globals map[string]int
+	funcs   map[string]*ast.FunctionLiteral
+	labels  int
+	fn      *hackFunc
+}
+
+// hackFunc is the function being compiled, its code is held until the
+// number of locals is known.
+type hackFunc struct {
+	code bytes.Buffer
+	// params is the number of parameters, they hold the first
+	// slots of the function.
+	params int
+	// base is the local holding the first slot following the
+	// parameters in the current frame.
+	base int
+	// locals counts the locals used by slots and intermediate
+	// values.
+	locals int
+}
+
+func (f *hackFunc) emit(format string, args ...interface{}) {
+	fmt.Fprintf(&f.code, format, args...)
+	f.code.WriteByte('\n')
+}
+
+// temp returns a new local for an intermediate value.
+func (f *hackFunc) temp() int {
+	f.locals++
+	return f.locals - 1
+}
+
+// slot returns the segment and index of the VM variable holding the
+// slot of a resolved identifier.
+func (f *hackFunc) slot(i *ast.Identifier) (string, int) {
+	if i.Slot < f.params {
+		return "argument", i.Slot
+	}
+	return "local", f.base + i.Slot - f.params
+}
+
+func (c *hackCompiler) label(name string) string {
+	c.labels++
+	return fmt.Sprintf("%s%d", name, c.labels)
+}
+
+func (c *hackCompiler) program(p *ast.Program) error {
+	var main []ast.Statement
+	for _, st := range p.Statements {
+		let, ok := st.(*ast.LetStmt)
+		if !ok || let.Pattern != nil {
+			main = append(main, st)
+			continue
+		}
+		fn, ok := let.Value.(*ast.FunctionLiteral)
+		if !ok {
+			main = append(main, st)
+			continue
+		}
+		if len(fn.Defaults) > 0 || fn.Rest != nil {
+			return Unsupported{Pos: fn.Token.Pos, What: "optional parameter"}
+		}
+		c.funcs[let.Name.Value] = fn
+	}
+	// The lets of the program, outside of blocks, are globals.
+	for _, st := range main {
+		if let, ok := st.(*ast.LetStmt); ok && let.Pattern == nil {
+			c.globals[let.Name.Value] = len(c.globals) + 1
+		}
+	}
+
+	c.buf.WriteString(hackRuntime)
+	if err := c.function(hackMain, &ast.FunctionLiteral{}, main); err != nil {
+		return err
+	}
+	// Functions are compiled in the order they are defined.
+	for _, st := range p.Statements {
+		let, ok := st.(*ast.LetStmt)
+		if !ok || let.Pattern != nil || c.funcs[let.Name.Value] != let.Value {
+			continue
+		}
+		fn := c.funcs[let.Name.Value]
+		if err := c.function("Main."+let.Name.Value, fn, fn.Body.Statements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// function compiles the body of fn, the slots following its
+// parameters are locals.
+func (c *hackCompiler) function(name string, fn *ast.FunctionLiteral, body []ast.Statement) error {
+	c.fn = &hackFunc{
+		params: len(fn.Parameters),
+		locals: fn.Locals - len(fn.Parameters),
+	}
+	if err := c.stmts(body); err != nil {
+		return err
+	}
+	c.fn.emit("return")
+	fmt.Fprintf(&c.buf, "function %s %d\n", name, c.fn.locals)
+	c.buf.Write(c.fn.code.Bytes())
+	return nil
+}
+
+// stmts compiles a list of statements leaving the value of the last
+// one on the stack.
+func (c *hackCompiler) stmts(stmts []ast.Statement) error {
+	for i, st := range stmts {
+		switch st := st.(type) {
+		case *ast.LetStmt:
+			if err := c.let(st); err != nil {
+				return err
+			}
+		case *ast.ReturnStmt:
+			// The statements following a return are never
+			// run.
+			if err := c.expr(st.Value); err != nil {
+				return err
+			}
+			c.fn.emit("return")
+			return nil
+		case *ast.ExpressionStmt:
+			if err := c.expr(st.Expression); err != nil {
+				return err
+			}
+			if i == len(stmts)-1 {
+				return nil
+			}
+			c.fn.emit("pop temp 0")
+		default:
+			return fmt.Errorf("transpile: unexpected statement %T", st)
+		}
+	}
+	c.fn.emit("push constant 0")
+	return nil
+}
+
+func (c *hackCompiler) let(st *ast.LetStmt) error {
+	if st.Pattern != nil {
+		return Unsupported{Pos: st.Token.Pos, What: "destructuring"}
+	}
+	if c.funcs[st.Name.Value] == st.Value {
+		// A top level function, compiled on its own.
+		return nil
+	}
+	if err := c.expr(st.Value); err != nil {
+		return err
+	}
+	if st.Name.Local {
+		segment, i := c.fn.slot(st.Name)
+		c.fn.emit("pop %s %d", segment, i)
+	} else {
+		c.fn.emit("pop static %d", c.globals[st.Name.Value])
+	}
+	return nil
+}
+
+// block compiles the statements of b, a block outside of functions
+// holds its slots in locals of its own.
+func (c *hackCompiler) block(b *ast.BlockStmt) error {
+	if b.Locals > 0 {
+		base := c.fn.base
+		defer func() { c.fn.base = base }()
+		c.fn.base = c.fn.locals
+		c.fn.locals += b.Locals
+	}
+	return c.stmts(b.Statements)
+}
+
+// hackOps maps infix operators to VM commands.
+var hackOps = map[string]string{
+	token.PLUS:     "add",
+	token.MINUS:    "sub",
+	token.ASTERISK: "call Monkey.mul 2",
+	token.SLASH:    "call Monkey.div 2",
+	token.LT:       "lt",
+	token.GT:       "gt",
+	token.EQ:       "eq",
+	token.NEQ:      "eq\nnot",
+}
+
+// expr compiles e leaving its value on the stack.
+func (c *hackCompiler) expr(e ast.Expression) error {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		if e.Big != nil || e.Value > 32767 {
+			return fmt.Errorf("%s: integer %s does not fit in 16 bits", e.Token.Pos, e.Token.Literal)
+		}
+		c.fn.emit("push constant %d", e.Value)
+	case *ast.Boolean:
+		c.fn.emit("push constant 0")
+		if e.Value {
+			c.fn.emit("not")
+		}
+	case *ast.Identifier:
+		return c.ident(e)
+	case *ast.PrefixExpr:
+		if err := c.expr(e.Right); err != nil {
+			return err
+		}
+		switch e.Operator {
+		case token.MINUS:
+			c.fn.emit("neg")
+		case token.BANG:
+			c.fn.emit("push constant 0")
+			c.fn.emit("eq")
+		default:
+			return Unsupported{Pos: e.Token.Pos, What: "operator " + e.Operator}
+		}
+	case *ast.InfixExpr:
+		op, ok := hackOps[e.Operator]
+		if !ok {
+			return Unsupported{Pos: e.Token.Pos, What: "operator " + e.Operator}
+		}
+		if err := c.expr(e.Left); err != nil {
+			return err
+		}
+		if err := c.expr(e.Right); err != nil {
+			return err
+		}
+		c.fn.emit("%s", op)
+	case *ast.IfExpr:
+		return c.ifExpr(e)
+	case *ast.ArrayLiteral:
+		return c.array(e)
+	case *ast.IndexExpr:
+		if err := c.expr(e.Left); err != nil {
+			return err
+		}
+		if err := c.expr(e.Index); err != nil {
+			return err
+		}
+		c.fn.emit("call Monkey.index 2")
+	case *ast.CallExpr:
+		return c.call(e)
+	case *ast.FunctionLiteral:
+		return Unsupported{Pos: e.Token.Pos, What: "function value"}
+	case *ast.StringLiteral:
+		return Unsupported{Pos: e.Token.Pos, What: "string"}
+	case *ast.TemplateLiteral:
+		return Unsupported{Pos: e.Token.Pos, What: "string"}
+	case *ast.HashLiteral:
+		return Unsupported{Pos: e.Token.Pos, What: "hash"}
+	case *ast.SpreadExpr:
+		return Unsupported{Pos: e.Token.Pos, What: "spread"}
+	case *ast.MatchExpr:
+		return Unsupported{Pos: e.Token.Pos, What: "match"}
+	case *ast.SelectExpr:
+		return Unsupported{Pos: e.Token.Pos, What: "select"}
+	case *ast.MemberExpr:
+		return Unsupported{Pos: e.Token.Pos, What: "member access"}
+	case *ast.SliceExpr:
+		return Unsupported{Pos: e.Token.Pos, What: "slice"}
+	case *ast.YieldExpr:
+		return Unsupported{Pos: e.Token.Pos, What: "yield"}
+	default:
+		return fmt.Errorf("transpile: unexpected expression %T", e)
+	}
+	return nil
+}
+
+func (c *hackCompiler) ident(i *ast.Identifier) error {
+	if i.Local {
+		segment, n := c.fn.slot(i)
+		c.fn.emit("push %s %d", segment, n)
+		return nil
+	}
+	if n, ok := c.globals[i.Value]; ok {
+		c.fn.emit("push static %d", n)
+		return nil
+	}
+	if _, ok := c.funcs[i.Value]; ok {
+		return Unsupported{Pos: i.Token.Pos, What: "function value"}
+	}
+	if _, ok := hackBuiltins[i.Value]; ok {
+		return Unsupported{Pos: i.Token.Pos, What: "function value"}
+	}
+	return fmt.Errorf("%s: unbound identifier: %s", i.Token.Pos, i.Value)
+}
+
+func (c *hackCompiler) ifExpr(e *ast.IfExpr) error {
+	if err := c.expr(e.Condition); err != nil {
+		return err
+	}
+	then, end := c.label("IF_TRUE"), c.label("IF_END")
+	c.fn.emit("if-goto %s", then)
+	if e.Alternative != nil {
+		if err := c.block(e.Alternative); err != nil {
+			return err
+		}
+	} else {
+		c.fn.emit("push constant 0")
+	}
+	c.fn.emit("goto %s", end)
+	c.fn.emit("label %s", then)
+	if err := c.block(e.Consequence); err != nil {
+		return err
+	}
+	c.fn.emit("label %s", end)
+	return nil
+}
+
+// array allocates the array on the heap, its address is held by a
+// temporary while the elements are stored.
+func (c *hackCompiler) array(e *ast.ArrayLiteral) error {
+	t := c.fn.temp()
+	c.fn.emit("push constant %d", len(e.Elements)+1)
+	c.fn.emit("call Monkey.alloc 1")
+	c.fn.emit("pop local %d", t)
+	c.fn.emit("push constant %d", len(e.Elements))
+	c.fn.emit("push local %d", t)
+	c.fn.emit("pop pointer 1")
+	c.fn.emit("pop that 0")
+	for i, elem := range e.Elements {
+		if err := c.expr(elem); err != nil {
+			return err
+		}
+		c.fn.emit("push local %d", t)
+		c.fn.emit("push constant %d", i+1)
+		c.fn.emit("add")
+		c.fn.emit("pop pointer 1")
+		c.fn.emit("pop that 0")
+	}
+	c.fn.emit("push local %d", t)
+	return nil
+}
+
+func (c *hackCompiler) call(e *ast.CallExpr) error {
+	ident, ok := e.Function.(*ast.Identifier)
+	if !ok {
+		return Unsupported{Pos: e.Token.Pos, What: "calling a function value"}
+	}
+	var name string
+	var nargs int
+	if fn, ok := c.funcs[ident.Value]; ok && !ident.Local {
+		name, nargs = "Main."+ident.Value, len(fn.Parameters)
+	} else if n, ok := hackBuiltins[ident.Value]; ok && !ident.Local {
+		name, nargs = "Monkey."+ident.Value, n
+	} else {
+		if err := c.ident(ident); err != nil {
+			return err
+		}
+		return Unsupported{Pos: ident.Token.Pos, What: "calling a function value"}
+	}
+	if len(e.Arguments) != nargs {
+		return fmt.Errorf("%s: bad number of arguments %d to %s which expects %d",
+			ident.Token.Pos, len(e.Arguments), ident.Value, nargs)
+	}
+	for _, arg := range e.Arguments {
+		if err := c.expr(arg); err != nil {
+			return err
+		}
+	}
+	c.fn.emit("call %s %d", name, nargs)
+	return nil
+}
+
+// hackRuntime starts the program and implements the operations the VM
+// lacks. Arrays are a word holding their length followed by their
+// elements.
+const hackRuntime = `// Code generated by monkey build. DO NOT EDIT.
+
+function Sys.init 0
+push constant 2048
+pop static 0
+call Sys.main 0
+pop temp 0
+label MONKEY_HALT
+goto MONKEY_HALT
+
+// alloc(n) returns the address of n new words.
+function Monkey.alloc 0
+push static 0
+push static 0
+push argument 0
+add
+pop static 0
+return
+
+// copy(src, dst, n) copies n words from src to dst.
+function Monkey.copy 1
+label MONKEY_COPY_LOOP
+push local 0
+push argument 2
+lt
+not
+if-goto MONKEY_COPY_END
+push argument 0
+push local 0
+add
+pop pointer 1
+push that 0
+push argument 1
+push local 0
+add
+pop pointer 1
+pop that 0
+push local 0
+push constant 1
+add
+pop local 0
+goto MONKEY_COPY_LOOP
+label MONKEY_COPY_END
+push constant 0
+return
+
+// mul(a, b) adds a shifted left for each bit set in b.
+function Monkey.mul 3
+push argument 0
+pop local 1
+push constant 1
+pop local 2
+label MONKEY_MUL_LOOP
+push local 2
+push constant 0
+eq
+if-goto MONKEY_MUL_END
+push argument 1
+push local 2
+and
+push constant 0
+eq
+if-goto MONKEY_MUL_NEXT
+push local 0
+push local 1
+add
+pop local 0
+label MONKEY_MUL_NEXT
+push local 1
+push local 1
+add
+pop local 1
+push local 2
+push local 2
+add
+pop local 2
+goto MONKEY_MUL_LOOP
+label MONKEY_MUL_END
+push local 0
+return
+
+// div(a, b) truncates the quotient toward zero.
+function Monkey.div 1
+push argument 1
+push constant 0
+eq
+if-goto MONKEY_DIV_ZERO
+push argument 0
+push constant 0
+lt
+push argument 1
+push constant 0
+lt
+eq
+not
+pop local 0
+push argument 0
+push constant 0
+lt
+not
+if-goto MONKEY_DIV_A
+push argument 0
+neg
+pop argument 0
+label MONKEY_DIV_A
+push argument 1
+push constant 0
+lt
+not
+if-goto MONKEY_DIV_B
+push argument 1
+neg
+pop argument 1
+label MONKEY_DIV_B
+push argument 0
+push argument 1
+call Monkey.udiv 2
+push local 0
+not
+if-goto MONKEY_DIV_END
+neg
+label MONKEY_DIV_END
+return
+label MONKEY_DIV_ZERO
+push constant 0
+return
+
+// udiv(x, y) divides non negative integers, x / y is twice x / 2y
+// plus one if what remains is at least y.
+function Monkey.udiv 1
+push argument 1
+push argument 0
+gt
+if-goto MONKEY_UDIV_ZERO
+push argument 1
+push argument 1
+add
+push constant 0
+lt
+if-goto MONKEY_UDIV_ONE
+push argument 0
+push argument 1
+push argument 1
+add
+call Monkey.udiv 2
+push constant 2
+call Monkey.mul 2
+pop local 0
+push argument 0
+push local 0
+push argument 1
+call Monkey.mul 2
+sub
+push argument 1
+lt
+if-goto MONKEY_UDIV_END
+push local 0
+push constant 1
+add
+pop local 0
+label MONKEY_UDIV_END
+push local 0
+return
+label MONKEY_UDIV_ONE
+push constant 1
+return
+label MONKEY_UDIV_ZERO
+push constant 0
+return
+
+// index(arr, i) returns arr[i], or 0 if i is out of bounds.
+function Monkey.index 0
+push argument 1
+push constant 0
+lt
+if-goto MONKEY_INDEX_NULL
+push argument 0
+pop pointer 1
+push argument 1
+push that 0
+lt
+not
+if-goto MONKEY_INDEX_NULL
+push argument 0
+push argument 1
+add
+pop pointer 1
+push that 1
+return
+label MONKEY_INDEX_NULL
+push constant 0
+return
+
+function Monkey.len 0
+push argument 0
+pop pointer 1
+push that 0
+return
+
+function Monkey.first 0
+push argument 0
+push constant 0
+call Monkey.index 2
+return
+
+function Monkey.last 0
+push argument 0
+push argument 0
+pop pointer 1
+push that 0
+push constant 1
+sub
+call Monkey.index 2
+return
+
+// rest(arr) returns a new array without the first element, or 0 if
+// arr is empty.
+function Monkey.rest 1
+push argument 0
+pop pointer 1
+push that 0
+push constant 0
+eq
+if-goto MONKEY_REST_NULL
+push that 0
+call Monkey.alloc 1
+pop local 0
+push argument 0
+pop pointer 1
+push that 0
+push constant 1
+sub
+push local 0
+pop pointer 1
+pop that 0
+push argument 0
+push constant 2
+add
+push local 0
+push constant 1
+add
+push that 0
+call Monkey.copy 3
+pop temp 0
+push local 0
+return
+label MONKEY_REST_NULL
+push constant 0
+return
+
+// push(arr, v) returns a new array holding the elements of arr
+// followed by v.
+function Monkey.push 1
+push argument 0
+pop pointer 1
+push that 0
+push constant 2
+add
+call Monkey.alloc 1
+pop local 0
+push argument 0
+pop pointer 1
+push that 0
+push constant 1
+add
+push local 0
+pop pointer 1
+pop that 0
+push argument 0
+push constant 1
+add
+push local 0
+push constant 1
+add
+push argument 0
+pop pointer 1
+push that 0
+call Monkey.copy 3
+pop temp 0
+push argument 1
+push local 0
+push local 0
+pop pointer 1
+push that 0
+add
+pop pointer 1
+pop that 0
+push local 0
+return
+
+`
@@ -0,0 +1,464 @@
+package transpile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// hackTests hold programs along with the value they leave in temp 0.
+var hackTests = []struct {
+	input string
+	want  int16
+}{
+	{"5 + 5 * 2", 15},
+	{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+	{"[-7 / 2, 7 / -2, -100 * 3, 181 * 181, 7 / 0][3]", 32761},
+	{"-7 / 2 + 7 / -2 * 10", -33},
+	{"32767 + 1", -32768},
+	{"1 < 2", -1},
+	{"!true", 0},
+	{"!(1 > 2) == true", -1},
+	{"if (1 > 2) { 10 } else { 20 }", 20},
+	{"if (false) { 10 }", 0},
+	{"9; return 2 * 5; 9;", 10},
+	{"let a = 3; let b = a * 4; b - a", 9},
+	{"let a = 3; if (true) { let a = a + 1; } a", 3},
+	{"let a = 3; if (true) { let b = a + 1; let a = b * 2; a } else { 0 }", 8},
+	{"let f = fn(x) { let y = if (x > 0) { let y = x * 2; y + 1 } else { x }; y * 10 + x }; f(2)", 52},
+	{"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(15)", 610},
+	{"let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(10)", -1},
+	{"let base = 10; let f = fn(x) { let y = x * 2; base + y }; f(3)", 16},
+	{"let f = fn(x) { if (x > 0) { return 1; } 2 }; f(1) * 10 + f(0)", 12},
+	{"let a = [1, 2, 3]; a[0] + a[2] + len(a)", 7},
+	{"[[1, 2], [3, 4]][1][0] * [[1, 2], [3, 4]][0][1]", 6},
+	{"[1, 2][2] + [1, 2][-1] + first([]) + last([])", 0},
+	{"let a = push([1, 2], 3); len(rest(a)) * 10 + last(a) + first(rest(a))", 25},
+	{"let sum = fn(xs) { if (len(xs) == 0) { 0 } else { first(xs) + sum(rest(xs)) } }; sum([1, 2, 3, 4])", 10},
+	{"let double = fn(xs, acc) { if (len(xs) == 0) { acc } else { double(rest(xs), push(acc, first(xs) * 2)) } }; double([1, 2, 3], [])[2]", 6},
+}
+
+func TestHack(t *testing.T) {
+	for _, tc := range hackTests {
+		src, err := Hack(parse(t, tc.input))
+		if err != nil {
+			t.Errorf("Hack(%q): %v", tc.input, err)
+			continue
+		}
+		got, err := runHack(src)
+		if err != nil {
+			t.Errorf("running %q: %v", tc.input, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q is %d on Hack, want %d", tc.input, got, tc.want)
+		}
+	}
+}
+
+// TestHackVMT translates the programs to Hack assembly with the
+// translator of nand2tetris/hackvmt and runs them on the Hack CPU.
+func TestHackVMT(t *testing.T) {
+	src := filepath.Join("..", "..", "nand2tetris", "hackvmt")
+	files, err := filepath.Glob(filepath.Join(src, "*.go"))
+	if err != nil || len(files) == 0 {
+		t.Skip("nand2tetris/hackvmt is not available")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "hackvmt")
+	args := []string{"build", "-o", bin}
+	for _, f := range files {
+		if !strings.HasSuffix(f, "_test.go") {
+			args = append(args, f)
+		}
+	}
+	build := exec.Command("go", args...)
+	build.Env = append(os.Environ(), "GOTOOLCHAIN=local")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	for i, tc := range hackTests {
+		src, err := Hack(parse(t, tc.input))
+		if err != nil {
+			t.Errorf("Hack(%q): %v", tc.input, err)
+			continue
+		}
+		// hackvmt bootstraps Sys.init when translating a
+		// directory, Main/Main.vm becomes Main/Main.asm.
+		main := filepath.Join(dir, fmt.Sprint("p", i), "Main")
+		if err := os.MkdirAll(main, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(main, "Main.vm"), src, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := exec.Command(bin, main).CombinedOutput(); err != nil {
+			t.Errorf("hackvmt on %q: %v\n%s", tc.input, err, out)
+			continue
+		}
+		asm, err := ioutil.ReadFile(filepath.Join(main, "Main.asm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := runAsm(asm)
+		if err != nil {
+			t.Errorf("running %q: %v", tc.input, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q is %d on the Hack CPU, want %d", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestHackErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`"monkey"`, "1:1: string is not supported"},
+		{"let f = fn(x) { fn(y) { x + y } }; f(1)", "1:17: function value is not supported"},
+		{"let f = fn(x) { x }; let g = f;", "1:30: function value is not supported"},
+		{"let f = fn(x, y = 1) { x }", "1:9: optional parameter is not supported"},
+		{"let f = fn(x) { x }; let g = fn(f) { f(1) };", "1:38: calling a function value is not supported"},
+		{"let f = fn(x) { x }; f(1, 2)", "1:22: bad number of arguments 2 to f which expects 1"},
+		{"40000", "1:1: integer 40000 does not fit in 16 bits"},
+		{"x + 1", "1:1: unbound identifier: x"},
+		{`{"a": 1}`, "1:1: hash is not supported"},
+	}
+	for _, tc := range tests {
+		_, err := Hack(parse(t, tc.input))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("Hack(%q) returned error %v, want %q", tc.input, err, tc.want)
+		}
+	}
+}
+
+// runHack interprets the VM code of a program until it halts,
+// returning the value it left in temp 0.
+func runHack(src []byte) (int16, error) {
+	type command struct {
+		op, arg string
+		n       int
+	}
+	var code []command
+	labels := map[string]int{}
+	s := bufio.NewScanner(bytes.NewReader(src))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		parts := strings.Fields(line)
+		switch len(parts) {
+		case 0:
+			continue
+		case 1:
+			code = append(code, command{op: parts[0]})
+		case 2:
+			if parts[0] == "label" {
+				labels[parts[1]] = len(code)
+			}
+			code = append(code, command{op: parts[0], arg: parts[1]})
+		case 3:
+			n, err := strconv.Atoi(parts[2])
+			if err != nil {
+				return 0, fmt.Errorf("line %q: %v", line, err)
+			}
+			if parts[0] == "function" {
+				labels[parts[1]] = len(code)
+			}
+			code = append(code, command{op: parts[0], arg: parts[1], n: n})
+		default:
+			return 0, fmt.Errorf("bad line %q", line)
+		}
+	}
+
+	const sp, lcl, arg, this, that, temp, static = 0, 1, 2, 3, 4, 5, 16
+	var ram [32768]int16
+	push := func(v int16) {
+		ram[ram[sp]] = v
+		ram[sp]++
+	}
+	pop := func() int16 {
+		ram[sp]--
+		return ram[ram[sp]]
+	}
+	address := func(segment string, i int) (int, error) {
+		switch segment {
+		case "local":
+			return int(ram[lcl]) + i, nil
+		case "argument":
+			return int(ram[arg]) + i, nil
+		case "this":
+			return int(ram[this]) + i, nil
+		case "that":
+			return int(ram[that]) + i, nil
+		case "pointer":
+			return this + i, nil
+		case "temp":
+			return temp + i, nil
+		case "static":
+			return static + i, nil
+		}
+		return 0, fmt.Errorf("bad segment %q", segment)
+	}
+	jump := func(label string) (int, error) {
+		pc, ok := labels[label]
+		if !ok {
+			return 0, fmt.Errorf("unknown label %q", label)
+		}
+		return pc, nil
+	}
+	boolean := func(b bool) int16 {
+		if b {
+			return -1
+		}
+		return 0
+	}
+
+	ram[sp] = 256
+	pc, err := jump("Sys.init")
+	if err != nil {
+		return 0, err
+	}
+	for steps := 0; steps < 10000000; steps++ {
+		c := code[pc]
+		pc++
+		switch c.op {
+		case "push", "pop":
+			if c.arg == "constant" {
+				push(int16(c.n))
+				break
+			}
+			a, err := address(c.arg, c.n)
+			if err != nil {
+				return 0, err
+			}
+			if c.op == "push" {
+				push(ram[a])
+			} else {
+				ram[a] = pop()
+			}
+		case "add", "sub", "and", "or", "eq", "lt", "gt":
+			y, x := pop(), pop()
+			push(map[string]int16{
+				"add": x + y, "sub": x - y, "and": x & y, "or": x | y,
+				"eq": boolean(x == y), "lt": boolean(x < y), "gt": boolean(x > y),
+			}[c.op])
+		case "neg":
+			push(-pop())
+		case "not":
+			push(^pop())
+		case "label":
+		case "goto", "if-goto":
+			if c.op == "if-goto" && pop() == 0 {
+				break
+			}
+			target, err := jump(c.arg)
+			if err != nil {
+				return 0, err
+			}
+			if target == pc-2 {
+				// A label followed by a jump to it halts.
+				return ram[temp], nil
+			}
+			pc = target
+		case "function":
+			for i := 0; i < c.n; i++ {
+				push(0)
+			}
+		case "call":
+			target, err := jump(c.arg)
+			if err != nil {
+				return 0, err
+			}
+			push(int16(pc))
+			push(ram[lcl])
+			push(ram[arg])
+			push(ram[this])
+			push(ram[that])
+			ram[arg] = ram[sp] - int16(c.n) - 5
+			ram[lcl] = ram[sp]
+			pc = target
+		case "return":
+			frame := ram[lcl]
+			ret := ram[frame-5]
+			ram[ram[arg]] = pop()
+			ram[sp] = ram[arg] + 1
+			ram[that], ram[this] = ram[frame-1], ram[frame-2]
+			ram[arg], ram[lcl] = ram[frame-3], ram[frame-4]
+			pc = int(ret)
+		default:
+			return 0, fmt.Errorf("bad command %q", c.op)
+		}
+	}
+	return 0, fmt.Errorf("program did not halt")
+}
+
+// runAsm assembles and runs Hack assembly until it reaches a jump to
+// itself, returning the value it left in temp 0. Computations are
+// accepted with their operands in either order, such as M+D, as
+// hackvmt writes them.
+func runAsm(src []byte) (int16, error) {
+	symbols := map[string]int{
+		"SP": 0, "LCL": 1, "ARG": 2, "THIS": 3, "THAT": 4,
+		"SCREEN": 16384, "KBD": 24576,
+	}
+	for i := 0; i < 16; i++ {
+		symbols[fmt.Sprint("R", i)] = i
+	}
+	var code []string
+	s := bufio.NewScanner(bytes.NewReader(src))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "(") && strings.HasSuffix(line, ")"):
+			symbols[line[1:len(line)-1]] = len(code)
+		default:
+			code = append(code, line)
+		}
+	}
+	next := 16
+	for i, line := range code {
+		if !strings.HasPrefix(line, "@") {
+			continue
+		}
+		name := line[1:]
+		if _, err := strconv.Atoi(name); err == nil {
+			continue
+		}
+		if _, ok := symbols[name]; !ok {
+			symbols[name] = next
+			next++
+		}
+		code[i] = fmt.Sprint("@", symbols[name])
+	}
+
+	var (
+		ram  [32768]int16
+		a, d int16
+		pc   int
+	)
+	operand := func(s string) (int16, error) {
+		switch s {
+		case "0":
+			return 0, nil
+		case "1":
+			return 1, nil
+		case "A":
+			return a, nil
+		case "D":
+			return d, nil
+		case "M":
+			return ram[uint16(a)%32768], nil
+		}
+		return 0, fmt.Errorf("bad operand %q", s)
+	}
+	comp := func(s string) (int16, error) {
+		if i := strings.IndexAny(s[1:], "+-&|"); i >= 0 {
+			x, err := operand(s[:i+1])
+			if err != nil {
+				return 0, err
+			}
+			y, err := operand(s[i+2:])
+			if err != nil {
+				return 0, err
+			}
+			switch s[i+1] {
+			case '+':
+				return x + y, nil
+			case '-':
+				return x - y, nil
+			case '&':
+				return x & y, nil
+			default:
+				return x | y, nil
+			}
+		}
+		switch s[0] {
+		case '-':
+			x, err := operand(s[1:])
+			return -x, err
+		case '!':
+			x, err := operand(s[1:])
+			return ^x, err
+		}
+		return operand(s)
+	}
+
+	ram[0] = 256
+	for steps := 0; steps < 100000000; steps++ {
+		if pc < 0 || pc >= len(code) {
+			return 0, fmt.Errorf("pc %d out of the program", pc)
+		}
+		line := code[pc]
+		if strings.HasPrefix(line, "@") {
+			n, err := strconv.Atoi(line[1:])
+			if err != nil || n < 0 || n > 32767 {
+				return 0, fmt.Errorf("bad instruction %q", line)
+			}
+			a = int16(n)
+			pc++
+			continue
+		}
+		dest, jump := "", ""
+		if i := strings.Index(line, "="); i >= 0 {
+			dest, line = line[:i], line[i+1:]
+		}
+		if i := strings.Index(line, ";"); i >= 0 {
+			line, jump = line[:i], line[i+1:]
+		}
+		v, err := comp(line)
+		if err != nil {
+			return 0, err
+		}
+		// M is the memory addressed by A before the instruction.
+		m := uint16(a) % 32768
+		if strings.Contains(dest, "M") {
+			ram[m] = v
+		}
+		if strings.Contains(dest, "A") {
+			a = v
+		}
+		if strings.Contains(dest, "D") {
+			d = v
+		}
+		jumps := map[string]bool{
+			"":    false,
+			"JGT": v > 0, "JEQ": v == 0, "JGE": v >= 0,
+			"JLT": v < 0, "JNE": v != 0, "JLE": v <= 0, "JMP": true,
+		}
+		taken, ok := jumps[jump]
+		if !ok {
+			return 0, fmt.Errorf("bad jump %q", jump)
+		}
+		if !taken {
+			pc++
+			continue
+		}
+		if int(a) == pc-1 && code[pc-1] == fmt.Sprint("@", pc-1) {
+			// Loading the address of the jump and jumping
+			// there halts.
+			return ram[5], nil
+		}
+		pc = int(a)
+	}
+	return 0, fmt.Errorf("program did not halt")
+}
@@ -0,0 +1,677 @@
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// printer holds the state shared by the printers of the targets.
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+// line prints a line at the current indentation.
+func (p *printer) line(format string, args ...interface{}) {
+	p.buf.WriteString(strings.Repeat("\t", p.indent))
+	fmt.Fprintf(&p.buf, format, args...)
+	p.buf.WriteByte('\n')
+}
+
+func printJS(prog *program) []byte {
+	p := &jsPrinter{}
+	p.buf.WriteString(jsRuntime)
+	p.line("")
+	p.line("function program() {")
+	p.indent++
+	p.decl(prog.vars)
+	p.stmts(prog.body)
+	if !returns(prog.body) {
+		p.line("return %s;", p.expr(prog.result))
+	}
+	p.indent--
+	p.line("}")
+	p.line("")
+	p.line("main(program);")
+	return p.buf.Bytes()
+}
+
+type jsPrinter struct {
+	printer
+}
+
+func (p *jsPrinter) decl(names []string) {
+	if len(names) > 0 {
+		p.line("let %s;", strings.Join(names, ", "))
+	}
+}
+
+func (p *jsPrinter) stmts(stmts []stmt) {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case declStmt:
+			p.decl(s.names)
+		case defStmt:
+			p.line("const %s = %s;", s.name, p.expr(s.value))
+		case setStmt:
+			p.line("%s = %s;", s.name, p.expr(s.value))
+		case ifStmt:
+			p.line("if (truthy(%s)) {", p.expr(s.test))
+			p.indent++
+			p.stmts(s.then)
+			p.indent--
+			p.line("} else {")
+			p.indent++
+			p.stmts(s.or)
+			p.indent--
+			p.line("}")
+		case retStmt:
+			p.line("return %s;", p.expr(s.value))
+		case exprStmt:
+			p.line("%s;", p.expr(s.value))
+		}
+	}
+}
+
+func (p *jsPrinter) expr(e expr) string {
+	switch e := e.(type) {
+	case varRef:
+		return string(e)
+	case intLit:
+		return strconv.FormatInt(int64(e), 10) + "n"
+	case bigLit:
+		return string(e) + "n"
+	case count:
+		return strconv.Itoa(int(e))
+	case strLit:
+		return jsString(string(e))
+	case boolLit:
+		return strconv.FormatBool(bool(e))
+	case nullLit:
+		return "null"
+	case builtinRef:
+		return "builtins." + string(e)
+	case rtCall:
+		args := make([]string, len(e.args))
+		for i, a := range e.args {
+			args[i] = p.expr(a)
+		}
+		return e.fn + "(" + strings.Join(args, ", ") + ")"
+	case fnLit:
+		return p.function(e)
+	}
+	panic(fmt.Sprintf("transpile: unexpected expression %T", e))
+}
+
+func (p *jsPrinter) function(fn fnLit) string {
+	// Functions are printed by a printer of their own, indented
+	// like the statement holding them.
+	f := &jsPrinter{printer{indent: p.indent + 1}}
+	f.line("arity(args, %d, %d);", fn.min, fn.max)
+	f.decl(fn.vars)
+	for i, par := range fn.params {
+		if par.value == nil {
+			f.line("%s = args[%d];", par.name, i)
+			continue
+		}
+		f.line("if (args.length > %d) {", i)
+		f.line("\t%s = args[%d];", par.name, i)
+		f.line("} else {")
+		f.indent++
+		f.stmts(par.def)
+		f.line("%s = %s;", par.name, f.expr(par.value))
+		f.indent--
+		f.line("}")
+	}
+	if fn.rest != "" {
+		f.line("%s = args.slice(%d);", fn.rest, len(fn.params))
+	}
+	f.stmts(fn.body)
+	if !returns(fn.body) {
+		f.line("return %s;", f.expr(fn.result))
+	}
+	return fmt.Sprintf("new Fn(%s, function (...args) {\n%s%s})",
+		jsString(fn.src), f.buf.String(), strings.Repeat("\t", p.indent))
+}
+
+// jsString quotes s as a JavaScript string literal.
+func jsString(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r < 0x20 || r == 0x7f || r == 0x2028 || r == 0x2029:
+			fmt.Fprintf(&buf, "\\u%04x", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// jsRuntime implements Monkey values for JavaScript programs. Integers
+// are BigInts, null is null and unset variables are undefined.
+const jsRuntime = `"use strict";
+
+class MonkeyError extends Error {}
+
+function fail(msg) {
+	throw new MonkeyError(msg);
+}
+
+class Hash {
+	constructor() {
+		this.index = new Map();
+		this.keys = [];
+		this.values = [];
+	}
+	set(k, v) {
+		const key = keyOf(k);
+		const i = this.index.get(key);
+		if (i !== undefined) {
+			this.values[i] = v;
+			return;
+		}
+		this.index.set(key, this.keys.length);
+		this.keys.push(k);
+		this.values.push(v);
+	}
+	get(k) {
+		const i = this.index.get(keyOf(k));
+		return i === undefined ? undefined : this.values[i];
+	}
+}
+
+class Fn {
+	constructor(src, call) {
+		this.src = src;
+		this.call = call;
+	}
+}
+
+class Builtin {
+	constructor(name, call) {
+		this.name = name;
+		this.call = call;
+	}
+}
+
+function typeName(v) {
+	switch (typeof v) {
+	case "bigint":
+		return "Integer";
+	case "string":
+		return "String";
+	case "boolean":
+		return "Boolean";
+	}
+	if (v === null) {
+		return "Null";
+	}
+	if (Array.isArray(v)) {
+		return "Array";
+	}
+	if (v instanceof Hash) {
+		return "Hash";
+	}
+	if (v instanceof Fn) {
+		return "Function";
+	}
+	if (v instanceof Builtin) {
+		return "Builtin";
+	}
+	return typeof v;
+}
+
+// quote quotes a string like Go's strconv.Quote.
+function quote(s) {
+	let q = '"';
+	for (const c of s) {
+		const code = c.codePointAt(0);
+		switch (c) {
+		case '"':
+			q += '\\"';
+			break;
+		case "\\":
+			q += "\\\\";
+			break;
+		case "\x07":
+			q += "\\a";
+			break;
+		case "\b":
+			q += "\\b";
+			break;
+		case "\f":
+			q += "\\f";
+			break;
+		case "\n":
+			q += "\\n";
+			break;
+		case "\r":
+			q += "\\r";
+			break;
+		case "\t":
+			q += "\\t";
+			break;
+		case "\v":
+			q += "\\v";
+			break;
+		default:
+			if (code < 0x20 || code === 0x7f) {
+				q += "\\x" + code.toString(16).padStart(2, "0");
+			} else {
+				q += c;
+			}
+		}
+	}
+	return q + '"';
+}
+
+function inspect(v) {
+	switch (typeName(v)) {
+	case "Integer":
+		return v.toString();
+	case "String":
+		return quote(v);
+	case "Boolean":
+		return v ? "true" : "false";
+	case "Null":
+		return "null";
+	case "Array":
+		return "[" + v.map(inspect).join(", ") + "]";
+	case "Hash":
+		return "{" + v.keys.map((k, i) => inspect(k) + ": " + inspect(v.values[i])).join(", ") + "}";
+	case "Function":
+		return v.src;
+	case "Builtin":
+		return "builtin function";
+	}
+	return String(v);
+}
+
+function tostr(v) {
+	return typeof v === "string" ? v : inspect(v);
+}
+
+// keyOf returns a string identifying hash keys, keys are equal when
+// they are structurally equal.
+function keyOf(v) {
+	switch (typeName(v)) {
+	case "Integer":
+		return "i" + v.toString();
+	case "String":
+		return "s" + quote(v);
+	case "Boolean":
+		return "b" + v;
+	case "Array":
+		return "a[" + v.map(keyOf).join(",") + "]";
+	case "Hash":
+		return "h{" + v.keys.map((k, i) => keyOf(k) + "=" + keyOf(v.values[i])).sort().join(",") + "}";
+	}
+	fail("unhashable type " + typeName(v) + " can not be used as a hash key");
+}
+
+function equal(a, b) {
+	if (typeName(a) !== typeName(b)) {
+		return false;
+	}
+	switch (typeName(a)) {
+	case "Array":
+		return a.length === b.length && a.every((e, i) => equal(e, b[i]));
+	case "Hash":
+		return a.keys.length === b.keys.length && a.keys.every((k, i) => {
+			const v = b.get(k);
+			return v !== undefined && equal(a.values[i], v);
+		});
+	}
+	return a === b;
+}
+
+function truthy(v) {
+	return v === null ? false : typeof v === "boolean" ? v : true;
+}
+
+function infix(op, l, r) {
+	if (typeName(l) !== typeName(r)) {
+		fail("type mismatch: " + typeName(l) + " " + op + " " + typeName(r));
+	}
+	switch (typeName(l)) {
+	case "Integer":
+		switch (op) {
+		case "+":
+			return l + r;
+		case "-":
+			return l - r;
+		case "*":
+			return l * r;
+		case "/":
+			if (r === 0n) {
+				fail("division by zero");
+			}
+			return l / r;
+		case "<":
+			return l < r;
+		case ">":
+			return l > r;
+		case "==":
+			return l === r;
+		case "!=":
+			return l !== r;
+		}
+		break;
+	case "String":
+		if (op === "+") {
+			return l + r;
+		}
+		break;
+	default:
+		switch (op) {
+		case "==":
+			return equal(l, r);
+		case "!=":
+			return !equal(l, r);
+		}
+	}
+	fail("bad operation: " + typeName(l) + " " + op + " " + typeName(r));
+}
+
+function not(v) {
+	return typeof v === "boolean" ? !v : v === null;
+}
+
+function neg(v) {
+	if (typeof v !== "bigint") {
+		fail("bad operator: -" + typeName(v));
+	}
+	return -v;
+}
+
+function index(l, i) {
+	if (Array.isArray(l) && typeof i === "bigint") {
+		return i < 0n || i >= BigInt(l.length) ? null : l[Number(i)];
+	}
+	if (typeof l === "string" && typeof i === "bigint") {
+		const chars = Array.from(l);
+		return i < 0n || i >= BigInt(chars.length) ? null : chars[Number(i)];
+	}
+	if (l instanceof Hash) {
+		const v = l.get(i);
+		return v === undefined ? null : v;
+	}
+	fail("bad index operator on type " + typeName(l));
+}
+
+function call(fn, ...args) {
+	if (fn instanceof Fn || fn instanceof Builtin) {
+		return fn.call(...args);
+	}
+	fail("bad fn call, " + typeName(fn) + " is not a function");
+}
+
+function apply(fn, args) {
+	return call(fn, ...args);
+}
+
+function arity(args, min, max) {
+	const got = args.length;
+	if (max < 0 && got < min) {
+		fail("bad number of arguments " + got + " to fn which expects at least " + min);
+	} else if (max >= 0 && min === max && got !== min) {
+		fail("bad number of arguments " + got + " to fn which expects " + min);
+	} else if (max >= 0 && (got < min || got > max)) {
+		fail("bad number of arguments " + got + " to fn which expects " + min + " to " + max);
+	}
+}
+
+function array(...elems) {
+	return elems;
+}
+
+function concat(...parts) {
+	return [].concat(...parts);
+}
+
+function spread(v) {
+	if (!Array.isArray(v)) {
+		fail("can not spread value of type " + typeName(v));
+	}
+	return v;
+}
+
+// protocols are the keys binding the protocols of hashes, which are
+// not implemented.
+const protocols = new Set(["__add__", "__eq__", "__lt__", "__str__", "__hash__", "__len__", "__index__"]);
+
+function hash(...pairs) {
+	const h = new Hash();
+	for (let i = 0; i < pairs.length; i += 2) {
+		if (protocols.has(pairs[i])) {
+			fail("protocol " + pairs[i] + " is not supported");
+		}
+		h.set(pairs[i], pairs[i + 1]);
+	}
+	return h;
+}
+
+function template(...parts) {
+	return parts.map(tostr).join("");
+}
+
+function get(v, name) {
+	if (v === undefined) {
+		unbound(name);
+	}
+	return v;
+}
+
+function unbound(name) {
+	fail("unbound identifier: " + name);
+}
+
+function destructArray(v, n, rest) {
+	if (!Array.isArray(v)) {
+		fail("can not destructure " + typeName(v) + " as an array");
+	}
+	const parts = [];
+	for (let i = 0; i < n; i++) {
+		parts.push(i < v.length ? v[i] : null);
+	}
+	if (rest) {
+		parts.push(v.slice(n));
+	}
+	return parts;
+}
+
+function destructHash(v, ...keys) {
+	if (!(v instanceof Hash)) {
+		fail("can not destructure " + typeName(v) + " as a hash");
+	}
+	return keys.map((k) => {
+		const e = v.get(k);
+		return e === undefined ? null : e;
+	});
+}
+
+function at(v, i) {
+	return v[i];
+}
+
+function nargs(name, want, args) {
+	if (args.length !== want) {
+		fail("bad number of arguments " + args.length + " to builtin '" + name + "' which expects " + want);
+	}
+}
+
+function badArg(name, v) {
+	fail("bad argument type " + typeName(v) + " for bultin in '" + name + "'");
+}
+
+// arrayArg returns the single array argument of builtin name.
+function arrayArg(name, args) {
+	nargs(name, 1, args);
+	if (!Array.isArray(args[0])) {
+		badArg(name, args[0]);
+	}
+	return args[0];
+}
+
+// hashArg returns the single hash argument of builtin name.
+function hashArg(name, args) {
+	nargs(name, 1, args);
+	if (!(args[0] instanceof Hash)) {
+		badArg(name, args[0]);
+	}
+	return args[0];
+}
+
+// sprintf implements the common verbs of Go's fmt.Sprintf along with
+// widths and the - and 0 flags.
+function sprintf(format, args) {
+	let out = "";
+	let n = 0;
+	for (let i = 0; i < format.length; i++) {
+		const c = format[i];
+		if (c !== "%") {
+			out += c;
+			continue;
+		}
+		let flags = "";
+		while (format[i + 1] === "-" || format[i + 1] === "0") {
+			flags += format[++i];
+		}
+		let width = "";
+		while (format[i + 1] >= "0" && format[i + 1] <= "9") {
+			width += format[++i];
+		}
+		const verb = format[++i];
+		if (verb === "%") {
+			out += "%";
+			continue;
+		}
+		if (n >= args.length) {
+			out += "%!" + verb + "(MISSING)";
+			continue;
+		}
+		const v = args[n++];
+		let s;
+		switch (verb) {
+		case "v":
+		case "s":
+		case "d":
+		case "t":
+			s = tostr(v);
+			break;
+		case "q":
+			s = typeof v === "string" ? quote(v) : tostr(v);
+			break;
+		case "x":
+			s = typeof v === "bigint" ? v.toString(16) : tostr(v);
+			break;
+		default:
+			out += "%!" + verb + "(" + tostr(v) + ")";
+			continue;
+		}
+		out += pad(s, Number(width), flags, typeof v === "bigint");
+	}
+	if (n < args.length) {
+		out += "%!(EXTRA " + args.slice(n).map((v) => {
+			const t = {Integer: "int64", String: "string", Boolean: "bool"}[typeName(v)] || "string";
+			return t + "=" + tostr(v);
+		}).join(", ") + ")";
+	}
+	return out;
+}
+
+// pad pads s to width with spaces, or with zeros following the sign
+// of a number for the 0 flag. The - flag pads on the right.
+function pad(s, width, flags, number) {
+	const fill = width - [...s].length;
+	if (fill <= 0) {
+		return s;
+	}
+	if (flags.includes("-")) {
+		return s + " ".repeat(fill);
+	}
+	if (flags.includes("0")) {
+		const sign = number && s[0] === "-" ? "-" : "";
+		return sign + "0".repeat(fill) + s.slice(sign.length);
+	}
+	return " ".repeat(fill) + s;
+}
+
+const builtins = {
+	len: new Builtin("len", (...args) => {
+		nargs("len", 1, args);
+		if (typeof args[0] === "string") {
+			return BigInt([...args[0]].length);
+		}
+		if (Array.isArray(args[0])) {
+			return BigInt(args[0].length);
+		}
+		if (args[0] instanceof Hash) {
+			return BigInt(args[0].keys.length);
+		}
+		badArg("len", args[0]);
+	}),
+	first: new Builtin("first", (...args) => {
+		const arr = arrayArg("first", args);
+		return arr.length > 0 ? arr[0] : null;
+	}),
+	last: new Builtin("last", (...args) => {
+		const arr = arrayArg("last", args);
+		return arr.length > 0 ? arr[arr.length - 1] : null;
+	}),
+	rest: new Builtin("rest", (...args) => {
+		const arr = arrayArg("rest", args);
+		return arr.length > 0 ? arr.slice(1) : null;
+	}),
+	push: new Builtin("push", (...args) => {
+		nargs("push", 2, args);
+		if (!Array.isArray(args[0])) {
+			badArg("push", args[0]);
+		}
+		return args[0].concat([args[1]]);
+	}),
+	keys: new Builtin("keys", (...args) => hashArg("keys", args).keys.slice()),
+	values: new Builtin("values", (...args) => hashArg("values", args).values.slice()),
+	str: new Builtin("str", (...args) => {
+		nargs("str", 1, args);
+		return tostr(args[0]);
+	}),
+	format: new Builtin("format", (...args) => {
+		if (args.length < 1) {
+			fail("bad number of arguments " + args.length + " to builtin 'format' which expects 1");
+		}
+		if (typeof args[0] !== "string") {
+			badArg("format", args[0]);
+		}
+		return sprintf(args[0], args.slice(1));
+	}),
+	puts: new Builtin("puts", (...args) => {
+		for (const arg of args) {
+			console.log(inspect(arg));
+		}
+		return null;
+	}),
+};
+
+// main runs program, errors are printed and end the process with
+// status 1.
+function main(program) {
+	try {
+		program();
+	} catch (e) {
+		if (!(e instanceof MonkeyError)) {
+			throw e;
+		}
+		console.error(e.message);
+		process.exitCode = 1;
+	}
+}
+`
@@ -0,0 +1,624 @@
+// Package transpile translates Monkey programs to the source of
+// equivalent JavaScript or Go programs, so Monkey code can run where
+// the interpreter is not available.
+//
+// Programs are first lowered to a small intermediate form where every
+// intermediate value is held by a temporary and if expressions become
+// statements, which keeps the evaluation order of the interpreter.
+// Each target then prints that form along with a runtime implementing
+// the semantics of the object package: truthiness, operators, hash
+// keys, errors and the builtins.
+//
+// Only the core language is supported. Programs using select, match,
+// modules, builtins other than those of the runtime or hashes binding
+// protocols such as __add__ are rejected with an Unsupported error.
+// The runtimes fail on hashes binding protocols under computed keys.
+//
+// Hack compiles a smaller subset of the language to the stack based
+// VM code of the Hack platform from nand2tetris.
+package transpile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/evaluator"
+	"github.com/emb/play/monkey/token"
+)
+
+// Unsupported is an error returned for a program using a feature the
+// targets do not implement.
+type Unsupported struct {
+	Pos  token.Position
+	What string
+}
+
+// Error returns a string describing the error
+func (u Unsupported) Error() string {
+	return fmt.Sprintf("%s: %s is not supported", u.Pos, u.What)
+}
+
+// runtimeBuiltins are the builtins implemented by the runtime of
+// every target.
+var runtimeBuiltins = map[string]bool{
+	"len":    true,
+	"first":  true,
+	"last":   true,
+	"rest":   true,
+	"push":   true,
+	"keys":   true,
+	"values": true,
+	"str":    true,
+	"format": true,
+	"puts":   true,
+}
+
+// protocols are the keys binding the protocols of hashes, which only
+// the evaluator implements.
+var protocols = map[string]bool{
+	"__add__":   true,
+	"__eq__":    true,
+	"__lt__":    true,
+	"__str__":   true,
+	"__hash__":  true,
+	"__len__":   true,
+	"__index__": true,
+}
+
+// JS returns the source of a JavaScript program, for node, equivalent
+// to program.
+func JS(program *ast.Program) ([]byte, error) {
+	p, err := lower(program)
+	if err != nil {
+		return nil, err
+	}
+	return printJS(p), nil
+}
+
+// Go returns the source of a Go main package equivalent to program.
+func Go(program *ast.Program) ([]byte, error) {
+	p, err := lower(program)
+	if err != nil {
+		return nil, err
+	}
+	return printGo(p), nil
+}
+
+// The intermediate form. Statements and expressions are printed as
+// they are by the targets, variables are named after the Monkey
+// identifiers with a "m_" prefix and temporaries with a "_t" prefix.
+type (
+	stmt interface{}
+	expr interface{}
+
+	// declStmt introduces variables which are unset until
+	// assigned.
+	declStmt struct{ names []string }
+	// defStmt introduces a temporary holding value.
+	defStmt struct {
+		name  string
+		value expr
+	}
+	setStmt struct {
+		name  string
+		value expr
+	}
+	ifStmt struct {
+		test     expr
+		then, or []stmt
+	}
+	retStmt  struct{ value expr }
+	exprStmt struct{ value expr }
+
+	varRef string
+	intLit int64
+	// bigLit holds the digits of an integer literal not fitting in
+	// an intLit.
+	bigLit string
+	// count is a Go int, or a JavaScript number, given to the
+	// runtime.
+	count      int
+	strLit     string
+	boolLit    bool
+	nullLit    struct{}
+	builtinRef string
+	// rtCall calls the runtime function fn.
+	rtCall struct {
+		fn   string
+		args []expr
+	}
+	fnLit struct {
+		// src is how the function is displayed.
+		src      string
+		min, max int
+		params   []param
+		rest     string
+		vars     []string
+		body     []stmt
+		result   expr
+	}
+)
+
+// param is a parameter of a function, value is nil for required
+// parameters, otherwise def computes value when the argument is
+// missing.
+type param struct {
+	name  string
+	def   []stmt
+	value expr
+}
+
+// program is the lowered form of a Monkey program.
+type program struct {
+	vars   []string
+	body   []stmt
+	result expr
+}
+
+// frame holds the variables of a function, or of a block outside of
+// functions, by the slot the parser resolved for them.
+type frame struct {
+	slots []string
+	outer *frame
+}
+
+type lowerer struct {
+	frame *frame
+	// globals are bound by the let statements of the program.
+	globals map[string]bool
+	// vars holds the variables of the program, globals and those
+	// of blocks outside of functions.
+	vars  []string
+	temps int
+}
+
+func lower(p *ast.Program) (*program, error) {
+	l := lowerer{globals: map[string]bool{}}
+	for _, st := range p.Statements {
+		if let, ok := st.(*ast.LetStmt); ok {
+			for _, i := range bound(let) {
+				l.globals[i.Value] = true
+				l.vars = append(l.vars, "m_"+i.Value)
+			}
+		}
+	}
+	var body []stmt
+	result, err := l.stmts(p.Statements, &body)
+	if err != nil {
+		return nil, err
+	}
+	return &program{vars: l.vars, body: body, result: result}, nil
+}
+
+// bound returns the identifiers bound by a let statement.
+func bound(let *ast.LetStmt) []*ast.Identifier {
+	if let.Pattern != nil {
+		return let.Pattern.Names()
+	}
+	return []*ast.Identifier{let.Name}
+}
+
+// open starts a frame of size slots holding params and the let
+// bindings of body, outside of the functions it holds. Variables are
+// named after the identifiers they bind, shadowed names are numbered
+// so that each one is distinct from the variables in scope.
+func (l *lowerer) open(size int, params []*ast.Identifier, body ast.Node) {
+	f := &frame{slots: make([]string, size), outer: l.frame}
+	l.frame = f
+	name := func(i *ast.Identifier) {
+		if f.slots[i.Slot] != "" {
+			return
+		}
+		v := "m_" + i.Value
+		for n := 2; l.taken(v); n++ {
+			v = fmt.Sprintf("m_%s_%d", i.Value, n)
+		}
+		f.slots[i.Slot] = v
+	}
+	for _, p := range params {
+		name(p)
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStmt:
+			for _, i := range bound(n) {
+				name(i)
+			}
+		}
+		return true
+	})
+}
+
+// taken reports whether a variable v is in scope.
+func (l *lowerer) taken(v string) bool {
+	for f := l.frame; f != nil; f = f.outer {
+		for _, s := range f.slots {
+			if s == v {
+				return true
+			}
+		}
+	}
+	for _, s := range l.vars {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// close ends the current frame returning the names of its variables.
+func (l *lowerer) close() []string {
+	var vars []string
+	for _, v := range l.frame.slots {
+		if v != "" {
+			vars = append(vars, v)
+		}
+	}
+	l.frame = l.frame.outer
+	return vars
+}
+
+// variable returns the variable holding the binding of i.
+func (l *lowerer) variable(i *ast.Identifier) string {
+	if !i.Local {
+		return "m_" + i.Value
+	}
+	f := l.frame
+	for d := i.Depth; d > 0; d-- {
+		f = f.outer
+	}
+	return f.slots[i.Slot]
+}
+
+func (l *lowerer) temp() string {
+	l.temps++
+	return fmt.Sprintf("_t%d", l.temps)
+}
+
+// spill holds the result of e in a new temporary.
+func (l *lowerer) spill(out *[]stmt, e expr) expr {
+	t := l.temp()
+	*out = append(*out, defStmt{name: t, value: e})
+	return varRef(t)
+}
+
+// stmts lowers a list of statements to out, returning the value of the
+// last one.
+func (l *lowerer) stmts(stmts []ast.Statement, out *[]stmt) (expr, error) {
+	var result expr = nullLit{}
+	for i, st := range stmts {
+		result = nullLit{}
+		switch st := st.(type) {
+		case *ast.LetStmt:
+			if err := l.let(st, out); err != nil {
+				return nil, err
+			}
+		case *ast.ReturnStmt:
+			v, err := l.expr(st.Value, out)
+			if err != nil {
+				return nil, err
+			}
+			// The statements following a return are never run.
+			*out = append(*out, retStmt{value: v})
+			return result, nil
+		case *ast.ExpressionStmt:
+			v, err := l.expr(st.Expression, out)
+			if err != nil {
+				return nil, err
+			}
+			if i < len(stmts)-1 {
+				*out = append(*out, exprStmt{value: v})
+			} else {
+				result = v
+			}
+		default:
+			return nil, fmt.Errorf("transpile: unexpected statement %T", st)
+		}
+	}
+	return result, nil
+}
+
+func (l *lowerer) let(st *ast.LetStmt, out *[]stmt) error {
+	v, err := l.expr(st.Value, out)
+	if err != nil {
+		return err
+	}
+	switch p := st.Pattern.(type) {
+	case nil:
+		*out = append(*out, setStmt{name: l.variable(st.Name), value: v})
+		return nil
+	case *ast.ArrayPattern:
+		v = l.spill(out, rtCall{"destructArray", []expr{
+			v, count(len(p.Elements)), boolLit(p.Rest != nil)}})
+	case *ast.HashPattern:
+		args := []expr{v}
+		for _, k := range p.Keys {
+			args = append(args, strLit(k.Value))
+		}
+		v = l.spill(out, rtCall{"destructHash", args})
+	}
+	for i, name := range st.Pattern.Names() {
+		*out = append(*out, setStmt{
+			name:  l.variable(name),
+			value: rtCall{"at", []expr{v, count(i)}},
+		})
+	}
+	return nil
+}
+
+// block lowers the statements of b, the variables of a block outside of
+// functions are variables of the program.
+func (l *lowerer) block(b *ast.BlockStmt, out *[]stmt) (expr, error) {
+	if b.Locals == 0 {
+		return l.stmts(b.Statements, out)
+	}
+	l.open(b.Locals, nil, b)
+	v, err := l.stmts(b.Statements, out)
+	l.vars = append(l.vars, l.close()...)
+	return v, err
+}
+
+// returns reports whether body ends with a return statement.
+func returns(body []stmt) bool {
+	if len(body) == 0 {
+		return false
+	}
+	_, ok := body[len(body)-1].(retStmt)
+	return ok
+}
+
+// expr lowers e to out, returning an expression without side effects
+// holding its value.
+func (l *lowerer) expr(e ast.Expression, out *[]stmt) (expr, error) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		if e.Big != nil {
+			return bigLit(e.Big.String()), nil
+		}
+		return intLit(e.Value), nil
+	case *ast.StringLiteral:
+		return strLit(e.Value), nil
+	case *ast.Boolean:
+		return boolLit(e.Value), nil
+	case *ast.TemplateLiteral:
+		parts, err := l.exprs(e.Parts, out)
+		if err != nil {
+			return nil, err
+		}
+		return l.spill(out, rtCall{"template", parts}), nil
+	case *ast.ArrayLiteral:
+		arr, err := l.list(e.Elements, out)
+		if err != nil {
+			return nil, err
+		}
+		return l.spill(out, arr), nil
+	case *ast.HashLiteral:
+		var pairs []expr
+		for _, p := range e.Pairs {
+			if s, ok := p.Key.(*ast.StringLiteral); ok && protocols[s.Value] {
+				return nil, Unsupported{Pos: s.Token.Pos, What: "protocol " + s.Value}
+			}
+			k, err := l.expr(p.Key, out)
+			if err != nil {
+				return nil, err
+			}
+			v, err := l.expr(p.Value, out)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, k, v)
+		}
+		return l.spill(out, rtCall{"hash", pairs}), nil
+	case *ast.Identifier:
+		return l.ident(e, out)
+	case *ast.PrefixExpr:
+		r, err := l.expr(e.Right, out)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Operator {
+		case token.BANG:
+			return l.spill(out, rtCall{"not", []expr{r}}), nil
+		case token.MINUS:
+			return l.spill(out, rtCall{"neg", []expr{r}}), nil
+		}
+		return nil, Unsupported{Pos: e.Token.Pos, What: "operator " + e.Operator}
+	case *ast.InfixExpr:
+		left, err := l.expr(e.Left, out)
+		if err != nil {
+			return nil, err
+		}
+		right, err := l.expr(e.Right, out)
+		if err != nil {
+			return nil, err
+		}
+		return l.spill(out, rtCall{"infix", []expr{strLit(e.Operator), left, right}}), nil
+	case *ast.IfExpr:
+		return l.ifExpr(e, out)
+	case *ast.FunctionLiteral:
+		return l.function(e)
+	case *ast.CallExpr:
+		fn, err := l.expr(e.Function, out)
+		if err != nil {
+			return nil, err
+		}
+		args, err := l.list(e.Arguments, out)
+		if err != nil {
+			return nil, err
+		}
+		if args.fn == "array" {
+			return l.spill(out, rtCall{"call", append([]expr{fn}, args.args...)}), nil
+		}
+		return l.spill(out, rtCall{"apply", []expr{fn, l.spill(out, args)}}), nil
+	case *ast.IndexExpr:
+		left, err := l.expr(e.Left, out)
+		if err != nil {
+			return nil, err
+		}
+		index, err := l.expr(e.Index, out)
+		if err != nil {
+			return nil, err
+		}
+		return l.spill(out, rtCall{"index", []expr{left, index}}), nil
+	case *ast.SliceExpr:
+		return nil, Unsupported{Pos: e.Token.Pos, What: "slice"}
+	case *ast.SelectExpr:
+		return nil, Unsupported{Pos: e.Token.Pos, What: "select"}
+	case *ast.MatchExpr:
+		return nil, Unsupported{Pos: e.Token.Pos, What: "match"}
+	case *ast.MemberExpr:
+		// Modules are reported by name.
+		if _, err := l.expr(e.Left, out); err != nil {
+			return nil, err
+		}
+		return nil, Unsupported{Pos: e.Token.Pos, What: "member access"}
+	case *ast.SpreadExpr:
+		return nil, Unsupported{Pos: e.Token.Pos, What: "spread outside of a list"}
+	case *ast.YieldExpr:
+		return nil, Unsupported{Pos: e.Token.Pos, What: "yield"}
+	}
+	return nil, fmt.Errorf("transpile: unexpected expression %T", e)
+}
+
+func (l *lowerer) exprs(exps []ast.Expression, out *[]stmt) ([]expr, error) {
+	result := make([]expr, len(exps))
+	for i, e := range exps {
+		var err error
+		if result[i], err = l.expr(e, out); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// list lowers the elements of an array or the arguments of a call.
+// Without spread elements the result calls "array", otherwise
+// "concat" joins arrays of the plain elements with the spread ones.
+func (l *lowerer) list(exps []ast.Expression, out *[]stmt) (rtCall, error) {
+	var (
+		parts  []expr
+		plain  []expr
+		spread bool
+	)
+	for _, e := range exps {
+		s, ok := e.(*ast.SpreadExpr)
+		if !ok {
+			v, err := l.expr(e, out)
+			if err != nil {
+				return rtCall{}, err
+			}
+			plain = append(plain, v)
+			continue
+		}
+		v, err := l.expr(s.Value, out)
+		if err != nil {
+			return rtCall{}, err
+		}
+		if len(plain) > 0 {
+			parts = append(parts, l.spill(out, rtCall{"array", plain}))
+			plain = nil
+		}
+		parts = append(parts, l.spill(out, rtCall{"spread", []expr{v}}))
+		spread = true
+	}
+	if !spread {
+		return rtCall{"array", plain}, nil
+	}
+	if len(plain) > 0 {
+		parts = append(parts, l.spill(out, rtCall{"array", plain}))
+	}
+	return rtCall{"concat", parts}, nil
+}
+
+func (l *lowerer) ident(i *ast.Identifier, out *[]stmt) (expr, error) {
+	switch {
+	case i.Local || l.globals[i.Value]:
+		return l.spill(out, rtCall{"get", []expr{varRef(l.variable(i)), strLit(i.Value)}}), nil
+	case runtimeBuiltins[i.Value]:
+		return builtinRef(i.Value), nil
+	}
+	if _, ok := evaluator.Builtins()[i.Value]; ok {
+		return nil, Unsupported{Pos: i.Token.Pos, What: "builtin " + i.Value}
+	}
+	if _, ok := evaluator.Modules()[i.Value]; ok {
+		return nil, Unsupported{Pos: i.Token.Pos, What: "module " + i.Value}
+	}
+	return l.spill(out, rtCall{"unbound", []expr{strLit(i.Value)}}), nil
+}
+
+func (l *lowerer) ifExpr(e *ast.IfExpr, out *[]stmt) (expr, error) {
+	test, err := l.expr(e.Condition, out)
+	if err != nil {
+		return nil, err
+	}
+	t := l.temp()
+	*out = append(*out, declStmt{names: []string{t}})
+	branch := func(b *ast.BlockStmt) ([]stmt, error) {
+		var body []stmt
+		var v expr = nullLit{}
+		if b != nil {
+			var err error
+			if v, err = l.block(b, &body); err != nil {
+				return nil, err
+			}
+		}
+		if returns(body) {
+			return body, nil
+		}
+		return append(body, setStmt{name: t, value: v}), nil
+	}
+	then, err := branch(e.Consequence)
+	if err != nil {
+		return nil, err
+	}
+	or, err := branch(e.Alternative)
+	if err != nil {
+		return nil, err
+	}
+	*out = append(*out, ifStmt{test: test, then: then, or: or})
+	return varRef(t), nil
+}
+
+func (l *lowerer) function(e *ast.FunctionLiteral) (expr, error) {
+	src := ast.ParamStrings(e.Parameters, e.Defaults, e.Rest)
+	fn := fnLit{
+		src: "fn (" + strings.Join(src, ", ") + ")" + e.Body.String(),
+		min: len(e.Parameters),
+		max: len(e.Parameters),
+	}
+	for fn.min > 0 && fn.min <= len(e.Defaults) && e.Defaults[fn.min-1] != nil {
+		fn.min--
+	}
+	if e.Rest != nil {
+		fn.max = -1
+	}
+
+	params := e.Parameters
+	if e.Rest != nil {
+		params = append(params[:len(params):len(params)], e.Rest)
+	}
+	l.open(e.Locals, params, e.Body)
+	if e.Rest != nil {
+		fn.rest = l.variable(e.Rest)
+	}
+	for i, p := range e.Parameters {
+		par := param{name: l.variable(p)}
+		if i < len(e.Defaults) && e.Defaults[i] != nil {
+			var err error
+			if par.value, err = l.expr(e.Defaults[i], &par.def); err != nil {
+				return nil, err
+			}
+		}
+		fn.params = append(fn.params, par)
+	}
+	result, err := l.stmts(e.Body.Statements, &fn.body)
+	if err != nil {
+		return nil, err
+	}
+	fn.result = result
+	fn.vars = l.close()
+	return fn, nil
+}
@@ -0,0 +1,226 @@
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emb/play/monkey/ast"
+	"github.com/emb/play/monkey/evaluator"
+	"github.com/emb/play/monkey/evaluator/evaltest"
+	"github.com/emb/play/monkey/lexer"
+	"github.com/emb/play/monkey/object"
+	"github.com/emb/play/monkey/parser"
+)
+
+// rejected holds programs binding protocols under computed keys, which
+// the runtimes fail on, along with what they print.
+var rejected = []struct {
+	src  string
+	want string
+}{
+	{`let k = "__add__"; puts(1); {k: fn(a, b) { a }}`, "1\nprotocol __add__ is not supported\n"},
+	{`let k = "__" + "len__"; len({k: fn(h) { 1 }})`, "protocol __len__ is not supported\n"},
+}
+
+// untranslated lists the tables of features neither runtime implements,
+// whose programs the conformance test leaves out.
+var untranslated = map[string]bool{
+	"floats.txt":     true,
+	"generators.txt": true,
+	"iterators.txt":  true,
+	"json.txt":       true,
+	"match.txt":      true,
+	"regexp.txt":     true,
+	"tasks.txt":      true,
+	"time.txt":       true,
+}
+
+func TestConformance(t *testing.T) {
+	if os.Getenv("MONKEY_CONFORMANCE") == "" {
+		t.Skip("running programs with node and go is slow, set MONKEY_CONFORMANCE to run them")
+	}
+	tests, err := evaltest.LoadAll(evaltest.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		srcs     []string
+		programs []*ast.Program
+		want     []string
+	)
+	for _, tc := range tests {
+		if untranslated[strings.SplitN(tc.Pos, ":", 2)[0]] {
+			continue
+		}
+		program := printLast(parse(t, tc.Input))
+		srcs = append(srcs, tc.Input)
+		programs = append(programs, program)
+		want = append(want, evaluate(program))
+	}
+	for _, r := range rejected {
+		srcs = append(srcs, r.src)
+		programs = append(programs, printLast(parse(t, r.src)))
+		want = append(want, r.want)
+	}
+
+	t.Run("js", func(t *testing.T) {
+		if _, err := exec.LookPath("node"); err != nil {
+			t.Skip("node is not installed")
+		}
+		dir := t.TempDir()
+		for i, program := range programs {
+			src, err := JS(program)
+			if _, ok := err.(Unsupported); ok {
+				t.Logf("skipping %q: %v", srcs[i], err)
+				continue
+			} else if err != nil {
+				t.Fatalf("JS(%q): %v", srcs[i], err)
+			}
+			file := filepath.Join(dir, fmt.Sprintf("p%d.js", i))
+			if err := ioutil.WriteFile(file, src, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := output(exec.Command("node", file)); got != want[i] {
+				t.Errorf("%q printed %q with node, want %q", srcs[i], got, want[i])
+			}
+		}
+	})
+
+	t.Run("go", func(t *testing.T) {
+		if _, err := exec.LookPath("go"); err != nil {
+			t.Skip("go is not installed")
+		}
+		// Each program is a main package of a module, built
+		// together in one go.
+		dir := t.TempDir()
+		files := map[string][]byte{"go.mod": []byte("module conformance\n")}
+		for i, program := range programs {
+			src, err := Go(program)
+			if _, ok := err.(Unsupported); ok {
+				t.Logf("skipping %q: %v", srcs[i], err)
+				continue
+			} else if err != nil {
+				t.Fatalf("Go(%q): %v", srcs[i], err)
+			}
+			files[fmt.Sprintf("p%d/main.go", i)] = src
+		}
+		for name, data := range files {
+			file := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(file, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		build := exec.Command("go", "build", "-o", "bin"+string(filepath.Separator), "./...")
+		build.Dir = dir
+		build.Env = append(os.Environ(), "GO111MODULE=on", "GOFLAGS=-mod=mod", "GOTOOLCHAIN=local")
+		if out, err := build.CombinedOutput(); err != nil {
+			t.Fatalf("go build: %v\n%s", err, out)
+		}
+		for i := range programs {
+			bin := filepath.Join(dir, "bin", fmt.Sprintf("p%d", i))
+			if _, err := os.Stat(bin); os.IsNotExist(err) {
+				continue
+			}
+			if got := output(exec.Command(bin)); got != want[i] {
+				t.Errorf("%q printed %q with go, want %q", srcs[i], got, want[i])
+			}
+		}
+	})
+}
+
+func TestUnsupported(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"match 1 { _ => 2 }", "1:1: match is not supported"},
+		{"let c = chan(); select { }", "1:9: builtin chan is not supported"},
+		{"fn() { os.args }", "1:8: module os is not supported"},
+		{"let x = 1; x.y", "1:13: member access is not supported"},
+		{"[1, 2][1:]", "1:7: slice is not supported"},
+		{`{"x": 1, "__str__": fn(h) { "x" }}`, "1:10: protocol __str__ is not supported"},
+		{`let v = fn(x) { {"x": x, "__eq__": fn(a, b) { true }} }; v(1) == v(2)`, "1:26: protocol __eq__ is not supported"},
+	}
+	for _, tc := range tests {
+		program := parse(t, tc.input)
+		for name, build := range map[string]func(*ast.Program) ([]byte, error){"JS": JS, "Go": Go} {
+			_, err := build(program)
+			if _, ok := err.(Unsupported); !ok || err.Error() != tc.want {
+				t.Errorf("%s(%q) returned error %v, want %q", name, tc.input, err, tc.want)
+			}
+		}
+	}
+}
+
+func parse(t *testing.T, src string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(src))
+	program := p.Program()
+	if errs := p.Errors(); len(errs) != 0 {
+		t.Fatalf("parsing %q: %v", src, errs)
+	}
+	return program
+}
+
+// printLast makes program print the value of its last expression,
+// unless the expression returns from the program before having a
+// value.
+func printLast(program *ast.Program) *ast.Program {
+	last := program.Statements[len(program.Statements)-1]
+	if s, ok := last.(*ast.ExpressionStmt); ok && !hasReturn(s) {
+		s.Expression = &ast.CallExpr{
+			Function:  &ast.Identifier{Value: "puts"},
+			Arguments: []ast.Expression{s.Expression},
+		}
+	}
+	return program
+}
+
+// hasReturn reports whether a return statement outside of a function
+// is part of node.
+func hasReturn(node ast.Node) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.ReturnStmt:
+			found = true
+		case *ast.FunctionLiteral:
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
+// evaluate returns what the program prints when evaluated, followed
+// by its error if any.
+func evaluate(program *ast.Program) string {
+	var out bytes.Buffer
+	env := object.NewEnvironment()
+	env.SetRuntime(&evaluator.Runtime{Stdout: &out})
+	if _, err := evaluator.Eval(program, env); err != nil {
+		return out.String() + err.Error() + "\n"
+	}
+	return out.String()
+}
+
+// output returns what cmd prints to its standard output followed by
+// its standard error.
+func output(cmd *exec.Cmd) string {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil && stderr.Len() == 0 {
+		return stdout.String() + err.Error() + "\n"
+	}
+	return stdout.String() + stderr.String()
+}